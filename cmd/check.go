@@ -0,0 +1,72 @@
+/**************************
+File: check.go
+Author: Mingyi Lim
+Description: This file contains the check subcommand, which runs scripts and checks that their committed histories are serializable.
+***************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/history"
+)
+
+/*
+************
+Runs the check subcommand
+
+repcrec check [flags] <file>... runs each script without printing its output, then prints whether its committed history is serializable
+Exits with 1 if any history is not serializable
+************
+*/
+func check(args []string) int {
+	flags := flag.NewFlagSet("repcrec check", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "also print every dependency of the serialization graph")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: repcrec check [-v] <file>...")
+		return 2
+	}
+	exitCode := 0
+	for _, filename := range flags.Args() {
+		result, err := checkFile(filename)
+		if err != nil {
+			fmt.Printf("%s: %v\n", filename, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: %s\n", filename, result)
+		if *verbose {
+			for _, edge := range result.Edges {
+				fmt.Printf("  %s\n", edge)
+			}
+		}
+		if !result.Serializable() {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+/* Runs a script with its output discarded and checks the committed history */
+func checkFile(filename string) (history.Result, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return history.Result{}, err
+	}
+	defer file.Close()
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	err = internal.SimulationWithOptions(file, siteCoordinator, transactionManager, internal.Options{ContinueOnError: true, Output: io.Discard})
+	if _, isCommandErrors := err.(internal.CommandErrors); err != nil && !isCommandErrors {
+		return history.Result{}, err
+	}
+	return history.Check(transactionManager.History()), nil
+}
@@ -0,0 +1,57 @@
+/**************************
+File: gen.go
+Author: Mingyi Lim
+Description: This file contains the gen subcommand, which writes a random workload script. The script can be replayed with repcrec <file>.
+***************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mingyi850/repcrec/internal/workload"
+)
+
+/*
+************
+Runs the gen subcommand
+
+repcrec gen [flags] writes a random script to stdout, or to the file given by -o
+************
+*/
+func gen(args []string) int {
+	config := workload.DefaultConfig()
+	flags := flag.NewFlagSet("repcrec gen", flag.ExitOnError)
+	flags.Int64Var(&config.Seed, "seed", config.Seed, "seed of the random generator, the same seed and flags always generate the same script")
+	flags.IntVar(&config.Transactions, "transactions", config.Transactions, "number of transactions")
+	flags.IntVar(&config.MaxOperations, "max-ops", config.MaxOperations, "maximum number of reads and writes per transaction")
+	flags.IntVar(&config.Concurrency, "concurrency", config.Concurrency, "maximum number of transactions running at once")
+	flags.Float64Var(&config.ReadRatio, "read-ratio", config.ReadRatio, "probability that an operation is a read rather than a write")
+	flags.Float64Var(&config.ReadOnlyRatio, "read-only-ratio", config.ReadOnlyRatio, "probability that a transaction is read-only")
+	flags.IntVar(&config.Keys, "keys", config.Keys, "operations use keys x1 to xN")
+	flags.IntVar(&config.Sites, "sites", config.Sites, "sites 1 to N may fail and recover")
+	distribution := flags.String("distribution", string(config.Distribution), "key distribution, uniform or zipf")
+	flags.Float64Var(&config.ZipfExponent, "zipf-exponent", config.ZipfExponent, "skew of the zipf distribution, larger is more skewed")
+	flags.Float64Var(&config.FailProbability, "fail-prob", config.FailProbability, "probability that a site fails at each tick")
+	flags.Float64Var(&config.RecoverProbability, "recover-prob", config.RecoverProbability, "probability that a failed site recovers at each tick")
+	output := flags.String("o", "", "file to write the script to, stdout if empty")
+	flags.Parse(args)
+	config.Distribution = workload.Distribution(*distribution)
+
+	script, err := workload.Generate(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *output == "" {
+		fmt.Print(script)
+		return 0
+	}
+	if err := os.WriteFile(*output, []byte(script), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -7,43 +7,103 @@ Description: This file contains the entrypoint to the program. It reads the inpu
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/clock"
 	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/remote"
 )
 
+/* Subcommands, run as repcrec <name> [flags]. Each returns the exit code of the program */
+var subcommands = map[string]func(args []string) int{
+	"gen":    gen,
+	"check":  check,
+	"shrink": shrinkCommand,
+	"serve":  serve,
+	"site":   site,
+}
+
 /*
 ************
 Runs Main function
 
+repcrec <subcommand> [flags] runs a subcommand
+Otherwise runs a simulation
+************
+*/
+func main() {
+	if len(os.Args) >= 2 {
+		if subcommand, exists := subcommands[os.Args[1]]; exists {
+			os.Exit(subcommand(os.Args[2:]))
+		}
+	}
+	os.Exit(simulate(os.Args[1:]))
+}
+
+/*
+************
+Runs a simulation
+
 If filename is provided, reads instructions from file
 Else, reads instructions from stdin
+With --continue-on-error, errors are logged and skipped, and summarised at the end
+--clock chooses the clock giving the time of each line: logical (default), wall or hybrid
+--processes DIR runs each site as its own process with its WAL in DIR, so that fail kills the process and recover restarts it
+--anti-entropy N compares the replicas of every key after every N lines, repairing those which diverged
 ************
 */
-func main() {
+func simulate(args []string) int {
+	flags := flag.NewFlagSet("repcrec", flag.ExitOnError)
+	continueOnError := flags.Bool("continue-on-error", false, "log errors with their line number and skip the offending command instead of stopping")
+	clockKind := flags.String("clock", string(clock.Logical), "clock giving the time of each line: logical, wall or hybrid")
+	processes := flags.String("processes", "", "run each site as its own process, with its write-ahead log in this directory")
+	antiEntropy := flags.Int("anti-entropy", 0, "repair diverged replicas after every N lines, never if zero")
+	flags.Parse(args)
+	simulationClock, err := clock.Create(clock.Kind(*clockKind))
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
 	file := os.Stdin
-	var err error
-	if len(os.Args) >= 2 {
-		filename := os.Args[1]
+	if flags.NArg() >= 1 {
+		filename := flags.Arg(0)
 		fmt.Printf("Opening file %s\n", filename)
 		file, err = os.Open(filename)
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(1)
+			return 1
 		}
 		defer file.Close()
 	}
 	if file == os.Stdin {
 		fmt.Println("Please enter input and press Ctrl-D or enter exit to exit")
 	}
-	siteCoordinator := domain.CreateSiteCoordinator(10)
+	siteCoordinator, err := createSiteCoordinator(*processes, false)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer siteCoordinator.Close()
 	transactionManager := domain.CreateTransactionManager(siteCoordinator)
-	err = internal.Simulation(file, siteCoordinator, transactionManager)
+	err = internal.SimulationWithOptions(file, siteCoordinator, transactionManager, internal.Options{ContinueOnError: *continueOnError, Clock: simulationClock, AntiEntropyEvery: *antiEntropy})
 	if err != nil {
 		fmt.Println(err)
-		return
+		return 0
 	}
 	fmt.Println("Completed Successfully")
+	return 0
+}
+
+/* Returns a site coordinator for 10 sites, which run as processes with their WALs in dir unless dir is empty. With resume, the sites replay the WALs left in dir */
+func createSiteCoordinator(dir string, resume bool) (interface {
+	domain.SiteCoordinator
+	Close() error
+}, error) {
+	if dir == "" {
+		return domain.CreateSiteCoordinator(10), nil
+	}
+	return remote.CreateCluster(remote.ClusterConfig{Sites: 10, Dir: dir, Resume: resume})
 }
@@ -0,0 +1,110 @@
+/**************************
+File: serve.go
+Author: Mingyi Lim
+Description: This file contains the serve subcommand, which serves a single database to many clients over TCP, HTTP or both.
+***************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/clock"
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+************
+Runs the serve subcommand
+
+repcrec serve [--addr host:port] [--http host:port] accepts connections speaking the simulation language, one line at a time,
+and serves the HTTP API if --http is given. Both share one database and one clock. An empty --addr serves HTTP only
+--clock chooses the clock: logical (default), wall or hybrid
+--processes DIR runs each site as its own process with its WAL in DIR
+--tm-log FILE logs the transaction manager to FILE. Started again with the same FILE and DIR, the server rebuilds the transaction manager from the log,
+aborting transactions which were in flight, and the sites replay their WALs
+--anti-entropy N compares the replicas of every key after every N lines and requests, repairing those which diverged
+************
+*/
+func serve(args []string) int {
+	flags := flag.NewFlagSet("repcrec serve", flag.ExitOnError)
+	addr := flags.String("addr", ":7000", "address to accept simulation language connections on, none if empty")
+	httpAddr := flags.String("http", "", "address to serve the HTTP API on, none if empty")
+	clockKind := flags.String("clock", string(clock.Logical), "clock giving the time of each line and request: logical, wall or hybrid")
+	processes := flags.String("processes", "", "run each site as its own process, with its write-ahead log in this directory")
+	tmLog := flags.String("tm-log", "", "log the transaction manager to this file, and rebuild it from the file on start. Needs --processes")
+	antiEntropy := flags.Int("anti-entropy", 0, "repair diverged replicas after every N lines and requests, never if zero")
+	flags.Parse(args)
+	if *addr == "" && *httpAddr == "" {
+		fmt.Fprintln(os.Stderr, "usage: repcrec serve [--addr host:port] [--http host:port]")
+		return 2
+	}
+	if *tmLog != "" && *processes == "" {
+		fmt.Fprintln(os.Stderr, "--tm-log needs --processes, as sites in the server's process do not outlive it")
+		return 2
+	}
+	serverClock, err := clock.Create(clock.Kind(*clockKind))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	siteCoordinator, err := createSiteCoordinator(*processes, *tmLog != "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer siteCoordinator.Close()
+	transactionManager, err := createTransactionManager(siteCoordinator, *tmLog, serverClock)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	server := internal.CreateServerWithOptions(siteCoordinator, transactionManager, internal.Options{Clock: serverClock, AntiEntropyEvery: *antiEntropy})
+	errs := make(chan error, 2)
+	if *httpAddr != "" {
+		listener, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Serving HTTP on %s\n", listener.Addr())
+		go func() { errs <- http.Serve(listener, server.Handler()) }()
+	}
+	if *addr != "" {
+		listener, err := net.Listen("tcp", *addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Listening on %s\n", listener.Addr())
+		go func() { errs <- server.Serve(listener) }()
+	}
+	if err := <-errs; err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+/*
+Returns a transaction manager, rebuilt from the log at path and logging to it unless path is empty.
+The clock is moved past the latest time in the log, so that new transactions begin after every logged one
+*/
+func createTransactionManager(siteCoordinator domain.SiteCoordinator, path string, serverClock clock.Clock) (domain.TransactionManager, error) {
+	if path == "" {
+		return domain.CreateTransactionManager(siteCoordinator), nil
+	}
+	log, records, err := domain.OpenTransactionLog(path)
+	if err != nil {
+		return nil, err
+	}
+	for serverClock.Now() <= domain.LatestLogTime(records) {
+		serverClock.Advance()
+	}
+	return domain.RestoreTransactionManager(siteCoordinator, records, log)
+}
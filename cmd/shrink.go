@@ -0,0 +1,65 @@
+/**************************
+File: shrink.go
+Author: Mingyi Lim
+Description: This file contains the shrink subcommand, which minimizes a failing script to a small script which still fails in the same way.
+***************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/mingyi850/repcrec/internal/shrink"
+)
+
+/*
+************
+Runs the shrink subcommand
+
+repcrec shrink [flags] <file> writes the shrunk script to stdout, or to the file given by -o
+--failure chooses what counts as failing: expect (default), invariant or panic
+************
+*/
+func shrinkCommand(args []string) int {
+	flags := flag.NewFlagSet("repcrec shrink", flag.ExitOnError)
+	failure := flags.String("failure", string(shrink.FailedExpectation), "what the script must keep doing: expect (an expectation fails), invariant (the fault-injection driver finds a violation) or panic")
+	output := flags.String("o", "", "file to write the shrunk script to, stdout if empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: repcrec shrink [--failure expect|invariant|panic] [-o file] <file>")
+		return 2
+	}
+	filename := flags.Arg(0)
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	script, err := parser.ParseInDir(file, filepath.Dir(filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fails, description, err := shrink.NewPredicate(shrink.Failure(*failure), script.Lines)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+		return 1
+	}
+	result := shrink.Shrink(script.Lines, fails)
+	fmt.Fprintf(os.Stderr, "shrunk %d lines to %d in %d runs\n", len(script.Lines), len(result.Lines), result.Runs)
+	shrunk := fmt.Sprintf("// Shrunk from %s by repcrec shrink --failure %s\n// %s\n%s", filename, *failure, description, shrink.Format(result.Lines))
+	if *output == "" {
+		fmt.Print(shrunk)
+		return 0
+	}
+	if err := os.WriteFile(*output, []byte(shrunk), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
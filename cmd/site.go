@@ -0,0 +1,52 @@
+/**************************
+File: site.go
+Author: Mingyi Lim
+Description: This file contains the site subcommand, which runs a single site as its own process, serving its DataManager over RPC.
+***************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mingyi850/repcrec/internal/remote"
+)
+
+/*
+************
+Runs the site subcommand
+
+repcrec site --id N --port P --wal FILE serves site N on port P of localhost until the process is killed, replaying FILE first
+--exit-on-eof also exits once stdin is closed, so that sites started by a cluster do not outlive it
+************
+*/
+func site(args []string) int {
+	flags := flag.NewFlagSet("repcrec site", flag.ExitOnError)
+	id := flags.Int("id", 0, "number of the site, from 1 to 10")
+	port := flags.Int("port", 0, "port to listen on")
+	wal := flags.String("wal", "", "write-ahead log of the site, site-<id>.wal if empty")
+	exitOnEOF := flags.Bool("exit-on-eof", false, "exit once stdin is closed")
+	flags.Parse(args)
+	if *id < 1 || *id > 10 || *port == 0 {
+		fmt.Fprintln(os.Stderr, "usage: repcrec site --id N --port P [--wal FILE]")
+		return 2
+	}
+	walPath := *wal
+	if walPath == "" {
+		walPath = fmt.Sprintf("site-%d.wal", *id)
+	}
+	if *exitOnEOF {
+		go func() {
+			io.Copy(io.Discard, os.Stdin)
+			os.Exit(0)
+		}()
+	}
+	if err := remote.ServeSite(*id, fmt.Sprintf("127.0.0.1:%d", *port), walPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+/**************************
+File: clock.go
+Author: Mingyi Lim
+Description: This file contains the clocks which drive the times passed to the TransactionManager, SiteCoordinator and DataManager.
+A clock divides time into events, such as a line of a script or a request to a server. Every operation of an event runs at the same time, and each event runs at a later time than the one before it.
+The components only compare times, so any clock whose times increase may drive them:
+
+	logical: ticks 1, 2, 3, ..., as used by scripts
+	wall: monotonic nanoseconds since the Unix epoch
+	hybrid: a hybrid logical clock, milliseconds since the Unix epoch in the high bits and a logical counter in the low 16 bits
+
+Clocks are not safe for concurrent use. Their callers already serialize operations on the components, and advance the clock while doing so.
+***************************/
+
+package clock
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type Kind string
+
+const (
+	Logical Kind = "logical"
+	Wall    Kind = "wall"
+	Hybrid  Kind = "hybrid"
+)
+
+const logicalBits = 16
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* The source of time of a clock */
+type Clock interface {
+	Now() int // Returns the time of the current event. Repeated calls return the same time until Advance is called
+	Advance() // Starts the next event, at a later time than the current one
+}
+
+/* Returns the physical time in nanoseconds since the Unix epoch. Successive readings must not decrease */
+type Source func() int64
+
+/* Counts events from 1 */
+type LogicalClock struct {
+	current int
+}
+
+/* Reads the physical time at every event. If the source has not moved on since the last event, the time is one nanosecond after it */
+type WallClock struct {
+	source  Source
+	current int
+}
+
+/* A hybrid logical clock. Its times stay close to physical time, in milliseconds, but still increase when physical time stands still or runs backwards */
+type HybridClock struct {
+	source  Source
+	current int // The time of the current event: physical time in milliseconds in the high bits, and a logical counter in the low bits
+}
+
+/* Creates a clock of the given kind. Physical clocks read the system time */
+func Create(kind Kind) (Clock, error) {
+	switch kind {
+	case Logical:
+		return CreateLogicalClock(), nil
+	case Wall:
+		return CreateWallClock(SystemSource()), nil
+	case Hybrid:
+		return CreateHybridClock(SystemSource()), nil
+	}
+	return nil, fmt.Errorf("unknown clock %q, expected %s, %s or %s", kind, Logical, Wall, Hybrid)
+}
+
+/* Returns a source reading the system time. Readings are taken from the monotonic clock, anchored at the wall time the source was created */
+func SystemSource() Source {
+	start := time.Now()
+	epoch := start.UnixNano()
+	return func() int64 {
+		return epoch + time.Since(start).Nanoseconds()
+	}
+}
+
+/* Creates a logical clock at tick 1 */
+func CreateLogicalClock() *LogicalClock {
+	return &LogicalClock{current: 1}
+}
+
+func (c *LogicalClock) Now() int {
+	return c.current
+}
+
+func (c *LogicalClock) Advance() {
+	c.current++
+}
+
+/* Creates a wall clock whose first event is at the current physical time */
+func CreateWallClock(source Source) *WallClock {
+	return &WallClock{source: source, current: int(source())}
+}
+
+func (c *WallClock) Now() int {
+	return c.current
+}
+
+func (c *WallClock) Advance() {
+	c.current = max(int(c.source()), c.current+1)
+}
+
+/* Creates a hybrid logical clock whose first event is at the current physical time */
+func CreateHybridClock(source Source) *HybridClock {
+	return &HybridClock{source: source, current: milliseconds(source()) << logicalBits}
+}
+
+func (c *HybridClock) Now() int {
+	return c.current
+}
+
+/* Moves to the current physical time with the counter at 0, unless that is not later than the last time, e.g. once a counter which outgrew its bits has carried into the next millisecond */
+func (c *HybridClock) Advance() {
+	c.current = max(milliseconds(c.source())<<logicalBits, c.current+1)
+}
+
+/* Splits a time of a hybrid clock into its physical time in milliseconds and its logical counter */
+func SplitHybrid(time int) (int, int) {
+	return time >> logicalBits, time & (1<<logicalBits - 1)
+}
+
+func milliseconds(nanoseconds int64) int {
+	return int(nanoseconds / int64(time.Millisecond))
+}
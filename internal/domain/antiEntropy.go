@@ -0,0 +1,209 @@
+/**************************
+File: antiEntropy.go
+Author: Mingyi Lim
+Description: This file contains anti-entropy, which finds replicas of a key whose committed histories have diverged and repairs them.
+Every site which is up builds a Merkle tree over the histories of the replicated keys. Sites whose root differs from the most common root are compared with a site holding it,
+looking only into subtrees whose hashes differ, which finds the keys to check.
+The authoritative history of such a key holds every version committed at any replica which is up. Two-phase commit gives each commit its own time, so versions are matched by time,
+and replicas which disagree on the value of a version are settled by majority, then by the lowest site.
+A replica lacking versions has usually missed commits while it was down. Repairing it gives it the history of the other replicas.
+***************************/
+
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal/utils"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A replica of a key whose history differs from the authoritative history */
+type Divergence struct {
+	Site        int
+	Key         int
+	Missing     []HistoricalValue // Versions of the authoritative history the replica lacks
+	Conflicting []HistoricalValue // Versions the replica holds with a value other than the authoritative one
+	Repaired    bool
+	Err         error // Why the replica could not be repaired
+}
+
+/*
+The position of a version in the history of a key: its commit time, and its place among the versions committed at that time.
+A transaction writing a key twice commits both versions at the same time
+*/
+type slot struct {
+	time    int
+	ordinal int
+}
+
+type slottedVersion struct {
+	HistoricalValue
+	slot slot
+}
+
+/* Returns the divergence as a single line, such as "x2 at site 3: missing 101 at 5; repaired" */
+func (d Divergence) String() string {
+	parts := make([]string, 0, 3)
+	if len(d.Missing) > 0 {
+		parts = append(parts, "missing "+joinVersions(d.Missing))
+	}
+	if len(d.Conflicting) > 0 {
+		parts = append(parts, "conflicting "+joinVersions(d.Conflicting))
+	}
+	if d.Repaired {
+		parts = append(parts, "repaired")
+	} else if d.Err != nil {
+		parts = append(parts, fmt.Sprintf("not repaired: %v", d.Err))
+	}
+	return fmt.Sprintf("x%d at site %d: %s", d.Key, d.Site, strings.Join(parts, "; "))
+}
+
+/*
+Compares the replicas of every replicated key at the sites which are up, and returns the replicas which diverge from the authoritative history, by key then site.
+With repair, each diverged replica is given the authoritative history
+*/
+func (s *SiteCoordinatorImpl) AntiEntropy(repair bool) []Divergence {
+	keys := s.replicatedKeys()
+	trees := make(map[int]MerkleTree)
+	rootCounts := make(map[string]int)
+	for _, site := range utils.GetSortedMapKeys(s.Sites) {
+		if s.isActiveSite(site) {
+			trees[site] = s.Sites[site].MerkleTree(keys)
+			rootCounts[trees[site].Root()]++
+		}
+	}
+	reference := -1
+	for _, site := range utils.GetSortedMapKeys(trees) {
+		if reference == -1 || rootCounts[trees[site].Root()] > rootCounts[trees[reference].Root()] {
+			reference = site
+		}
+	}
+	diverged := make(map[int]bool)
+	for _, site := range utils.GetSortedMapKeys(trees) {
+		for _, key := range trees[site].Diff(trees[reference]) {
+			diverged[key] = true
+		}
+	}
+	divergences := make([]Divergence, 0)
+	for _, key := range utils.GetSortedMapKeys(diverged) {
+		divergences = append(divergences, s.checkKey(key, utils.GetSortedMapKeys(trees), repair)...)
+	}
+	return divergences
+}
+
+/*
+******
+Private Methods
+******
+*/
+
+/* Returns the keys held by more than one site */
+func (s *SiteCoordinatorImpl) replicatedKeys() []int {
+	keys := make([]int, 0)
+	for key := 1; key <= NumKeys; key++ {
+		if len(s.GetSitesForKey(key)) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+/* Compares the histories of a key at the given sites with its authoritative history, repairing the replicas which diverge if asked to */
+func (s *SiteCoordinatorImpl) checkKey(key int, sites []int, repair bool) []Divergence {
+	histories := make(map[int][]HistoricalValue)
+	for _, site := range sites {
+		if s.Sites[site].HasKey(key) {
+			histories[site] = s.Sites[site].History(key)
+		}
+	}
+	authoritative := authoritativeHistory(histories)
+	divergences := make([]Divergence, 0)
+	for _, site := range utils.GetSortedMapKeys(histories) {
+		divergence := Divergence{Site: site, Key: key}
+		held := slotValues(histories[site])
+		for _, version := range authoritative {
+			value, exists := held[version.slot]
+			if !exists {
+				divergence.Missing = append(divergence.Missing, version.HistoricalValue)
+			} else if value != version.value {
+				divergence.Conflicting = append(divergence.Conflicting, HistoricalValue{value, version.time})
+			}
+		}
+		if len(divergence.Missing) == 0 && len(divergence.Conflicting) == 0 {
+			continue
+		}
+		if repair {
+			divergence.Err = s.Sites[site].Repair(key, versionsOf(authoritative))
+			divergence.Repaired = divergence.Err == nil
+		}
+		divergences = append(divergences, divergence)
+	}
+	return divergences
+}
+
+/* Returns the value a replica holds in each slot */
+func slotValues(history []HistoricalValue) map[slot]int {
+	values := make(map[slot]int)
+	ordinals := make(map[int]int)
+	for _, version := range history {
+		values[slot{version.time, ordinals[version.time]}] = version.value
+		ordinals[version.time]++
+	}
+	return values
+}
+
+/* Merges the histories of the replicas of a key slot by slot. The value of a slot is the one most replicas hold, or that of the lowest site on a tie */
+func authoritativeHistory(histories map[int][]HistoricalValue) []slottedVersion {
+	votes := make(map[slot]map[int]int)
+	first := make(map[slot]int) // The value held by the lowest site holding the slot
+	for _, site := range utils.GetSortedMapKeys(histories) {
+		for position, value := range slotValues(histories[site]) {
+			if _, exists := votes[position]; !exists {
+				votes[position] = make(map[int]int)
+				first[position] = value
+			}
+			votes[position][value]++
+		}
+	}
+	history := make([]slottedVersion, 0, len(votes))
+	for position, counts := range votes {
+		chosen := first[position]
+		for _, value := range utils.GetSortedMapKeys(counts) {
+			if counts[value] > counts[chosen] {
+				chosen = value
+			}
+		}
+		history = append(history, slottedVersion{HistoricalValue{chosen, position.time}, position})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].slot.time != history[j].slot.time {
+			return history[i].slot.time < history[j].slot.time
+		}
+		return history[i].slot.ordinal < history[j].slot.ordinal
+	})
+	return history
+}
+
+func versionsOf(history []slottedVersion) []HistoricalValue {
+	versions := make([]HistoricalValue, len(history))
+	for i, version := range history {
+		versions[i] = version.HistoricalValue
+	}
+	return versions
+}
+
+func joinVersions(versions []HistoricalValue) string {
+	parts := make([]string, len(versions))
+	for i, version := range versions {
+		parts[i] = version.String()
+	}
+	return strings.Join(parts, ", ")
+}
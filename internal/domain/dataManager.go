@@ -14,6 +14,15 @@ import (
 	"github.com/mingyi850/repcrec/internal/utils"
 )
 
+/*
+****
+Consts and Enums
+****
+*/
+
+/* The keys of the database are x1 to xNumKeys. Even keys are held by every site, and odd key k by site 1 + k mod 10 */
+const NumKeys = 20
+
 /*
 ****
 Custom Structs
@@ -28,6 +37,11 @@ type HistoricalValue struct {
 	time  int
 }
 
+/* Creates a version of a key, as received from a site in another process */
+func CreateHistoricalValue(value int, time int) HistoricalValue {
+	return HistoricalValue{value, time}
+}
+
 func (h HistoricalValue) GetValue() int {
 	return h.value
 }
@@ -36,20 +50,45 @@ func (h HistoricalValue) GetTime() int {
 	return h.time
 }
 
+/* Returns the version as "value at time", where the initial version is shown as "value at init" */
+func (h HistoricalValue) String() string {
+	if h.time == -1 {
+		return fmt.Sprintf("%d at init", h.value)
+	}
+	return fmt.Sprintf("%d at %d", h.value, h.time)
+}
+
 /*
 The Data Manager is responsible for managing the data at a single site. It provides interfaces to access and modify the data.
 */
 type DataManager interface {
 	Dump() string
+	DumpKey(key int) string
+	DumpHistory(key int) string
+	DumpAsOf(time int) string
+	HasKey(key int) bool
 	Read(key int, time int) HistoricalValue
 	Commit(key int, value int, time int) error
 	GetLastCommitted(key int) HistoricalValue
+	Prepare(tx int, writes []PreparedWrite) error
+	Decide(tx int, commit bool, time int) error
+	InDoubt() []int
+	History(key int) []HistoricalValue
+	Repair(key int, versions []HistoricalValue) error
+	MerkleTree(keys []int) MerkleTree
 }
 
-/* Each key contains a list of committed values */
+/* A write which a transaction has prepared at a site, committed once the coordinator decides to commit */
+type PreparedWrite struct {
+	Key   int
+	Value int
+}
+
+/* Each key contains a list of committed values. Transactions which have prepared at the site and await a decision hold their writes in prepared */
 type DataManagerImpl struct {
 	siteId         int
 	commitedValues map[int][]HistoricalValue
+	prepared       map[int][]PreparedWrite
 }
 
 /* Creates and returns an instance of the DataManagerImpl */
@@ -57,6 +96,7 @@ func CreateDataManager(siteId int) DataManagerImpl {
 	result := DataManagerImpl{
 		siteId:         siteId,
 		commitedValues: initValuesMap(siteId),
+		prepared:       make(map[int][]PreparedWrite),
 	}
 	return result
 }
@@ -76,6 +116,37 @@ func (d *DataManagerImpl) Dump() string {
 	return fmt.Sprintf("site %d - %s", d.siteId, strings.Join(result, ", "))
 }
 
+/* Returns a single line representing a snapshot of all data committed at the site up to the given time */
+func (d *DataManagerImpl) DumpAsOf(time int) string {
+	keys := getManagedKeys(d.siteId)
+	sort.IntSlice(keys).Sort()
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, fmt.Sprintf("x%d: %d", key, d.Read(key, time).value))
+	}
+	return fmt.Sprintf("site %d - %s", d.siteId, strings.Join(result, ", "))
+}
+
+/* Returns a single line representing the last committed value of a key at the site */
+func (d *DataManagerImpl) DumpKey(key int) string {
+	return fmt.Sprintf("site %d - x%d: %d", d.siteId, key, d.GetLastCommitted(key).value)
+}
+
+/* Returns a single line representing every committed version of a key at the site, oldest first */
+func (d *DataManagerImpl) DumpHistory(key int) string {
+	versions := make([]string, 0, len(d.commitedValues[key]))
+	for _, version := range d.commitedValues[key] {
+		versions = append(versions, version.String())
+	}
+	return fmt.Sprintf("site %d - x%d: %s", d.siteId, key, strings.Join(versions, ", "))
+}
+
+/* Returns true if the site holds a copy of the key */
+func (d *DataManagerImpl) HasKey(key int) bool {
+	_, exists := d.commitedValues[key]
+	return exists
+}
+
 /* Returns the last committed value of a key at the current time */
 func (d *DataManagerImpl) GetLastCommitted(key int) HistoricalValue {
 	committedArray := d.commitedValues[key]
@@ -98,6 +169,51 @@ func (d *DataManagerImpl) Commit(key int, value int, time int) error {
 	return nil
 }
 
+/* Holds the writes of a transaction until the coordinator decides its outcome. Voting yes is a promise to commit them if told to */
+func (d *DataManagerImpl) Prepare(tx int, writes []PreparedWrite) error {
+	d.prepared[tx] = writes
+	return nil
+}
+
+/* Commits or discards the writes a transaction has prepared at the site. A transaction which is not prepared has already been decided, so nothing is done */
+func (d *DataManagerImpl) Decide(tx int, commit bool, time int) error {
+	writes, exists := d.prepared[tx]
+	if !exists {
+		return nil
+	}
+	delete(d.prepared, tx)
+	if commit {
+		for _, write := range writes {
+			d.Commit(write.Key, write.Value, time)
+		}
+	}
+	return nil
+}
+
+/* Returns the transactions which have prepared at the site and await a decision, in order */
+func (d *DataManagerImpl) InDoubt() []int {
+	return utils.GetSortedMapKeys(d.prepared)
+}
+
+/* Returns every committed version of a key, oldest first */
+func (d *DataManagerImpl) History(key int) []HistoricalValue {
+	return append([]HistoricalValue{}, d.commitedValues[key]...)
+}
+
+/* Replaces the history of a key with the authoritative history found by anti-entropy */
+func (d *DataManagerImpl) Repair(key int, versions []HistoricalValue) error {
+	if !d.HasKey(key) {
+		return fmt.Errorf("site %d does not hold x%d", d.siteId, key)
+	}
+	d.commitedValues[key] = append([]HistoricalValue{}, versions...)
+	return nil
+}
+
+/* Returns the Merkle tree over the histories of the given keys at the site */
+func (d *DataManagerImpl) MerkleTree(keys []int) MerkleTree {
+	return CreateMerkleTree(keys, d.commitedValues)
+}
+
 /*
 *******
 Private Methods
@@ -113,7 +229,7 @@ func initValuesMap(siteId int) map[int][]HistoricalValue {
 }
 
 func getManagedKeys(siteId int) []int {
-	keys := utils.GetRange(2, 20, 2)
+	keys := utils.GetRange(2, NumKeys, 2)
 	if siteId%2 == 0 {
 		keys = append(keys, siteId-1, siteId+9)
 	}
@@ -0,0 +1,140 @@
+/**************************
+File: explain.go
+Author: Mingyi Lim
+Description: This file contains the decision trail recorded for each transaction. The trail records which sites were considered or excluded for reads, which writes were verified at commit and which checks caused the transaction to wait or abort.
+***************************/
+
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type DecisionType string
+
+const (
+	DecisionBegin         DecisionType = "begin"
+	DecisionReadSites     DecisionType = "read sites"
+	DecisionSiteExcluded  DecisionType = "site excluded"
+	DecisionRead          DecisionType = "read"
+	DecisionWrite         DecisionType = "write"
+	DecisionWait          DecisionType = "wait"
+	DecisionWriteVerified DecisionType = "write verified"
+	DecisionWriteRejected DecisionType = "write rejected"
+	DecisionCycleCheck    DecisionType = "cycle check"
+	DecisionCommit        DecisionType = "commit"
+	DecisionAbort         DecisionType = "abort"
+	DecisionInDoubt       DecisionType = "in doubt"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A single step in the decision trail of a transaction. Key and site are -1 if the decision does not concern a key or site */
+type Decision struct {
+	time         int
+	decisionType DecisionType
+	key          int
+	site         int
+	detail       string
+}
+
+/* Returns the time at which the decision was made */
+func (d Decision) GetTime() int {
+	return d.time
+}
+
+/* Returns the type of the decision */
+func (d Decision) GetType() DecisionType {
+	return d.decisionType
+}
+
+/* Returns the key the decision concerns, or -1 */
+func (d Decision) GetKey() int {
+	return d.key
+}
+
+/* Returns the site the decision concerns, or -1 */
+func (d Decision) GetSite() int {
+	return d.site
+}
+
+/* Returns the human readable detail of the decision */
+func (d Decision) GetDetail() string {
+	return d.detail
+}
+
+/* Returns a single line representation of the decision, e.g. "[5] site excluded x4 site 2: down between last commit and transaction start" */
+func (d Decision) String() string {
+	subject := string(d.decisionType)
+	if d.key != -1 {
+		subject += fmt.Sprintf(" x%d", d.key)
+	}
+	if d.site != -1 {
+		subject += fmt.Sprintf(" site %d", d.site)
+	}
+	if d.detail == "" {
+		return fmt.Sprintf("[%d] %s", d.time, subject)
+	}
+	return fmt.Sprintf("[%d] %s: %s", d.time, subject, d.detail)
+}
+
+/*
+**********
+Transaction methods
+**********
+*/
+
+/* Returns the decision trail of the transaction in the order the decisions were made */
+func (tx *Transaction) GetDecisions() []Decision {
+	return tx.decisions
+}
+
+/* Returns a multi line explanation of the transaction's state and decision trail */
+func (tx *Transaction) Explain() string {
+	lines := make([]string, 0, len(tx.decisions)+1)
+	header := fmt.Sprintf("T%d: %s, started at %d", tx.id, tx.state, tx.startTime)
+	if tx.readOnly {
+		header = fmt.Sprintf("T%d: %s (read-only), started at %d", tx.id, tx.state, tx.startTime)
+	}
+	if tx.endTime != -1 {
+		header += fmt.Sprintf(", ended at %d", tx.endTime)
+	}
+	lines = append(lines, header)
+	for _, decision := range tx.decisions {
+		lines = append(lines, "  "+decision.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+/*
+*************
+Private methods
+*************
+*/
+
+/* Appends a decision to the decision trail of the transaction */
+func (tx *Transaction) recordDecision(time int, decisionType DecisionType, key int, site int, detail string) {
+	tx.decisions = append(tx.decisions, Decision{time, decisionType, key, site, detail})
+}
+
+/* Formats a cycle as a single line path, e.g. "T3 -RW-> T2 -RW-> T1 -WW-> T3" */
+func formatCycle(cycle []Edge) string {
+	if len(cycle) == 0 {
+		return ""
+	}
+	parts := []string{fmt.Sprintf("T%d", cycle[0].from)}
+	for _, edge := range cycle {
+		parts = append(parts, fmt.Sprintf("-%s-> T%d", edge.edgeType, edge.to))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,93 @@
+/**************************
+File: merkle.go
+Author: Mingyi Lim
+Description: This file contains the Merkle tree a site builds over the committed version histories of its keys, used to find replicas which have diverged.
+Two sites holding the same history of every key have the same root. Otherwise, comparing their trees from the root down finds the keys whose histories differ,
+looking only into subtrees whose hashes differ.
+***************************/
+
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A binary hash tree over the histories of a list of keys. Levels[0] holds the hash of each key's history in the order of Keys, and the last level holds the root */
+type MerkleTree struct {
+	Keys   []int
+	Levels [][]string
+}
+
+/* Builds the tree over the histories of the given keys. A node without a sibling is carried up to the next level unchanged */
+func CreateMerkleTree(keys []int, histories map[int][]HistoricalValue) MerkleTree {
+	leaves := make([]string, len(keys))
+	for i, key := range keys {
+		versions := make([]string, len(histories[key]))
+		for j, version := range histories[key] {
+			versions[j] = fmt.Sprintf("%d@%d", version.value, version.time)
+		}
+		leaves[i] = hash(fmt.Sprintf("x%d:%s", key, strings.Join(versions, ",")))
+	}
+	levels := [][]string{leaves}
+	for len(levels[len(levels)-1]) > 1 {
+		below := levels[len(levels)-1]
+		level := make([]string, 0, (len(below)+1)/2)
+		for i := 0; i < len(below); i += 2 {
+			if i+1 == len(below) {
+				level = append(level, below[i])
+			} else {
+				level = append(level, hash(below[i]+below[i+1]))
+			}
+		}
+		levels = append(levels, level)
+	}
+	return MerkleTree{Keys: keys, Levels: levels}
+}
+
+/* Returns the hash of the whole tree, empty for a tree without keys */
+func (m MerkleTree) Root() string {
+	top := m.Levels[len(m.Levels)-1]
+	if len(top) == 0 {
+		return ""
+	}
+	return top[0]
+}
+
+/* Returns the keys whose histories differ between two trees over the same keys, in the order of Keys */
+func (m MerkleTree) Diff(other MerkleTree) []int {
+	keys := make([]int, 0)
+	var descend func(level int, index int)
+	descend = func(level int, index int) {
+		if index >= len(m.Levels[level]) || m.Levels[level][index] == other.Levels[level][index] {
+			return
+		}
+		if level == 0 {
+			keys = append(keys, m.Keys[index])
+			return
+		}
+		descend(level-1, 2*index)
+		descend(level-1, 2*index+1)
+	}
+	descend(len(m.Levels)-1, 0)
+	return keys
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+func hash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+/**************************
+File: network.go
+Author: Mingyi Lim
+Description: This file contains the networks which carry messages between the SiteCoordinator and the sites.
+The direct network delivers every message at once and in order. The faulty network delays each message by a random duration, so that messages may overtake each other, and drops some of them.
+Senders retry requests which are not answered in time, so a faulty network slows the database down without changing what it does.
+***************************/
+
+package domain
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Carries messages between the SiteCoordinator and the sites */
+type Network interface {
+	Send(deliver func())         // Delivers a message by calling deliver, now, later or never
+	RetryTimeout() time.Duration // How long to wait for a response before sending a request again. Zero waits forever
+}
+
+/* Delivers every message immediately, in the order it was sent */
+type DirectNetwork struct{}
+
+/* Faults injected by a FaultyNetwork */
+type FaultConfig struct {
+	MaxDelay     time.Duration // Each message is delayed by a random duration up to MaxDelay
+	Loss         float64       // Probability that a message is dropped
+	Seed         int64
+	RetryTimeout time.Duration // Twice MaxDelay plus a millisecond if zero
+}
+
+/* Delays, reorders and drops messages at random. The same seed makes the same decisions for the same sequence of messages */
+type FaultyNetwork struct {
+	config FaultConfig
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+func (DirectNetwork) Send(deliver func()) {
+	deliver()
+}
+
+func (DirectNetwork) RetryTimeout() time.Duration {
+	return 0
+}
+
+/* Creates a network injecting the given faults */
+func CreateFaultyNetwork(config FaultConfig) *FaultyNetwork {
+	if config.RetryTimeout == 0 {
+		config.RetryTimeout = 2*config.MaxDelay + time.Millisecond
+	}
+	return &FaultyNetwork{config: config, random: rand.New(rand.NewSource(config.Seed))}
+}
+
+func (n *FaultyNetwork) Send(deliver func()) {
+	n.mutex.Lock()
+	lost := n.random.Float64() < n.config.Loss
+	delay := time.Duration(n.random.Int63n(int64(n.config.MaxDelay) + 1))
+	n.mutex.Unlock()
+	if lost {
+		return
+	}
+	time.AfterFunc(delay, deliver)
+}
+
+func (n *FaultyNetwork) RetryTimeout() time.Duration {
+	return n.config.RetryTimeout
+}
@@ -0,0 +1,271 @@
+/**************************
+File: site.go
+Author: Mingyi Lim
+Description: This file contains the site process, which runs a DataManager on its own goroutine, and the SiteClient through which the SiteCoordinator reaches it.
+The goroutine owns its DataManagerImpl and is the only code touching it. Every call on a SiteClient is a request message over the network, answered by a response message.
+Requests carry increasing ids. A site answers a request it has already handled with the response it gave before, so a retried commit is applied once,
+and the client ignores responses to requests it is no longer waiting for.
+***************************/
+
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type siteMethod string
+
+const (
+	dumpMethod          siteMethod = "dump"
+	dumpKeyMethod       siteMethod = "dumpKey"
+	dumpHistoryMethod   siteMethod = "dumpHistory"
+	dumpAsOfMethod      siteMethod = "dumpAsOf"
+	hasKeyMethod        siteMethod = "hasKey"
+	readMethod          siteMethod = "read"
+	commitMethod        siteMethod = "commit"
+	lastCommittedMethod siteMethod = "lastCommitted"
+	prepareMethod       siteMethod = "prepare"
+	decideMethod        siteMethod = "decide"
+	inDoubtMethod       siteMethod = "inDoubt"
+	historyMethod       siteMethod = "history"
+	repairMethod        siteMethod = "repair"
+	merkleTreeMethod    siteMethod = "merkleTree"
+)
+
+const siteQueueSize = 16
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+type siteRequest struct {
+	id       int
+	method   siteMethod
+	key      int
+	value    int
+	time     int
+	tx       int
+	writes   []PreparedWrite
+	commit   bool
+	keys     []int
+	versions []HistoricalValue
+}
+
+type siteResponse struct {
+	id       int
+	value    HistoricalValue
+	text     string
+	ok       bool
+	txs      []int
+	versions []HistoricalValue
+	tree     MerkleTree
+	err      error
+	panicked any // The value the DataManager panicked with, raised again by the client
+}
+
+/* A site running on its own goroutine. It handles one request at a time */
+type siteProcess struct {
+	dataManager  DataManagerImpl
+	network      Network
+	requests     chan siteRequest
+	responses    chan siteResponse
+	lastID       int
+	lastResponse siteResponse
+}
+
+/*
+The DataManager of a site as seen by the SiteCoordinator. Each call sends a request to the site and waits for its response, sending the request again if the network loses it.
+A SiteClient must not be called by several goroutines at once
+*/
+type SiteClient struct {
+	siteId    int
+	network   Network
+	requests  chan<- siteRequest
+	responses <-chan siteResponse
+	nextID    int
+}
+
+/* Starts a site on its own goroutine, which runs until done is closed, and returns a client for it */
+func StartSite(siteId int, network Network, done <-chan struct{}) *SiteClient {
+	process := &siteProcess{
+		dataManager: CreateDataManager(siteId),
+		network:     network,
+		requests:    make(chan siteRequest, siteQueueSize),
+		responses:   make(chan siteResponse, siteQueueSize),
+	}
+	go process.run(done)
+	return &SiteClient{siteId: siteId, network: network, requests: process.requests, responses: process.responses}
+}
+
+func (c *SiteClient) Dump() string {
+	return c.call(siteRequest{method: dumpMethod}).text
+}
+
+func (c *SiteClient) DumpKey(key int) string {
+	return c.call(siteRequest{method: dumpKeyMethod, key: key}).text
+}
+
+func (c *SiteClient) DumpHistory(key int) string {
+	return c.call(siteRequest{method: dumpHistoryMethod, key: key}).text
+}
+
+func (c *SiteClient) DumpAsOf(time int) string {
+	return c.call(siteRequest{method: dumpAsOfMethod, time: time}).text
+}
+
+func (c *SiteClient) HasKey(key int) bool {
+	return c.call(siteRequest{method: hasKeyMethod, key: key}).ok
+}
+
+func (c *SiteClient) Read(key int, time int) HistoricalValue {
+	return c.call(siteRequest{method: readMethod, key: key, time: time}).value
+}
+
+func (c *SiteClient) Commit(key int, value int, time int) error {
+	return c.call(siteRequest{method: commitMethod, key: key, value: value, time: time}).err
+}
+
+func (c *SiteClient) GetLastCommitted(key int) HistoricalValue {
+	return c.call(siteRequest{method: lastCommittedMethod, key: key}).value
+}
+
+func (c *SiteClient) Prepare(tx int, writes []PreparedWrite) error {
+	return c.call(siteRequest{method: prepareMethod, tx: tx, writes: writes}).err
+}
+
+func (c *SiteClient) Decide(tx int, commit bool, time int) error {
+	return c.call(siteRequest{method: decideMethod, tx: tx, commit: commit, time: time}).err
+}
+
+func (c *SiteClient) InDoubt() []int {
+	return c.call(siteRequest{method: inDoubtMethod}).txs
+}
+
+func (c *SiteClient) History(key int) []HistoricalValue {
+	return c.call(siteRequest{method: historyMethod, key: key}).versions
+}
+
+func (c *SiteClient) Repair(key int, versions []HistoricalValue) error {
+	return c.call(siteRequest{method: repairMethod, key: key, versions: versions}).err
+}
+
+func (c *SiteClient) MerkleTree(keys []int) MerkleTree {
+	return c.call(siteRequest{method: merkleTreeMethod, keys: keys}).tree
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Sends a request and waits for its response, sending it again each time the retry timeout of the network passes */
+func (c *SiteClient) call(request siteRequest) siteResponse {
+	c.nextID++
+	request.id = c.nextID
+	for {
+		c.network.Send(func() { offer(c.requests, request) })
+		var retry <-chan time.Time
+		if timeout := c.network.RetryTimeout(); timeout > 0 {
+			retry = time.After(timeout)
+		}
+		if response, answered := c.await(request.id, retry); answered {
+			if response.panicked != nil {
+				panic(response.panicked)
+			}
+			return response
+		}
+	}
+}
+
+/* Waits for the response to a request, skipping responses to earlier requests. Returns false if retry fires first */
+func (c *SiteClient) await(id int, retry <-chan time.Time) (siteResponse, bool) {
+	for {
+		select {
+		case response := <-c.responses:
+			if response.id == id {
+				return response, true
+			}
+		case <-retry:
+			return siteResponse{}, false
+		}
+	}
+}
+
+/* Handles requests until done is closed. Requests older than the last one handled are dropped, as their client has moved on */
+func (p *siteProcess) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case request := <-p.requests:
+			if request.id < p.lastID {
+				continue
+			}
+			if request.id > p.lastID {
+				p.lastID, p.lastResponse = request.id, p.handle(request)
+			}
+			response := p.lastResponse
+			p.network.Send(func() { offer(p.responses, response) })
+		}
+	}
+}
+
+/* Runs a request against the DataManager. A panic is returned to the client instead of bringing down the site */
+func (p *siteProcess) handle(request siteRequest) (response siteResponse) {
+	response.id = request.id
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			response.panicked = recovered
+		}
+	}()
+	switch request.method {
+	case dumpMethod:
+		response.text = p.dataManager.Dump()
+	case dumpKeyMethod:
+		response.text = p.dataManager.DumpKey(request.key)
+	case dumpHistoryMethod:
+		response.text = p.dataManager.DumpHistory(request.key)
+	case dumpAsOfMethod:
+		response.text = p.dataManager.DumpAsOf(request.time)
+	case hasKeyMethod:
+		response.ok = p.dataManager.HasKey(request.key)
+	case readMethod:
+		response.value = p.dataManager.Read(request.key, request.time)
+	case commitMethod:
+		response.err = p.dataManager.Commit(request.key, request.value, request.time)
+	case lastCommittedMethod:
+		response.value = p.dataManager.GetLastCommitted(request.key)
+	case prepareMethod:
+		response.err = p.dataManager.Prepare(request.tx, request.writes)
+	case decideMethod:
+		response.err = p.dataManager.Decide(request.tx, request.commit, request.time)
+	case inDoubtMethod:
+		response.txs = p.dataManager.InDoubt()
+	case historyMethod:
+		response.versions = p.dataManager.History(request.key)
+	case repairMethod:
+		response.err = p.dataManager.Repair(request.key, request.versions)
+	case merkleTreeMethod:
+		response.tree = p.dataManager.MerkleTree(request.keys)
+	default:
+		response.err = fmt.Errorf("unknown method %q", request.method)
+	}
+	return response
+}
+
+/* Puts a message on a queue without blocking. A message which does not fit is lost, like any other lost message */
+func offer[M any](queue chan<- M, message M) {
+	select {
+	case queue <- message:
+	default:
+	}
+}
@@ -9,6 +9,7 @@ package domain
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/mingyi850/repcrec/internal/utils"
 )
@@ -21,9 +22,25 @@ Custom Structs
 type SiteCommitResult string
 
 const (
-	SiteOk    SiteCommitResult = "success"
-	SiteDown  SiteCommitResult = "down"
-	SiteStale SiteCommitResult = "stale"
+	SiteOk          SiteCommitResult = "success"
+	SiteDown        SiteCommitResult = "down"
+	SiteStale       SiteCommitResult = "stale"
+	SiteUnreachable SiteCommitResult = "unreachable"
+)
+
+/* The vote of a site in the prepare phase of two-phase commit. Verified is the number of writes which passed VerifySiteWrite before the vote was cast */
+type SiteVote struct {
+	Result   SiteCommitResult // SiteOk if the site votes to commit
+	Verified int
+	Err      error // Why the site could not prepare, if Result is SiteUnreachable
+}
+
+type SiteReadResult string
+
+const (
+	SiteReadable        SiteReadResult = "valid"
+	SiteNotAliveAtStart SiteReadResult = "not alive at transaction start"
+	SiteDownSinceCommit SiteReadResult = "down between last commit and transaction start"
 )
 
 type Range struct {
@@ -31,6 +48,20 @@ type Range struct {
 	end   int
 }
 
+/* The error of a change to a site which is down, such as telling it the outcome of a transaction. Such a site learns the outcome when it recovers */
+type SiteDownError struct {
+	Site int
+	Err  error
+}
+
+func (e *SiteDownError) Error() string {
+	return fmt.Sprintf("site %d is down: %v", e.Site, e.Err)
+}
+
+func (e *SiteDownError) Unwrap() error {
+	return e.Err
+}
+
 /*
 SiteCoordinator is responsible for managing the data across all sites. It provides interfaces to access and modify the data,
 It also provides the interface to manage site failures and recoveries.
@@ -39,33 +70,73 @@ type SiteCoordinator interface {
 	Fail(site int, time int) error
 	Recover(site int, time int) error
 	Dump() string
+	DumpSite(site int) (string, error)
+	DumpKey(key int) (string, error)
+	DumpHistory(key int) (string, error)
+	DumpAsOf(time int) string
+	QueryState() string
 	ReadActiveSite(site int, key int, time int) (HistoricalValue, error)
+	GetLastCommitted(site int, key int) (HistoricalValue, error)
+	GetSites() []int
 	GetSitesForKey(key int) []int
 	GetActiveSitesForKey(key int) []int
 	GetValidSitesForRead(key int, txStart int) []int
-	VerifySiteWrite(site int, key int, writeTime int, currentTime int) SiteCommitResult
+	VerifySiteRead(site int, key int, txStart int) SiteReadResult
+	VerifySiteWrite(site int, key int, snapshot int, writeTime int, currentTime int) SiteCommitResult
 	CommitSiteWrite(site int, key int, value int, time int) error
+	PrepareSite(site int, tx int, snapshot int, writes []Operation, currentTime int) SiteVote
+	DecideSite(site int, tx int, commit bool, time int) error
+	InDoubt(site int) []int
+	AntiEntropy(repair bool) []Divergence
 }
 
-/* Each site contains a DataManager and a list of time ranges that it was up for, allowing us to track when a site was up/down */
+/*
+Each site contains a DataManager and a list of time ranges that it was up for, allowing us to track when a site was up/down.
+Each DataManager runs on its own goroutine and is reached through messages, which stop once the coordinator is closed
+*/
 type SiteCoordinatorImpl struct {
 	Sites      map[int]DataManager
 	SiteUptime map[int]([]Range)
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+/* Returns the range as "[start, end]", where a start of -1 is shown as init and an end of -1 as now */
+func (r Range) String() string {
+	start, end := "init", "now"
+	if r.start != -1 {
+		start = fmt.Sprint(r.start)
+	}
+	if r.end != -1 {
+		end = fmt.Sprint(r.end)
+	}
+	return fmt.Sprintf("[%s, %s]", start, end)
 }
 
-/* Creates a new SiteCoordinator with the given number of sites */
+/* Creates a new SiteCoordinator with the given number of sites, whose messages are delivered immediately */
 func CreateSiteCoordinator(numSites int) *SiteCoordinatorImpl {
+	return CreateSiteCoordinatorWithNetwork(numSites, DirectNetwork{})
+}
+
+/* Creates a new SiteCoordinator with the given number of sites, reached through the given network. Close must be called to stop the goroutines of the sites */
+func CreateSiteCoordinatorWithNetwork(numSites int, network Network) *SiteCoordinatorImpl {
+	done := make(chan struct{})
 	sites := make(map[int]DataManager)
-	uptimes := make(map[int]([]Range))
 	for i := 1; i <= numSites; i++ {
-		site := CreateDataManager(i)
-		sites[i] = &site
-		uptimes[i] = append(uptimes[i], Range{start: -1, end: -1})
-	}
-	return &SiteCoordinatorImpl{
-		Sites:      sites,
-		SiteUptime: uptimes,
+		sites[i] = StartSite(i, network, done)
 	}
+	return createSiteCoordinator(sites, done)
+}
+
+/* Creates a new SiteCoordinator for sites numbered from 1 which are already running, such as sites in other processes */
+func CreateSiteCoordinatorWithSites(sites map[int]DataManager) *SiteCoordinatorImpl {
+	return createSiteCoordinator(sites, make(chan struct{}))
+}
+
+/* Stops the goroutines of the sites. The coordinator must not be used afterwards */
+func (s *SiteCoordinatorImpl) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
 }
 
 /* Fail a site at the given time. Closes the existing range for a site that is up. */
@@ -94,6 +165,67 @@ func (s *SiteCoordinatorImpl) Dump() string {
 	return strings.Join(results, "\n")
 }
 
+/* Returns a single line representing a snapshot of one site */
+func (s *SiteCoordinatorImpl) DumpSite(site int) (string, error) {
+	dataManager, exists := s.Sites[site]
+	if !exists {
+		return "", fmt.Errorf("site %d does not exist", site)
+	}
+	return dataManager.Dump(), nil
+}
+
+/* Returns one line per site holding the key, with the last committed value of the key at that site */
+func (s *SiteCoordinatorImpl) DumpKey(key int) (string, error) {
+	sites, err := s.getSitesHoldingKey(key)
+	if err != nil {
+		return "", err
+	}
+	results := make([]string, len(sites))
+	for i, site := range sites {
+		results[i] = s.Sites[site].DumpKey(key)
+	}
+	return strings.Join(results, "\n"), nil
+}
+
+/* Returns one line per site holding the key, with every committed version of the key at that site */
+func (s *SiteCoordinatorImpl) DumpHistory(key int) (string, error) {
+	sites, err := s.getSitesHoldingKey(key)
+	if err != nil {
+		return "", err
+	}
+	results := make([]string, len(sites))
+	for i, site := range sites {
+		results[i] = s.Sites[site].DumpHistory(key)
+	}
+	return strings.Join(results, "\n"), nil
+}
+
+/* Returns all lines representing a snapshot of all sites as of the given time. Sites which were down at that time are shown as down */
+func (s *SiteCoordinatorImpl) DumpAsOf(time int) string {
+	results := make([]string, 0, len(s.Sites))
+	for _, site := range utils.GetSortedMapKeys(s.Sites) {
+		if s.wasAliveBetween(site, time, time) {
+			results = append(results, s.Sites[site].DumpAsOf(time))
+		} else {
+			results = append(results, fmt.Sprintf("site %d - down at %d", site, time))
+		}
+	}
+	return strings.Join(results, "\n")
+}
+
+/* Returns all lines representing the uptime history of each site */
+func (s *SiteCoordinatorImpl) QueryState() string {
+	results := []string{"Site uptime:"}
+	for _, site := range utils.GetSortedMapKeys(s.SiteUptime) {
+		ranges := make([]string, 0, len(s.SiteUptime[site]))
+		for _, uptime := range s.SiteUptime[site] {
+			ranges = append(ranges, uptime.String())
+		}
+		results = append(results, fmt.Sprintf("  site %d: %s", site, strings.Join(ranges, ", ")))
+	}
+	return strings.Join(results, "\n")
+}
+
 /* Returns a list of active sites that contain the given key */
 func (s *SiteCoordinatorImpl) GetActiveSitesForKey(key int) []int {
 	readSites := s.GetSitesForKey(key)
@@ -108,21 +240,38 @@ func (s *SiteCoordinatorImpl) GetActiveSitesForKey(key int) []int {
 
 /* Returns a list of valid sites that contain the given key and were alive between the previous commit and the current transaction start */
 func (s *SiteCoordinatorImpl) GetValidSitesForRead(key int, txStart int) []int {
-	readSites := s.GetSitesForKey(key)
 	result := make([]int, 0)
-	if len(readSites) == 1 { // Odd case -> Return the non replicated site
-		result = append(result, readSites[0])
-	} else {
-		for _, site := range readSites { // Even case -> Return sites which were alive between prev commit and Tx start
-			historicRead := s.Sites[site].Read(key, txStart)
-			if s.wasAliveBetween(site, historicRead.time, txStart) {
-				result = append(result, site)
-			}
+	for _, site := range s.GetSitesForKey(key) {
+		if s.VerifySiteRead(site, key, txStart) == SiteReadable {
+			result = append(result, site)
 		}
 	}
 	return result
 }
 
+/*
+Verifies whether a site can service a read of a key for a transaction starting at txStart.
+The non replicated site of an odd key is always valid. Replicated sites must have been alive between the previous commit and the transaction start
+*/
+func (s *SiteCoordinatorImpl) VerifySiteRead(site int, key int, txStart int) SiteReadResult {
+	if len(s.GetSitesForKey(key)) == 1 { // Odd case -> The non replicated site is always valid
+		return SiteReadable
+	}
+	historicRead := s.Sites[site].Read(key, txStart)
+	if s.wasAliveBetween(site, historicRead.time, txStart) {
+		return SiteReadable
+	}
+	if !s.wasAliveBetween(site, txStart, txStart) {
+		return SiteNotAliveAtStart
+	}
+	return SiteDownSinceCommit
+}
+
+/* Returns every site, in order */
+func (s *SiteCoordinatorImpl) GetSites() []int {
+	return utils.GetSortedMapKeys(s.Sites)
+}
+
 /* Returns a list of sites that contain the given key */
 func (s *SiteCoordinatorImpl) GetSitesForKey(key int) []int {
 	if key%2 == 0 {
@@ -140,13 +289,28 @@ func (s *SiteCoordinatorImpl) ReadActiveSite(site int, key int, time int) (Histo
 	return s.Sites[site].Read(key, time), nil
 }
 
-/* Verifies that a site did not go down since and no commit has occured since a given write */
-func (s *SiteCoordinatorImpl) VerifySiteWrite(site int, key int, writeTime int, currentTime int) SiteCommitResult {
+/* Returns the last committed value of a key at a site, whether or not the site is up. This is the value shown by dump */
+func (s *SiteCoordinatorImpl) GetLastCommitted(site int, key int) (HistoricalValue, error) {
+	dataManager, exists := s.Sites[site]
+	if !exists {
+		return HistoricalValue{}, fmt.Errorf("site %d does not exist", site)
+	}
+	if !dataManager.HasKey(key) {
+		return HistoricalValue{}, fmt.Errorf("site %d does not hold x%d", site, key)
+	}
+	return dataManager.GetLastCommitted(key), nil
+}
+
+/*
+Verifies that a site did not go down since a given write, and that no other transaction has committed to the key after the snapshot of the writing transaction (first committer wins).
+The snapshot is the latest time whose commits the transaction observes, usually its start time. Checking against the write time instead would let a transaction overwrite a version committed after its snapshot was taken
+*/
+func (s *SiteCoordinatorImpl) VerifySiteWrite(site int, key int, snapshot int, writeTime int, currentTime int) SiteCommitResult {
 	if !s.wasAliveBetween(site, writeTime, currentTime) {
 		return SiteDown
 	}
 	committedValue := s.Sites[site].GetLastCommitted(key)
-	if committedValue.time < writeTime {
+	if committedValue.time <= snapshot {
 		return SiteOk
 	} else {
 		return SiteStale
@@ -160,11 +324,65 @@ func (s *SiteCoordinatorImpl) CommitSiteWrite(site int, key int, value int, curr
 	return nil
 }
 
+/*
+Asks a site to vote on committing the writes of a transaction, the prepare phase of two-phase commit.
+The site votes against if VerifySiteWrite rejects any write, and otherwise prepares the writes, voting against if it cannot be reached
+*/
+func (s *SiteCoordinatorImpl) PrepareSite(site int, tx int, snapshot int, writes []Operation, currentTime int) SiteVote {
+	prepared := make([]PreparedWrite, len(writes))
+	for i, write := range writes {
+		if result := s.VerifySiteWrite(site, write.key, snapshot, write.time, currentTime); result != SiteOk {
+			return SiteVote{Result: result, Verified: i}
+		}
+		prepared[i] = PreparedWrite{Key: write.key, Value: write.value}
+	}
+	if err := s.Sites[site].Prepare(tx, prepared); err != nil {
+		return SiteVote{Result: SiteUnreachable, Verified: len(writes), Err: err}
+	}
+	return SiteVote{Result: SiteOk, Verified: len(writes)}
+}
+
+/* Tells a site the outcome of a transaction which prepared there. Committed writes take the given time */
+func (s *SiteCoordinatorImpl) DecideSite(site int, tx int, commit bool, time int) error {
+	return s.Sites[site].Decide(tx, commit, time)
+}
+
+/* Returns the transactions which have prepared at a site and not heard their outcome */
+func (s *SiteCoordinatorImpl) InDoubt(site int) []int {
+	return s.Sites[site].InDoubt()
+}
+
 /*
 ******
 Private Methods
 ******
 */
+func createSiteCoordinator(sites map[int]DataManager, done chan struct{}) *SiteCoordinatorImpl {
+	uptimes := make(map[int]([]Range))
+	for site := range sites {
+		uptimes[site] = append(uptimes[site], Range{start: -1, end: -1})
+	}
+	return &SiteCoordinatorImpl{
+		Sites:      sites,
+		SiteUptime: uptimes,
+		done:       done,
+	}
+}
+
+/* Returns the sites holding a copy of the key, or an error if no site holds it */
+func (s *SiteCoordinatorImpl) getSitesHoldingKey(key int) ([]int, error) {
+	sites := make([]int, 0)
+	for _, site := range s.GetSitesForKey(key) {
+		if dataManager, exists := s.Sites[site]; exists && dataManager.HasKey(key) {
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) == 0 {
+		return sites, fmt.Errorf("key x%d does not exist", key)
+	}
+	return sites, nil
+}
+
 func (s *SiteCoordinatorImpl) isActiveSite(site int) bool {
 	uptimeArr := s.SiteUptime[site]
 	return uptimeArr[len(uptimeArr)-1].end == -1
@@ -8,6 +8,10 @@ package domain
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal/utils"
 )
 
 /*
@@ -97,7 +101,11 @@ func (t *TransactionGraph) PurgeGraph(earliestStart int) {
 	}
 }
 
-func (t *TransactionGraph) TryCommitTransaction(tx int, incomingConflicts map[int]ConflictType, outgoingConflicts map[int]ConflictType, time int) bool {
+/*
+Adds a committing transaction and its conflicts to the graph.
+If doing so creates a RW-RW cycle, the transaction is removed again and the offending cycle is returned
+*/
+func (t *TransactionGraph) TryCommitTransaction(tx int, incomingConflicts map[int]ConflictType, outgoingConflicts map[int]ConflictType, time int) (bool, []Edge) {
 	t.AddNode(tx, time)
 	for from, edgeType := range incomingConflicts {
 		t.AddEdge(from, tx, edgeType)
@@ -105,23 +113,28 @@ func (t *TransactionGraph) TryCommitTransaction(tx int, incomingConflicts map[in
 	for to, edgeType := range outgoingConflicts {
 		t.AddEdge(tx, to, edgeType)
 	}
-	if t.FindRWCycles(tx) {
+	if cycle := t.FindRWCycle(tx); cycle != nil {
 		t.RemoveNode(tx)
-		return false
+		return false, cycle
 	}
-	return true
+	return true, nil
 }
 
 /* Checks if RW-RW cycles exist in the graph. Returns true if so and false otherwise */
 func (t *TransactionGraph) FindRWCycles(tx int) bool {
+	return t.FindRWCycle(tx) != nil
+}
+
+/* Returns the first cycle through a transaction which contains consecutive RW edges, or nil if there is none */
+func (t *TransactionGraph) FindRWCycle(tx int) []Edge {
 	visited := make(map[int]bool)
 	cycles := t.findCycles(tx, tx, visited, make([]Edge, 0))
 	for _, cycle := range cycles {
 		if t.findConsecutiveRW(cycle) {
-			return true
+			return cycle
 		}
 	}
-	return false
+	return nil
 }
 
 /* Gets map representation of the graph (used for debugging / testing) */
@@ -143,6 +156,7 @@ func (t *TransactionGraph) GetNodes() []int {
 	return nodes
 }
 
+/* Returns the commit time of a transaction in the graph */
 func (t *TransactionGraph) GetCommitTime(tx int) (int, error) {
 	if time, exists := t.commitTimes[tx]; exists {
 		return time, nil
@@ -150,24 +164,108 @@ func (t *TransactionGraph) GetCommitTime(tx int) (int, error) {
 	return 0, fmt.Errorf("Transaction %d has not committed", tx)
 }
 
+/* Exports the whole graph in Graphviz DOT format. Nodes are labelled with their commit times and edges with their conflict type */
+func (t *TransactionGraph) ExportDot() string {
+	edges := make([]Edge, 0)
+	for _, from := range t.sortedNodes() {
+		for _, to := range utils.GetSortedMapKeys(t.graph[from]) {
+			edges = append(edges, Edge{from, to, t.graph[from][to]})
+		}
+	}
+	return t.toDot("TransactionGraph", t.sortedNodes(), edges)
+}
+
+/* Exports a cycle (as returned by FindRWCycle) in Graphviz DOT format. Transactions no longer in the graph are shown as uncommitted */
+func (t *TransactionGraph) ExportCycleDot(cycle []Edge) string {
+	nodes := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, edge := range cycle {
+		for _, node := range []int{edge.from, edge.to} {
+			if !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return t.toDot("RWCycle", nodes, cycle)
+}
+
+/* Returns the transaction the edge starts from */
+func (e Edge) From() int {
+	return e.from
+}
+
+/* Returns the transaction the edge points to */
+func (e Edge) To() int {
+	return e.to
+}
+
+/* Returns the conflict type of the edge */
+func (e Edge) Type() ConflictType {
+	return e.edgeType
+}
+
 /*
 *************************
 Private Methods
 *************************
 */
 
+/* Returns one line per node in the graph listing its commit time and outgoing edges */
+func (t *TransactionGraph) describe() []string {
+	lines := make([]string, 0)
+	for _, node := range t.sortedNodes() {
+		edges := make([]string, 0)
+		for _, to := range utils.GetSortedMapKeys(t.graph[node]) {
+			edges = append(edges, fmt.Sprintf("-%s-> T%d", t.graph[node][to], to))
+		}
+		lines = append(lines, fmt.Sprintf("  T%d (committed at %d): [%s]", node, t.commitTimes[node], strings.Join(edges, ", ")))
+	}
+	return lines
+}
+
+/* Renders the given nodes and edges as a DOT digraph */
+func (t *TransactionGraph) toDot(name string, nodes []int, edges []Edge) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "digraph %s {\n", name)
+	for _, node := range nodes {
+		if time, exists := t.commitTimes[node]; exists {
+			fmt.Fprintf(&builder, "  T%d [label=\"T%d\\ncommit=%d\"];\n", node, node, time)
+		} else {
+			fmt.Fprintf(&builder, "  T%d [label=\"T%d\\nuncommitted\", style=dashed];\n", node, node)
+		}
+	}
+	for _, edge := range edges {
+		style := ""
+		if edge.edgeType == RW {
+			style = ", color=red"
+		}
+		fmt.Fprintf(&builder, "  T%d -> T%d [label=\"%s\"%s];\n", edge.from, edge.to, edge.edgeType, style)
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+/* Returns the nodes of the graph in ascending order */
+func (t *TransactionGraph) sortedNodes() []int {
+	nodes := t.GetNodes()
+	sort.Ints(nodes)
+	return nodes
+}
+
 /* Finds all cycles in a graph starting from a given node using DFS. Returns a list of paths (which is a list of edges) for inspection */
 func (t *TransactionGraph) findCycles(current int, start int, visited map[int]bool, path []Edge) [][]Edge {
 	if current == start && len(path) > 1 {
-		return append(make([][]Edge, 0), path)
+		// Copy the path, since sibling branches share its backing array
+		return append(make([][]Edge, 0), append([]Edge(nil), path...))
 	}
 	foundCycles := make([][]Edge, 0)
 	if current != start {
 		visited[current] = true
 	}
-	for next, edge := range t.graph[current] {
+	for _, next := range utils.GetSortedMapKeys(t.graph[current]) {
 		if !visited[next] {
-			edge := Edge{current, next, edge}
+			edge := Edge{current, next, t.graph[current][next]}
 			newPath := append(path, edge)
 			foundCycles = append(foundCycles, t.findCycles(next, start, visited, newPath)...)
 		}
@@ -0,0 +1,247 @@
+/**************************
+File: transactionLog.go
+Author: Mingyi Lim
+Description: This file contains the transaction log, which persists the state of the TransactionManager so that it can be rebuilt after its process dies.
+The log records every transaction which begins, the outcome of every transaction which ends, with the operations and conflicts of committed transactions, and every purge of the TransactionGraph.
+An outcome is logged before any site is told it, and a decision which every site has heard is logged as forgotten, so the DecisionLog is rebuilt with only the decisions some site awaits.
+Replaying the log in order rebuilds the committed transactions and the TransactionGraph as they were, so committed transactions stay in the graph for as long as they matter to later commits.
+Transactions which began without ending were in flight when the log ended, and are aborted when the manager is rebuilt. Each record is one line of JSON.
+***************************/
+
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mingyi850/repcrec/internal/history"
+	"github.com/mingyi850/repcrec/internal/utils"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type LogRecordType string
+
+const (
+	LogBegin  LogRecordType = "begin"
+	LogCommit LogRecordType = "commit"
+	LogAbort  LogRecordType = "abort"
+	LogPurge  LogRecordType = "purge"
+	LogForget LogRecordType = "forget"
+)
+
+const restartReason = "transaction manager restarted"
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/*
+A record of the transaction log. Time is the start of a transaction which begins, the time of an outcome or of forgetting it, or the earliest start passed to PurgeGraph.
+Commit records hold the completed operations, reads and conflicts of the transaction, and are written before any site is told to commit
+*/
+type LogRecord struct {
+	Type       LogRecordType        `json:"type"`
+	Tx         int                  `json:"tx,omitempty"`
+	Time       int                  `json:"time"`
+	ReadOnly   bool                 `json:"readOnly,omitempty"`
+	Operations []LoggedOperation    `json:"operations,omitempty"`
+	Reads      []history.Read       `json:"reads,omitempty"`
+	Incoming   map[int]ConflictType `json:"incoming,omitempty"`
+	Outgoing   map[int]ConflictType `json:"outgoing,omitempty"`
+	Reason     string               `json:"reason,omitempty"`
+}
+
+/* A completed operation of a committed transaction */
+type LoggedOperation struct {
+	Type  OperationType `json:"type"`
+	Key   int           `json:"key"`
+	Value int           `json:"value"`
+	Time  int           `json:"time"`
+}
+
+/* Persists the records of a TransactionManager. A record is durable once Append returns */
+type TransactionLog interface {
+	Append(record LogRecord) error
+}
+
+/* A TransactionLog in a file, synced after every record */
+type FileTransactionLog struct {
+	file *os.File
+}
+
+/*
+Opens the transaction log at path, creating it if needed, and returns the records already in it.
+A last record cut short by a crash was never durable, so it is dropped and overwritten
+*/
+func OpenTransactionLog(path string) (*FileTransactionLog, []LogRecord, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	complete := contents[:bytes.LastIndexByte(contents, '\n')+1]
+	records := make([]LogRecord, 0)
+	for line, data := range bytes.Split(bytes.TrimSuffix(complete, []byte("\n")), []byte("\n")) {
+		if len(data) == 0 {
+			continue
+		}
+		var record LogRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, nil, fmt.Errorf("%s, line %d: %v", path, line+1, err)
+		}
+		records = append(records, record)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := file.Truncate(int64(len(complete))); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &FileTransactionLog{file: file}, records, nil
+}
+
+func (l *FileTransactionLog) Append(record LogRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *FileTransactionLog) Close() error {
+	return l.file.Close()
+}
+
+/* Returns the latest time in the records of a log, or -1 if there is none */
+func LatestLogTime(records []LogRecord) int {
+	latest := -1
+	for _, record := range records {
+		latest = max(latest, record.Time)
+	}
+	return latest
+}
+
+/*
+Rebuilds a TransactionManager from the records of its log. New records are appended to log, which may be nil.
+Committed and aborted transactions are restored with their outcome, along with the TransactionGraph and the commit decisions.
+Transactions in flight when the log ended are aborted at the latest time in the log, and sites still prepared for a transaction are told its outcome.
+A site which is down is told when it recovers. Any other error telling a site is returned
+*/
+func RestoreTransactionManager(siteCoordinator SiteCoordinator, records []LogRecord, log TransactionLog) (*TransactionManagerImpl, error) {
+	t := CreateTransactionManagerWithLog(siteCoordinator, log)
+	for _, record := range records {
+		if err := t.replay(record); err != nil {
+			return nil, err
+		}
+	}
+	latest := LatestLogTime(records)
+	for _, tx := range utils.GetSortedMapKeys(t.TransactionMap) {
+		if t.TransactionMap[tx].state == TxActive {
+			t.abortTransactionWithReason(tx, latest, restartReason)
+		}
+	}
+	for _, site := range siteCoordinator.GetSites() {
+		var siteDown *SiteDownError
+		if err := t.resolveInDoubt(site, latest); err != nil && !errors.As(err, &siteDown) { // A site which is down is resolved when it recovers
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Applies a record of the log to the manager */
+func (t *TransactionManagerImpl) replay(record LogRecord) error {
+	if record.Type == LogPurge {
+		t.TransactionGraph.PurgeGraph(record.Time)
+		return nil
+	}
+	if record.Type == LogForget {
+		delete(t.DecisionLog, record.Tx)
+		return nil
+	}
+	if record.Type == LogBegin {
+		t.TransactionMap[record.Tx] = createTransaction(record.Tx, record.Time, t.nextSequence(), record.ReadOnly)
+		return nil
+	}
+	transaction, exists := t.TransactionMap[record.Tx]
+	if !exists {
+		return fmt.Errorf("transaction log ends T%d, which never began", record.Tx)
+	}
+	switch record.Type {
+	case LogCommit:
+		for _, operation := range record.Operations {
+			transaction.appendCompletedOperation(Operation{operation.Type, operation.Key, operation.Value, operation.Time})
+		}
+		transaction.reads = record.Reads
+		transaction.endTime = record.Time
+		transaction.endSequence = t.nextSequence()
+		t.TransactionGraph.AddNode(record.Tx, record.Time)
+		for from, edgeType := range record.Incoming {
+			t.TransactionGraph.AddEdge(from, record.Tx, edgeType)
+		}
+		for to, edgeType := range record.Outgoing {
+			t.TransactionGraph.AddEdge(record.Tx, to, edgeType)
+		}
+		transaction.state = TxCommitted
+		transaction.recordDecision(record.Time, DecisionCommit, -1, -1, "")
+		t.DecisionLog[record.Tx] = CommitDecision{Commit: true, Time: record.Time}
+	case LogAbort:
+		transaction.state = TxAborted
+		transaction.recordDecision(record.Time, DecisionAbort, -1, -1, record.Reason)
+	default:
+		return fmt.Errorf("unknown transaction log record %q", record.Type)
+	}
+	return nil
+}
+
+/* Appends a record to the log of the manager, if it has one */
+func (t *TransactionManagerImpl) appendLog(record LogRecord) error {
+	if t.Log == nil {
+		return nil
+	}
+	return t.Log.Append(record)
+}
+
+/* Logs the commit of a transaction with what later commits need to find their conflicts with it */
+func (t *TransactionManagerImpl) logCommit(transaction *Transaction, incoming map[int]ConflictType, outgoing map[int]ConflictType, time int) error {
+	operations := make([]LoggedOperation, 0)
+	for _, key := range utils.GetSortedMapKeys(transaction.completedOperations) {
+		for _, operation := range transaction.completedOperations[key] {
+			operations = append(operations, LoggedOperation{operation.operationType, operation.key, operation.value, operation.time})
+		}
+	}
+	return t.appendLog(LogRecord{
+		Type:       LogCommit,
+		Tx:         transaction.id,
+		Time:       time,
+		Operations: operations,
+		Reads:      transaction.reads,
+		Incoming:   incoming,
+		Outgoing:   outgoing,
+	})
+}
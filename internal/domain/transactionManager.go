@@ -8,7 +8,11 @@ package domain
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
+	"github.com/mingyi850/repcrec/internal/history"
 	"github.com/mingyi850/repcrec/internal/utils"
 )
 
@@ -20,9 +24,10 @@ Consts and Enums
 type OperationType string
 
 const (
-	Write OperationType = "write"
-	Read  OperationType = "read"
-	End   OperationType = "end"
+	Write    OperationType = "write"
+	Read     OperationType = "read"
+	ReadAsOf OperationType = "readasof"
+	End      OperationType = "end"
 )
 
 type ConflictType int
@@ -33,6 +38,19 @@ const (
 	RW ConflictType = 3
 )
 
+/* Returns the short name of the conflict type (WW, WR or RW) */
+func (c ConflictType) String() string {
+	switch c {
+	case WW:
+		return "WW"
+	case WR:
+		return "WR"
+	case RW:
+		return "RW"
+	}
+	return fmt.Sprintf("ConflictType(%d)", int(c))
+}
+
 type OperationResultType string
 
 const (
@@ -58,7 +76,7 @@ Custom Structs
 *********
 */
 
-/* Operation represents a single operation in a transaction */
+/* Operation represents a single operation in a transaction. ReadAsOf operations hold the requested tick in value while pending, and the value read once completed */
 type Operation struct {
 	operationType OperationType
 	key           int
@@ -66,10 +84,11 @@ type Operation struct {
 	time          int
 }
 
-/* Represents the result of a commit operation. Includes reason if ResultType is Abort */
+/* Represents the result of a commit operation. Includes reason if ResultType is Abort, and the offending cycle in DOT format if the abort was caused by a RW cycle */
 type CommitResult struct {
 	ResultType OperationResultType
 	reason     string
+	cycleDot   string
 }
 
 /* Represents the result of a write operation. Includes sites written to if ResultType is Success */
@@ -78,10 +97,11 @@ type WriteResult struct {
 	Sites      []int
 }
 
-/* Represents the result of a read operation. Includes read value if ResultType is Success */
+/* Represents the result of a read operation. Includes read value if ResultType is Success and reason if ResultType is Abort */
 type ReadResult struct {
 	Value      int
 	ResultType OperationResultType
+	reason     string
 }
 
 /*
@@ -92,6 +112,10 @@ type ReadResult struct {
 3. completedOperations - all operations that have been completed by the transaction. Key is the key of the operation
 4. waitingSites - sites that the transaction is waiting on
 5. state - the state of the transaction
+6. decisions - the decision trail of the transaction, used to explain waits and aborts
+7. readOnly - whether the transaction was started with beginRO. Read-only transactions may read as of a past tick and never write
+8. reads - every completed snapshot read with the version it observed, used to check the committed history for serializability
+9. startSequence and endSequence - the order of its begin and end among all begins and ends, which orders those sharing a time, e.g. on one line of a script
 */
 type Transaction struct {
 	id                  int
@@ -102,6 +126,11 @@ type Transaction struct {
 	waitingSites        map[int]bool
 	state               TransactionState
 	endTime             int
+	decisions           []Decision
+	readOnly            bool
+	reads               []history.Read
+	startSequence       int
+	endSequence         int
 }
 
 /*
@@ -113,11 +142,19 @@ It also provides the interface to recover transactions.
 */
 type TransactionManager interface {
 	Begin(tx int, time int) error
+	BeginRO(tx int, time int) error
 	End(tx int, time int) (CommitResult, error) // Either "commit" or "abort"
 	Write(tx int, key int, value int, time int) (WriteResult, error)
 	Read(tx int, key int, time int) (ReadResult, error) // Returns read value if available
+	ReadAsOf(tx int, key int, asOf int, time int) (ReadResult, error)
 	Recover(site int, time int) error
+	Abort(tx int, time int) error
 	GetTransaction(tx int) (*Transaction, bool, error)
+	GetTransactionGraph() *TransactionGraph
+	Explain(tx int) (string, error)
+	QueryState() string
+	History() history.History
+	SetLogger(logger *utils.Logger)
 }
 
 /*
@@ -127,24 +164,51 @@ type TransactionManager interface {
 2. TransactionMap -> Map of id to a transaction struct
 3. WaitingTransactions -> Set of transactions that are waiting
 4. TransactionGraph -> Graph of transactions and their conflicts
+5. DecisionLog -> The outcome of every transaction which reached the decision of two-phase commit and some prepared site has not heard, told to that site when it recovers
+6. Log -> The TransactionLog the manager can be rebuilt from after a crash, or nil
+7. logger -> Where the manager writes the output of operations it runs itself, such as those resumed when a site recovers
+8. sequence -> The number of begins and ends seen so far, which orders those sharing a time
 */
 type TransactionManagerImpl struct {
 	SiteCoordinator     SiteCoordinator
 	TransactionMap      map[int]*Transaction
 	WaitingTransactions map[int]bool
 	TransactionGraph    TransactionGraph
+	DecisionLog         map[int]CommitDecision
+	Log                 TransactionLog
+	logger              *utils.Logger
+	sequence            int
+}
+
+/* The outcome of a transaction decided by the coordinator of two-phase commit. Time is the commit time of its writes */
+type CommitDecision struct {
+	Commit bool
+	Time   int
 }
 
 /* Creates and returns an instance of the TransactionManager */
 func CreateTransactionManager(SiteCoordinator SiteCoordinator) *TransactionManagerImpl {
+	return CreateTransactionManagerWithLog(SiteCoordinator, nil)
+}
+
+/* Creates a TransactionManager which appends its records to a TransactionLog. See RestoreTransactionManager to rebuild one from its log */
+func CreateTransactionManagerWithLog(SiteCoordinator SiteCoordinator, log TransactionLog) *TransactionManagerImpl {
 	return &TransactionManagerImpl{
 		SiteCoordinator:     SiteCoordinator,
 		TransactionMap:      make(map[int]*Transaction),
 		WaitingTransactions: make(map[int]bool),
 		TransactionGraph:    CreateTransactionGraph(),
+		DecisionLog:         make(map[int]CommitDecision),
+		Log:                 log,
+		logger:              utils.CreateLogger(os.Stdout),
 	}
 }
 
+/* Sets where the manager writes the output of operations it runs itself, such as reads resumed when a site recovers. Stdout by default */
+func (t *TransactionManagerImpl) SetLogger(logger *utils.Logger) {
+	t.logger = logger
+}
+
 /*
 ************
 Transaction Manager Methods
@@ -152,17 +216,12 @@ Transaction Manager Methods
 */
 /* Begins a new transaction with the given id and start time - loads the transactionMap and transactionGraph. */
 func (t *TransactionManagerImpl) Begin(tx int, time int) error {
-	t.TransactionMap[tx] = &Transaction{
-		id:                  tx,
-		startTime:           time,
-		siteWrites:          make(map[int][]Operation),
-		pendingOperations:   make([]Operation, 0),
-		completedOperations: make(map[int][]Operation, 0),
-		waitingSites:        make(map[int]bool),
-		state:               TxActive,
-		endTime:             -1,
-	}
-	return nil
+	return t.begin(tx, time, false)
+}
+
+/* Begins a new read-only transaction with the given id and start time. Read-only transactions may read as of a past tick */
+func (t *TransactionManagerImpl) BeginRO(tx int, time int) error {
+	return t.begin(tx, time, true)
 }
 
 /*
@@ -171,55 +230,88 @@ func (t *TransactionManagerImpl) Begin(tx int, time int) error {
 Performs sanity checks on the transaction
 Verifies that all writes to sites are valid and not stale
 a. If site was down after write to site occured, abort with reason SiteDown
-b. If site has been written to since the write to the site, abort with reason SiteStale
+b. If another transaction has committed to the key since the transaction started, abort with reason SiteStale (first committer wins)
 Checks for RW cycles in the transaction graph
 Commits the transaction if all checks pass
 */
 func (t *TransactionManagerImpl) End(tx int, time int) (CommitResult, error) {
 	transaction, waiting, err := t.GetTransaction(tx)
 	if err != nil {
-		return CommitResult{Wait, ""}, err
+		return CommitResult{Wait, "", ""}, err
 	}
 	if waiting {
 		transaction.appendWaitingOperation(Operation{End, 0, 0, time})
-		return CommitResult{Waiting, ""}, nil
+		return CommitResult{Waiting, "", ""}, nil
 	}
 	if transaction.state != TxActive {
-		return CommitResult{Aborted, "Transaction is not active"}, nil
+		return CommitResult{Aborted, "Transaction is not active", ""}, nil
 	}
 	transaction.endTime = time
-	for site, operations := range transaction.siteWrites {
-		for _, operation := range operations {
-			result := t.SiteCoordinator.VerifySiteWrite(site, operation.key, operation.time, time)
-			switch result {
-			case SiteDown:
-				t.abortTransaction(tx)
-				return CommitResult{Abort, fmt.Sprintf("Site %d was down between write to x%d and commit", site, operation.key)}, nil
-			case SiteStale:
-				t.abortTransaction(tx)
-				return CommitResult{Abort, fmt.Sprintf("Write to x%d was stale at site %d", operation.key, site)}, nil
-			case SiteOk:
-				continue
-			}
+	transaction.endSequence = t.nextSequence()
+	// Prepare phase: every site holding a write votes, and the transaction aborts at the first site voting against it
+	prepared := make([]int, 0, len(transaction.siteWrites))
+	writtenKeys := make([]int, 0)
+	for _, key := range utils.GetSortedMapKeys(transaction.completedOperations) {
+		if transaction.wrote(key) {
+			writtenKeys = append(writtenKeys, key)
+		}
+	}
+	for _, site := range utils.GetSortedMapKeys(transaction.siteWrites) {
+		operations := transaction.siteWrites[site]
+		vote := t.SiteCoordinator.PrepareSite(site, tx, t.snapshotTime(transaction, writtenKeys), operations, time)
+		for _, operation := range operations[:vote.Verified] {
+			transaction.recordDecision(time, DecisionWriteVerified, operation.key, site, fmt.Sprintf("write at %d", operation.time))
+		}
+		if vote.Result == SiteOk {
+			prepared = append(prepared, site)
+			continue
 		}
+		var reason string
+		switch vote.Result {
+		case SiteDown:
+			operation := operations[vote.Verified]
+			transaction.recordDecision(time, DecisionWriteRejected, operation.key, site, fmt.Sprintf("site down between write at %d and commit", operation.time))
+			reason = fmt.Sprintf("Site %d was down between write to x%d and commit", site, operation.key)
+		case SiteStale:
+			operation := operations[vote.Verified]
+			transaction.recordDecision(time, DecisionWriteRejected, operation.key, site, fmt.Sprintf("another transaction committed x%d after start at %d", operation.key, transaction.startTime))
+			reason = fmt.Sprintf("Write to x%d was stale at site %d", operation.key, site)
+		case SiteUnreachable:
+			transaction.recordDecision(time, DecisionWriteRejected, -1, site, fmt.Sprintf("could not prepare: %v", vote.Err))
+			reason = fmt.Sprintf("Site %d could not prepare: %v", site, vote.Err)
+		}
+		t.abortPrepared(transaction, prepared, time, reason)
+		return CommitResult{Abort, reason, ""}, nil
 	}
-	// Purge old transactions
-	t.TransactionGraph.PurgeGraph(t.findEarliestActiveStart())
+	// Purge old transactions. A purge missing from the log only keeps more of the graph after a restart
+	earliestStart := t.findEarliestActiveStart()
+	t.TransactionGraph.PurgeGraph(earliestStart)
+	t.appendLog(LogRecord{Type: LogPurge, Time: earliestStart})
 	// Find new conflicts
 	incomingConflicts, outgoingConflicts, err := t.findTransactionConflicts(tx)
 	if err != nil {
-		return CommitResult{Abort, ""}, err
+		return CommitResult{Abort, "", ""}, err
 	}
-	graphCommitSuccess := t.TransactionGraph.TryCommitTransaction(tx, incomingConflicts, outgoingConflicts, time)
+	graphCommitSuccess, cycle := t.TransactionGraph.TryCommitTransaction(tx, incomingConflicts, outgoingConflicts, time)
 	if !graphCommitSuccess {
-		t.abortTransaction(tx)
-		return CommitResult{Abort, fmt.Sprintf("Tx: %d, RW cycle detected", tx)}, nil
+		transaction.recordDecision(time, DecisionCycleCheck, -1, -1, "failed: "+formatCycle(cycle))
+		reason := fmt.Sprintf("Tx: %d, RW cycle detected", tx)
+		t.abortPrepared(transaction, prepared, time, reason)
+		return CommitResult{Abort, reason, t.TransactionGraph.ExportCycleDot(cycle)}, nil
+	}
+	transaction.recordDecision(time, DecisionCycleCheck, -1, -1, "passed")
+	if err := t.logCommit(transaction, incomingConflicts, outgoingConflicts, time); err != nil {
+		t.TransactionGraph.RemoveNode(tx)
+		reason := fmt.Sprintf("Could not log the commit of T%d: %v", tx, err)
+		t.abortPrepared(transaction, prepared, time, reason)
+		return CommitResult{Abort, reason, ""}, nil
 	}
-	err = t.commitTransaction(tx, time)
+	err = t.commitTransaction(tx, prepared, time)
 	if err != nil {
-		return CommitResult{Abort, err.Error()}, nil
+		return CommitResult{Abort, err.Error(), ""}, nil
 	}
-	return CommitResult{Success, ""}, nil
+	transaction.recordDecision(time, DecisionCommit, -1, -1, "")
+	return CommitResult{Success, "", ""}, nil
 }
 
 /* Writes a value to a key at all available sites holding the key. If the key is not available, waits for the key to become available */
@@ -235,9 +327,13 @@ func (t *TransactionManagerImpl) Write(tx int, key int, value int, time int) (Wr
 	if transaction.state == TxAborted {
 		return WriteResult{Aborted, []int{}}, nil
 	}
+	if transaction.readOnly {
+		return WriteResult{Abort, []int{}}, fmt.Errorf("Transaction %d is read-only", tx)
+	}
 	writeSites := t.SiteCoordinator.GetActiveSitesForKey(key)
 	if len(writeSites) == 0 {
 		possibleWriteSites := t.SiteCoordinator.GetSitesForKey(key)
+		transaction.recordDecision(time, DecisionWait, key, -1, fmt.Sprintf("all sites holding x%d are down, waiting for sites %v", key, possibleWriteSites))
 		t.waitTransaction(tx, possibleWriteSites)
 		// Check if this was already pending operation
 		if len(transaction.pendingOperations) == 0 {
@@ -248,6 +344,7 @@ func (t *TransactionManagerImpl) Write(tx int, key int, value int, time int) (Wr
 	for _, site := range writeSites {
 		transaction.addSiteWrite(site, key, value, time)
 	}
+	transaction.recordDecision(time, DecisionWrite, key, -1, fmt.Sprintf("wrote %d to active sites %v", value, writeSites))
 	t.completeOperation(*transaction, Operation{Write, key, value, time})
 	return WriteResult{Success, writeSites}, nil
 }
@@ -262,34 +359,79 @@ If there are valid sites and the site is up, reads the value from the site
 func (t *TransactionManagerImpl) Read(tx int, key int, time int) (ReadResult, error) {
 	transaction, waiting, err := t.GetTransaction(tx)
 	if err != nil {
-		return ReadResult{-1, Abort}, err
+		return ReadResult{-1, Abort, ""}, err
 	}
+	return t.readSnapshot(transaction, waiting, Operation{Read, key, 0, time}, transaction.startTime)
+}
+
+/*
+	Reads a value from a key as it was committed at a past tick. Only allowed for read-only transactions.
+
+Sites are valid if they were up continuously from the last commit before the tick until the tick, otherwise behaves like Read
+*/
+func (t *TransactionManagerImpl) ReadAsOf(tx int, key int, asOf int, time int) (ReadResult, error) {
+	transaction, waiting, err := t.GetTransaction(tx)
+	if err != nil {
+		return ReadResult{-1, Abort, ""}, err
+	}
+	if !transaction.readOnly {
+		return ReadResult{-1, Abort, ""}, fmt.Errorf("Transaction %d is not read-only", tx)
+	}
+	if asOf >= time {
+		return ReadResult{-1, Abort, ""}, fmt.Errorf("Cannot read x%d as of %d at time %d, tick is not in the past", key, asOf, time)
+	}
+	return t.readSnapshot(transaction, waiting, Operation{ReadAsOf, key, asOf, time}, asOf)
+}
+
+/*
+	Reads a key from the snapshot at the given time on behalf of a read operation.
+
+If there are not valid sites to read from, aborts the transaction immediately
+If there are valid sites but the site is down, waits for the site to recover
+If there are valid sites and the site is up, reads the value from the site
+*/
+func (t *TransactionManagerImpl) readSnapshot(transaction *Transaction, waiting bool, operation Operation, snapshot int) (ReadResult, error) {
+	tx, key, time := transaction.id, operation.key, operation.time
 	if waiting {
-		transaction.appendWaitingOperation(Operation{Read, key, 0, time})
-		return ReadResult{-1, Waiting}, nil
+		transaction.appendWaitingOperation(operation)
+		return ReadResult{-1, Waiting, ""}, nil
 	}
 	if transaction.state == TxAborted {
-		return ReadResult{-1, Aborted}, nil
+		return ReadResult{-1, Aborted, ""}, nil
 	}
-	transactionStart := transaction.startTime
-	siteList := t.SiteCoordinator.GetValidSitesForRead(key, transactionStart)
+	t.recordReadSites(transaction, key, snapshot, time)
+	siteList := t.SiteCoordinator.GetValidSitesForRead(key, snapshot)
 	if len(siteList) == 0 {
-		t.abortTransaction(tx)
-		return ReadResult{-1, Abort}, nil
+		reason := fmt.Sprintf("No site holding x%d was up continuously from its last commit until T%d began", key, tx)
+		if operation.operationType == ReadAsOf {
+			reason = fmt.Sprintf("No site holding x%d was up continuously from its last commit until tick %d", key, snapshot)
+		}
+		t.abortTransactionWithReason(tx, time, reason)
+		return ReadResult{-1, Abort, reason}, nil
+	}
+	visible := snapshot
+	if operation.operationType == Read {
+		visible = t.snapshotTime(transaction, []int{key})
 	}
 	for _, site := range siteList {
-		value, err := t.SiteCoordinator.ReadActiveSite(site, key, transactionStart)
+		value, err := t.SiteCoordinator.ReadActiveSite(site, key, visible)
 		if err == nil {
-			t.completeOperation(*transaction, Operation{Read, key, value.value, time})
-			return ReadResult{value.value, Success}, nil
+			transaction.recordDecision(time, DecisionRead, key, site, fmt.Sprintf("read %d committed at %d", value.value, value.time))
+			// Reads as of a past tick do not observe the transaction's snapshot, so they complete as ReadAsOf, which conflict detection ignores, and are left out of its history
+			t.completeOperation(*transaction, Operation{operation.operationType, key, value.value, time})
+			if operation.operationType == Read {
+				transaction.reads = append(transaction.reads, history.Read{Key: key, Value: value.value, VersionTime: value.time})
+			}
+			return ReadResult{value.value, Success, ""}, nil
 		}
 	}
-	err = t.waitTransaction(tx, siteList)
+	transaction.recordDecision(time, DecisionWait, key, -1, fmt.Sprintf("valid sites %v are down", siteList))
+	err := t.waitTransaction(tx, siteList)
 	// Check if this was already pending operation
 	if len(transaction.pendingOperations) == 0 {
-		transaction.appendWaitingOperation(Operation{Read, key, 0, time})
+		transaction.appendWaitingOperation(operation)
 	}
-	return ReadResult{-1, Wait}, err
+	return ReadResult{-1, Wait, ""}, err
 }
 
 /*
@@ -298,7 +440,10 @@ Looks for transactions which were waiting on the recovered site.
 Runs all pending operations in a single time unit on the site for all transactions that were waiting on the site
 */
 func (t *TransactionManagerImpl) Recover(site int, time int) error {
-	for tx := range t.WaitingTransactions {
+	if err := t.resolveInDoubt(site, time); err != nil {
+		return err
+	}
+	for _, tx := range utils.GetSortedMapKeys(t.WaitingTransactions) { // Sorted so that transactions resume in a deterministic order
 		transaction, waiting, err := t.GetTransaction(tx)
 		if err != nil {
 			return err
@@ -321,6 +466,21 @@ func (t *TransactionManagerImpl) Recover(site int, time int) error {
 	return nil
 }
 
+/* Aborts an active or waiting transaction at the request of its client. Operations it was waiting to run are dropped */
+func (t *TransactionManagerImpl) Abort(tx int, time int) error {
+	transaction, waiting, err := t.GetTransaction(tx)
+	if err != nil {
+		return err
+	}
+	if waiting {
+		if err := t.unwaitTransaction(tx); err != nil {
+			return err
+		}
+		transaction.pendingOperations = nil
+	}
+	return t.abortTransactionWithReason(tx, time, "rolled back by client")
+}
+
 /* Returns the transaction with the given id, a boolean indicating if the transaction is waiting, and an error if the transaction does not exist */
 func (t *TransactionManagerImpl) GetTransaction(tx int) (*Transaction, bool, error) {
 	transaction, exists := t.TransactionMap[tx]
@@ -331,13 +491,73 @@ func (t *TransactionManagerImpl) GetTransaction(tx int) (*Transaction, bool, err
 	return transaction, waiting, nil
 }
 
+/* Returns the state and decision trail of a transaction as printable text */
+func (t *TransactionManagerImpl) Explain(tx int) (string, error) {
+	transaction, _, err := t.GetTransaction(tx)
+	if err != nil {
+		return "", err
+	}
+	return transaction.Explain(), nil
+}
+
+/* Returns a printable snapshot of all transactions, the waiting set and the transaction graph */
+func (t *TransactionManagerImpl) QueryState() string {
+	lines := []string{"Transactions:"}
+	for _, tx := range utils.GetSortedMapKeys(t.TransactionMap) {
+		lines = append(lines, "  "+t.TransactionMap[tx].describe())
+	}
+	waiting := make([]string, 0)
+	for _, tx := range utils.GetSortedMapKeys(t.WaitingTransactions) {
+		waiting = append(waiting, fmt.Sprintf("T%d", tx))
+	}
+	lines = append(lines, fmt.Sprintf("Waiting transactions: [%s]", strings.Join(waiting, " ")))
+	lines = append(lines, "Transaction graph:")
+	lines = append(lines, t.TransactionGraph.describe()...)
+	return strings.Join(lines, "\n")
+}
+
+/* Returns the committed transactions with what each read observed and the final value of each write, in commit order */
+func (t *TransactionManagerImpl) History() history.History {
+	result := history.History{Transactions: make([]history.Transaction, 0)}
+	for _, tx := range utils.GetSortedMapKeys(t.TransactionMap) {
+		transaction := t.TransactionMap[tx]
+		if transaction.state != TxCommitted {
+			continue
+		}
+		writes := make(map[int]int)
+		for key, operations := range transaction.completedOperations {
+			for _, operation := range operations {
+				if operation.operationType == Write {
+					writes[key] = operation.value
+				}
+			}
+		}
+		result.Transactions = append(result.Transactions, history.Transaction{
+			ID:         tx,
+			CommitTime: transaction.endTime,
+			ReadOnly:   transaction.readOnly,
+			Reads:      append([]history.Read{}, transaction.reads...),
+			Writes:     writes,
+		})
+	}
+	sort.SliceStable(result.Transactions, func(i, j int) bool {
+		return result.Transactions[i].CommitTime < result.Transactions[j].CommitTime
+	})
+	return result
+}
+
+/* Returns the graph of committed transactions used for RW cycle detection */
+func (t *TransactionManagerImpl) GetTransactionGraph() *TransactionGraph {
+	return &t.TransactionGraph
+}
+
 /*
 ************************************
 Private Methods for TransactionManagerImpl
 **************************************
 */
-/* Commits a transaction by committing all writes to the sites and updating the transaction state. Removes the transaction from the TransactionGraph */
-func (t *TransactionManagerImpl) commitTransaction(tx int, currentTime int) error {
+/* Commits a transaction by deciding to commit at every site it prepared at and updating the transaction state. Removes the transaction from the TransactionGraph */
+func (t *TransactionManagerImpl) commitTransaction(tx int, prepared []int, currentTime int) error {
 	transaction, waiting, err := t.GetTransaction(tx)
 	if err != nil {
 		return err
@@ -348,19 +568,77 @@ func (t *TransactionManagerImpl) commitTransaction(tx int, currentTime int) erro
 	if transaction.state != TxActive {
 		return fmt.Errorf("Transaction %d is not active", tx)
 	}
-	for site, operations := range transaction.siteWrites {
-		for _, operation := range operations {
-			err := t.SiteCoordinator.CommitSiteWrite(site, operation.key, operation.value, currentTime)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	t.decide(transaction, prepared, true, currentTime)
 	transaction.state = TxCommitted
 	t.removeTransaction(tx)
 	return nil
 }
 
+/*
+Tells every site a transaction prepared at its outcome, which the caller has written to the transaction log, so the decision is final.
+A site which cannot be told is failed, and learns the outcome from the DecisionLog when it recovers. The decision is forgotten once every site has heard it
+*/
+func (t *TransactionManagerImpl) decide(transaction *Transaction, prepared []int, commit bool, time int) {
+	t.DecisionLog[transaction.id] = CommitDecision{Commit: commit, Time: time}
+	acknowledged := true
+	for _, site := range prepared {
+		err := t.SiteCoordinator.DecideSite(site, transaction.id, commit, time)
+		if err == nil {
+			continue
+		}
+		acknowledged = false
+		transaction.recordDecision(time, DecisionInDoubt, -1, site, err.Error())
+		if err := t.SiteCoordinator.Fail(site, time); err != nil { // The site stays up without the outcome, holding the prepared writes
+			detail := fmt.Sprintf("site %d could not be failed: %v", site, err)
+			transaction.recordDecision(time, DecisionInDoubt, -1, site, detail)
+			t.logger.Log(fmt.Sprintf("T%d: %s", transaction.id, detail))
+		}
+	}
+	if acknowledged {
+		t.forgetDecision(transaction.id, time)
+	}
+}
+
+/* Aborts a transaction which may have prepared at sites. The abort is logged before any site is told, like a commit */
+func (t *TransactionManagerImpl) abortPrepared(transaction *Transaction, prepared []int, time int, reason string) {
+	t.abortTransactionWithReason(transaction.id, time, reason)
+	t.decide(transaction, prepared, false, time)
+}
+
+/*
+Tells a recovering site the outcome of every transaction it prepared without hearing the decision. A transaction with no logged decision never committed, so it is aborted.
+Decisions which no site is in doubt about any more are forgotten
+*/
+func (t *TransactionManagerImpl) resolveInDoubt(site int, time int) error {
+	for _, tx := range t.SiteCoordinator.InDoubt(site) {
+		decision, logged := t.DecisionLog[tx]
+		if !logged {
+			decision = CommitDecision{Commit: false, Time: time}
+		}
+		if err := t.SiteCoordinator.DecideSite(site, tx, decision.Commit, decision.Time); err != nil {
+			return err
+		}
+	}
+	inDoubt := make(map[int]bool)
+	for _, site := range t.SiteCoordinator.GetSites() {
+		for _, tx := range t.SiteCoordinator.InDoubt(site) {
+			inDoubt[tx] = true
+		}
+	}
+	for _, tx := range utils.GetSortedMapKeys(t.DecisionLog) {
+		if !inDoubt[tx] {
+			t.forgetDecision(tx, time)
+		}
+	}
+	return nil
+}
+
+/* Drops the decision on a transaction which every site has heard. A drop missing from the log only keeps the decision after a restart, until a site recovers */
+func (t *TransactionManagerImpl) forgetDecision(tx int, time int) {
+	delete(t.DecisionLog, tx)
+	t.appendLog(LogRecord{Type: LogForget, Tx: tx, Time: time})
+}
+
 /* Aborts a transaction and updates the transaction state. Removes transaction from the TransactionGraph */
 func (t *TransactionManagerImpl) abortTransaction(tx int) error {
 	transaction, _, err := t.GetTransaction(tx)
@@ -375,6 +653,84 @@ func (t *TransactionManagerImpl) abortTransaction(tx int) error {
 	return nil
 }
 
+/* Aborts a transaction and records the reason in its decision trail and the log. An abort missing from the log leaves the transaction in flight, so it is aborted after a restart */
+func (t *TransactionManagerImpl) abortTransactionWithReason(tx int, time int, reason string) error {
+	if transaction, exists := t.TransactionMap[tx]; exists {
+		transaction.recordDecision(time, DecisionAbort, -1, -1, reason)
+	}
+	if err := t.abortTransaction(tx); err != nil {
+		return err
+	}
+	t.appendLog(LogRecord{Type: LogAbort, Tx: tx, Time: time, Reason: reason})
+	return nil
+}
+
+/* Begins a transaction, logging it first so that a transaction in flight when the manager dies is known after a restart */
+func (t *TransactionManagerImpl) begin(tx int, time int, readOnly bool) error {
+	if _, exists := t.TransactionMap[tx]; exists {
+		return fmt.Errorf("Transaction %d already exists", tx)
+	}
+	if err := t.appendLog(LogRecord{Type: LogBegin, Tx: tx, Time: time, ReadOnly: readOnly}); err != nil {
+		return err
+	}
+	t.TransactionMap[tx] = createTransaction(tx, time, t.nextSequence(), readOnly)
+	return nil
+}
+
+/* Returns the position of the next begin or end among all begins and ends */
+func (t *TransactionManagerImpl) nextSequence() int {
+	t.sequence++
+	return t.sequence
+}
+
+/*
+Returns the latest time whose commits to the given keys are all in the snapshot of a transaction.
+This is its start time, unless a transaction which ended at that time after it began committed one of the keys, in which case it is the time before
+*/
+func (t *TransactionManagerImpl) snapshotTime(transaction *Transaction, keys []int) int {
+	for _, tx := range t.TransactionGraph.GetNodes() {
+		past, exists := t.TransactionMap[tx]
+		if !exists || past.endTime != transaction.startTime || endedBefore(past, transaction) {
+			continue
+		}
+		for _, key := range keys {
+			if past.wrote(key) {
+				return transaction.startTime - 1
+			}
+		}
+	}
+	return transaction.startTime
+}
+
+func createTransaction(tx int, time int, sequence int, readOnly bool) *Transaction {
+	transaction := &Transaction{
+		id:                  tx,
+		startTime:           time,
+		siteWrites:          make(map[int][]Operation),
+		pendingOperations:   make([]Operation, 0),
+		completedOperations: make(map[int][]Operation, 0),
+		waitingSites:        make(map[int]bool),
+		state:               TxActive,
+		endTime:             -1,
+		decisions:           make([]Decision, 0),
+		readOnly:            readOnly,
+		startSequence:       sequence,
+	}
+	transaction.recordDecision(time, DecisionBegin, -1, -1, "")
+	return transaction
+}
+
+/* Records the sites considered for a read and the reason each excluded site was excluded */
+func (t *TransactionManagerImpl) recordReadSites(transaction *Transaction, key int, snapshot int, time int) {
+	sites := t.SiteCoordinator.GetSitesForKey(key)
+	transaction.recordDecision(time, DecisionReadSites, key, -1, fmt.Sprintf("considered sites %v for snapshot at %d", sites, snapshot))
+	for _, site := range sites {
+		if result := t.SiteCoordinator.VerifySiteRead(site, key, snapshot); result != SiteReadable {
+			transaction.recordDecision(time, DecisionSiteExcluded, key, site, string(result))
+		}
+	}
+}
+
 /* Changes a transaction state to waiting and tracks the sites that the transaction is waiting on */
 func (t *TransactionManagerImpl) waitTransaction(tx int, sites []int) error {
 	transaction, waiting, err := t.GetTransaction(tx)
@@ -413,7 +769,7 @@ func (t *TransactionManagerImpl) unwaitTransaction(tx int) error {
 	return nil
 }
 
-/* Runs all pending operations on a transaction. Truncates the pending operations if the operation requires another wait */
+/* Runs all pending operations on a transaction. Truncates the pending operations if the operation requires another wait, and clears them once all have run */
 func (t *TransactionManagerImpl) runPendingOperations(tx *Transaction, recoverTime int) error {
 	for index, operation := range tx.pendingOperations {
 		switch operation.operationType {
@@ -422,7 +778,7 @@ func (t *TransactionManagerImpl) runPendingOperations(tx *Transaction, recoverTi
 			if err != nil {
 				return err
 			}
-			HandleWriteResult(tx.id, operation.key, result)
+			HandleWriteResult(t.logger, tx.id, operation.key, result)
 			if result.ResultType != Success {
 				tx.truncatePendingOperations(index) //Wait or Abort
 				return nil
@@ -432,7 +788,17 @@ func (t *TransactionManagerImpl) runPendingOperations(tx *Transaction, recoverTi
 			if err != nil {
 				return err
 			}
-			HandleReadResult(tx.id, operation.key, value)
+			HandleReadResult(t.logger, tx.id, operation.key, value)
+			if value.ResultType != Success {
+				tx.truncatePendingOperations(index) //Wait or Abort
+				return nil
+			}
+		case ReadAsOf:
+			value, err := t.ReadAsOf(tx.id, operation.key, operation.value, recoverTime)
+			if err != nil {
+				return err
+			}
+			HandleReadResult(t.logger, tx.id, operation.key, value)
 			if value.ResultType != Success {
 				tx.truncatePendingOperations(index) //Wait or Abort
 				return nil
@@ -442,9 +808,10 @@ func (t *TransactionManagerImpl) runPendingOperations(tx *Transaction, recoverTi
 			if err != nil {
 				return err
 			}
-			HandleCommitResult(tx.id, result)
+			HandleCommitResult(t.logger, tx.id, result)
 		}
 	}
+	tx.pendingOperations = make([]Operation, 0) // Every pending operation has run, so a later wait starts a new list
 	return nil
 }
 
@@ -500,7 +867,8 @@ Finds conflicts between a transaction and all other transactions in the Transact
 1. Only committed transactions are in the TransactionMap
 2. Case 1: WW Conflict -> If another transaction committed first, then it will create an edge to this one. No exceptions here
 3. Case 2: WR Conflict -> If another transaction committed first, if this transaction started after the other transaction committed, then it will create a WR edge to this one
-4. Case 3: RW Conflict -> If another transasction committed first, if this transaction started after the other transaction committed, then it will create a RW edge from this one
+4. Case 3: RW Conflict -> If another transasction committed first, if this transaction started before the other transaction committed, then it will create a RW edge from this one
+A transaction which began at the time another ended started after it if its begin came after the end, e.g. on one line of a script
 */
 func (t *TransactionManagerImpl) findOperationConflicts(operation Operation, transaction Transaction, committedTransactions []int) (map[int]ConflictType, map[int]ConflictType, error) {
 	incomingEdges := make(map[int]ConflictType)
@@ -523,7 +891,7 @@ func (t *TransactionManagerImpl) findOperationConflicts(operation Operation, tra
 			case Read:
 				switch pastOp.operationType {
 				case Write:
-					if pastTransaction.endTime < transaction.startTime { // Current Read started after past write committed
+					if endedBefore(pastTransaction, &transaction) { // Current Read started after past write committed
 						t.mergeConflict(incomingEdges, tx, WR)
 					} else {
 						t.mergeConflict(outgoingEdges, tx, RW)
@@ -556,11 +924,45 @@ func (t *TransactionManagerImpl) mergeConflicts(original map[int]ConflictType, i
 Transaction methods
 **********/
 
+/* Returns the reason a commit was aborted */
+func (c CommitResult) GetReason() string {
+	return c.reason
+}
+
+/* Returns the reason a read was aborted */
+func (r ReadResult) GetReason() string {
+	return r.reason
+}
+
+/* Returns a short representation of an operation, e.g. "W(x4, 111)@5" */
+func (o Operation) String() string {
+	switch o.operationType {
+	case Write:
+		return fmt.Sprintf("W(x%d, %d)@%d", o.key, o.value, o.time)
+	case Read:
+		return fmt.Sprintf("R(x%d)@%d", o.key, o.time)
+	case ReadAsOf:
+		return fmt.Sprintf("R(x%d @ %d)@%d", o.key, o.value, o.time)
+	}
+	return fmt.Sprintf("%s@%d", o.operationType, o.time)
+}
+
 /* Returns the state of the transaction */
 func (tx *Transaction) GetState() TransactionState {
 	return tx.state
 }
 
+/* Returns the value returned by the transaction's most recent completed read of a key, and whether it has read the key */
+func (tx *Transaction) GetLastRead(key int) (int, bool) {
+	operations := tx.completedOperations[key]
+	for i := len(operations) - 1; i >= 0; i-- {
+		if operations[i].operationType == Read || operations[i].operationType == ReadAsOf {
+			return operations[i].value, true
+		}
+	}
+	return -1, false
+}
+
 /* Returns the sites a transaction has written to */
 func (tx *Transaction) GetSiteWrites() map[int][]Operation {
 	return tx.siteWrites
@@ -571,6 +973,32 @@ func (tx *Transaction) GetSiteWrites() map[int][]Operation {
 Private methods
 *************
 */
+/* Returns a single line summary of the transaction for querystate */
+func (tx *Transaction) describe() string {
+	end := "-"
+	if tx.endTime != -1 {
+		end = fmt.Sprint(tx.endTime)
+	}
+	pending := make([]string, 0, len(tx.pendingOperations))
+	for _, operation := range tx.pendingOperations {
+		pending = append(pending, operation.String())
+	}
+	siteWrites := make([]string, 0, len(tx.siteWrites))
+	for _, site := range utils.GetSortedMapKeys(tx.siteWrites) {
+		writes := make([]string, 0, len(tx.siteWrites[site]))
+		for _, operation := range tx.siteWrites[site] {
+			writes = append(writes, operation.String())
+		}
+		siteWrites = append(siteWrites, fmt.Sprintf("%d: [%s]", site, strings.Join(writes, " ")))
+	}
+	state := string(tx.state)
+	if tx.readOnly {
+		state += " (read-only)"
+	}
+	return fmt.Sprintf("T%d: %s, start %d, end %s, pending [%s], waiting sites %v, site writes {%s}",
+		tx.id, state, tx.startTime, end, strings.Join(pending, " "), utils.GetSortedMapKeys(tx.waitingSites), strings.Join(siteWrites, ", "))
+}
+
 /* Appends an operation to the pending operations of a transaction */
 func (tx *Transaction) appendWaitingOperation(operation Operation) error {
 	tx.pendingOperations = append(tx.pendingOperations, operation)
@@ -589,6 +1017,24 @@ func (tx *Transaction) addSiteWrite(site int, key int, value int, time int) erro
 	return nil
 }
 
+/* Returns true if the transaction has completed a write to the key */
+func (tx *Transaction) wrote(key int) bool {
+	for _, operation := range tx.completedOperations[key] {
+		if operation.operationType == Write {
+			return true
+		}
+	}
+	return false
+}
+
+/* Returns true if a transaction ended before another began. Times are compared first, and the order of the end and the begin breaks a tie */
+func endedBefore(past *Transaction, transaction *Transaction) bool {
+	if past.endTime != transaction.startTime {
+		return past.endTime < transaction.startTime
+	}
+	return past.endSequence < transaction.startSequence
+}
+
 /* Truncates the pending operations of a transaction */
 func (tx *Transaction) truncatePendingOperations(index int) {
 	tx.pendingOperations = tx.pendingOperations[index:]
@@ -601,49 +1047,52 @@ Utility Functions
 */
 
 /* Handles the printed output of a read operation */
-func HandleReadResult(tx int, key int, result ReadResult) {
+func HandleReadResult(logger *utils.Logger, tx int, key int, result ReadResult) {
 	switch result.ResultType {
 	case Success:
-		utils.LogRead(tx, key, result.Value)
+		logger.LogRead(tx, key, result.Value)
 	case Abort:
-		utils.LogAbort(tx, "")
+		logger.LogAbort(tx, result.reason)
 	case Wait:
-		utils.LogWait(tx)
+		logger.LogWait(tx)
 	case Waiting:
-		utils.LogWaiting(tx)
+		logger.LogWaiting(tx)
 	case Aborted:
-		utils.LogAborted(tx)
+		logger.LogAborted(tx)
 	}
 }
 
 /* Handles the printed output of a write operation */
-func HandleWriteResult(tx int, key int, result WriteResult) {
+func HandleWriteResult(logger *utils.Logger, tx int, key int, result WriteResult) {
 	switch result.ResultType {
 	case Success:
-		utils.LogWrite(tx, key, result.Sites)
+		logger.LogWrite(tx, key, result.Sites)
 	case Abort:
-		utils.LogAbort(tx, "")
+		logger.LogAbort(tx, "")
 	case Wait:
-		utils.LogWait(tx)
+		logger.LogWait(tx)
 	case Waiting:
-		utils.LogWaiting(tx)
+		logger.LogWaiting(tx)
 	case Aborted:
-		utils.LogAborted(tx)
+		logger.LogAborted(tx)
 	}
 }
 
 /* Handles the printed output of a commit operation */
-func HandleCommitResult(tx int, result CommitResult) {
+func HandleCommitResult(logger *utils.Logger, tx int, result CommitResult) {
 	switch result.ResultType {
 	case Success:
-		utils.LogCommit(tx)
+		logger.LogCommit(tx)
 	case Abort:
-		utils.LogAbort(tx, result.reason)
+		logger.LogAbort(tx, result.reason)
+		if result.cycleDot != "" {
+			logger.LogGraph(tx, result.cycleDot)
+		}
 	case Wait:
-		utils.LogWait(tx)
+		logger.LogWait(tx)
 	case Waiting:
-		utils.LogWaiting(tx)
+		logger.LogWaiting(tx)
 	case Aborted:
-		utils.LogAborted(tx)
+		logger.LogAborted(tx)
 	}
 }
@@ -0,0 +1,78 @@
+/**************************
+File: expect.go
+Author: Mingyi Lim
+Description: This file contains the checks behind expect commands, which let a scenario file verify its own outcome. A failed expectation is reported like any other error with its line number, but never stops the run, so every mismatch in a scenario is reported.
+***************************/
+
+package internal
+
+import (
+	"fmt"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/parser"
+)
+
+/* Transaction state each expectable state word refers to */
+var expectedStates = map[string]domain.TransactionState{
+	"commits": domain.TxCommitted,
+	"aborts":  domain.TxAborted,
+	"waits":   domain.TxWaiting,
+}
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* An expectation which did not hold. Actual describes what was found instead */
+type ExpectationError struct {
+	Actual string
+}
+
+func (e *ExpectationError) Error() string {
+	return "expectation failed, " + e.Actual
+}
+
+/*
+*************************
+Private Methods
+***************************
+*/
+
+/* Checks the expectation of an expect command against the current state. Returns an ExpectationError if it does not hold */
+func (s *simulation) checkExpectation(command parser.Command) error {
+	expectation := command.Expect
+	switch expectation.Kind {
+	case parser.ExpectRead:
+		transaction, _, err := s.transactionManager.GetTransaction(command.Tx())
+		if err != nil {
+			return &ExpectationError{err.Error()}
+		}
+		value, exists := transaction.GetLastRead(command.Key())
+		if !exists {
+			return &ExpectationError{fmt.Sprintf("T%d has not read x%d", command.Tx(), command.Key())}
+		}
+		if value != expectation.Value {
+			return &ExpectationError{fmt.Sprintf("T%d read x%d = %d", command.Tx(), command.Key(), value)}
+		}
+	case parser.ExpectState:
+		transaction, _, err := s.transactionManager.GetTransaction(command.Tx())
+		if err != nil {
+			return &ExpectationError{err.Error()}
+		}
+		if transaction.GetState() != expectedStates[expectation.State] {
+			return &ExpectationError{fmt.Sprintf("T%d is %s", command.Tx(), transaction.GetState())}
+		}
+	case parser.ExpectSiteValue:
+		value, err := s.siteCoordinator.GetLastCommitted(command.Number(), command.Key())
+		if err != nil {
+			return &ExpectationError{err.Error()}
+		}
+		if value.GetValue() != expectation.Value {
+			return &ExpectationError{fmt.Sprintf("site %d has x%d: %d", command.Number(), command.Key(), value.GetValue())}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+/**************************
+File: history.go
+Author: Mingyi Lim
+Description: This file contains the offline serializability checker. It takes the committed history of a run and builds the direct serialization graph (DSG) from it, independently of the TransactionGraph used to decide commits.
+Each read is resolved to the transaction which installed the version it observed. Initial values are installed by a virtual transaction T0.
+
+	ww: Ti installs a version of x and Tj installs the next version of x
+	wr: Tj reads the version of x installed by Ti
+	rw: Ti reads a version of x and Tj installs the next version of x
+
+Cycles are classified as in Adya's isolation levels: G0 (ww edges only), G1c (ww and wr edges) and G2 (at least one rw edge).
+Reads which do not match any committed write are reported as well.
+***************************/
+
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal/utils"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type DependencyType string
+
+const (
+	WW DependencyType = "ww"
+	WR DependencyType = "wr"
+	RW DependencyType = "rw"
+)
+
+type AnomalyType string
+
+const (
+	G0            AnomalyType = "G0"  // Write cycle
+	G1c           AnomalyType = "G1c" // Circular information flow
+	G2            AnomalyType = "G2"  // Anti-dependency cycle
+	IncorrectRead AnomalyType = "incorrect read"
+)
+
+/* Id of the virtual transaction which installs the initial value of every key */
+const InitialTransaction = 0
+
+/* Version time of the initial value of every key */
+const InitialVersion = -1
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A read of a key. VersionTime is the commit time of the version observed, or InitialVersion for the initial value */
+type Read struct {
+	Key         int
+	Value       int
+	VersionTime int
+}
+
+/* A committed transaction. Writes holds the final value written to each key */
+type Transaction struct {
+	ID         int
+	CommitTime int
+	ReadOnly   bool
+	Reads      []Read
+	Writes     map[int]int
+}
+
+/* The committed transactions of a run */
+type History struct {
+	Transactions []Transaction
+}
+
+/* A dependency between two transactions on a key */
+type Edge struct {
+	From int
+	To   int
+	Type DependencyType
+	Key  int
+}
+
+/* Returns the edge as "T1 -rw(x2)-> T2" */
+func (e Edge) String() string {
+	return fmt.Sprintf("T%d -%s(x%d)-> T%d", e.From, e.Type, e.Key, e.To)
+}
+
+/* A violation of serializability. Cycle holds the dependencies forming the cycle, and is empty for incorrect reads */
+type Anomaly struct {
+	Type   AnomalyType
+	Cycle  []Edge
+	Detail string
+}
+
+/* Returns the anomaly as a single line, e.g. "G2: T1 -rw(x2)-> T2 -rw(x4)-> T1" */
+func (a Anomaly) String() string {
+	if len(a.Cycle) == 0 {
+		return fmt.Sprintf("%s: %s", a.Type, a.Detail)
+	}
+	parts := []string{fmt.Sprintf("T%d", a.Cycle[0].From)}
+	for _, edge := range a.Cycle {
+		parts = append(parts, fmt.Sprintf("-%s(x%d)-> T%d", edge.Type, edge.Key, edge.To))
+	}
+	return fmt.Sprintf("%s: %s", a.Type, strings.Join(parts, " "))
+}
+
+/* Returns the transactions involved in the anomaly, in the order they appear in the cycle */
+func (a Anomaly) Transactions() []int {
+	transactions := make([]int, len(a.Cycle))
+	for i, edge := range a.Cycle {
+		transactions[i] = edge.From
+	}
+	return transactions
+}
+
+/* The direct serialization graph of a history and the anomalies found in it */
+type Result struct {
+	Edges     []Edge
+	Anomalies []Anomaly
+}
+
+/* Returns true if no anomalies were found */
+func (r Result) Serializable() bool {
+	return len(r.Anomalies) == 0
+}
+
+/* Returns a report of the check, with one line per anomaly */
+func (r Result) String() string {
+	if r.Serializable() {
+		return fmt.Sprintf("serializable: %d dependencies, no cycles", len(r.Edges))
+	}
+	lines := []string{fmt.Sprintf("not serializable: %d anomalies", len(r.Anomalies))}
+	for _, anomaly := range r.Anomalies {
+		lines = append(lines, "  "+anomaly.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+/* A version of a key, installed by a transaction at its commit time */
+type version struct {
+	writer int
+	time   int
+	value  int
+}
+
+/*
+Checks a history for serializability. Builds the DSG and reports incorrect reads and, for each of G0, G1c and G2,
+the first cycle found. Transactions and keys are visited in order, so the result is deterministic
+*/
+func Check(h History) Result {
+	transactions := make([]Transaction, len(h.Transactions))
+	copy(transactions, h.Transactions)
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].CommitTime != transactions[j].CommitTime {
+			return transactions[i].CommitTime < transactions[j].CommitTime
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+	versions := versionOrder(transactions)
+	for _, transaction := range transactions {
+		for _, read := range transaction.Reads {
+			if _, exists := versions[read.Key]; !exists { // Only the initial version of keys which are read but never written
+				versions[read.Key] = []version{{InitialTransaction, InitialVersion, -1}}
+			}
+		}
+	}
+	result := Result{Edges: make([]Edge, 0), Anomalies: make([]Anomaly, 0)}
+	edges := make(map[Edge]bool)
+	addEdge := func(edge Edge) {
+		if edge.From != edge.To && !edges[edge] {
+			edges[edge] = true
+			result.Edges = append(result.Edges, edge)
+		}
+	}
+	for _, key := range utils.GetSortedMapKeys(versions) {
+		for i := 1; i < len(versions[key]); i++ {
+			addEdge(Edge{versions[key][i-1].writer, versions[key][i].writer, WW, key})
+		}
+	}
+	for _, transaction := range transactions {
+		for _, read := range transaction.Reads {
+			index, err := findVersion(versions[read.Key], read)
+			if err != nil {
+				result.Anomalies = append(result.Anomalies, Anomaly{IncorrectRead, nil, fmt.Sprintf("T%d read x%d = %d: %v", transaction.ID, read.Key, read.Value, err)})
+				continue
+			}
+			observed := versions[read.Key][index]
+			addEdge(Edge{observed.writer, transaction.ID, WR, read.Key})
+			if index+1 < len(versions[read.Key]) {
+				addEdge(Edge{transaction.ID, versions[read.Key][index+1].writer, RW, read.Key})
+			}
+		}
+	}
+	graph := make(map[int][]Edge)
+	for _, edge := range result.Edges {
+		graph[edge.From] = append(graph[edge.From], edge)
+	}
+	checks := []struct {
+		anomalyType AnomalyType
+		allowed     map[DependencyType]bool
+		required    DependencyType
+	}{
+		{G0, map[DependencyType]bool{WW: true}, WW},
+		{G1c, map[DependencyType]bool{WW: true, WR: true}, WR},
+		{G2, map[DependencyType]bool{WW: true, WR: true, RW: true}, RW},
+	}
+	for _, check := range checks {
+		if cycle := findCycle(graph, result.Edges, check.allowed, check.required); cycle != nil {
+			result.Anomalies = append(result.Anomalies, Anomaly{check.anomalyType, cycle, ""})
+		}
+	}
+	return result
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Returns the versions of each key in commit order, starting with the initial version */
+func versionOrder(transactions []Transaction) map[int][]version {
+	versions := make(map[int][]version)
+	for _, transaction := range transactions {
+		for key, value := range transaction.Writes {
+			if _, exists := versions[key]; !exists {
+				versions[key] = []version{{InitialTransaction, InitialVersion, -1}}
+			}
+			versions[key] = append(versions[key], version{transaction.ID, transaction.CommitTime, value})
+		}
+	}
+	return versions
+}
+
+/* Returns the index of the version observed by a read, or an error if no committed write matches it */
+func findVersion(versions []version, read Read) (int, error) {
+	if read.VersionTime == InitialVersion {
+		return 0, nil
+	}
+	found := -1
+	for i, candidate := range versions {
+		if candidate.time != read.VersionTime || candidate.writer == InitialTransaction {
+			continue
+		}
+		if found != -1 {
+			return -1, fmt.Errorf("T%d and T%d both committed x%d at %d", versions[found].writer, candidate.writer, read.Key, read.VersionTime)
+		}
+		found = i
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("no committed transaction wrote x%d at %d", read.Key, read.VersionTime)
+	}
+	if versions[found].value != read.Value {
+		return -1, fmt.Errorf("T%d committed x%d = %d at %d", versions[found].writer, read.Key, versions[found].value, read.VersionTime)
+	}
+	return found, nil
+}
+
+/*
+Finds a cycle using only allowed dependency types which contains at least one edge of the required type.
+For each required edge u -> v, searches for a path from v back to u
+*/
+func findCycle(graph map[int][]Edge, edges []Edge, allowed map[DependencyType]bool, required DependencyType) []Edge {
+	for _, edge := range edges {
+		if edge.Type != required {
+			continue
+		}
+		if path := findPath(graph, edge.To, edge.From, allowed); path != nil {
+			return append([]Edge{edge}, path...)
+		}
+	}
+	return nil
+}
+
+/* Returns the edges of a shortest path from one transaction to another using only allowed dependency types, or nil */
+func findPath(graph map[int][]Edge, from int, to int, allowed map[DependencyType]bool) []Edge {
+	previous := map[int]Edge{}
+	visited := map[int]bool{from: true}
+	queue := []int{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == to {
+			path := make([]Edge, 0)
+			for node := to; node != from; node = previous[node].From {
+				path = append([]Edge{previous[node]}, path...)
+			}
+			return path
+		}
+		for _, edge := range graph[current] {
+			if allowed[edge.Type] && !visited[edge.To] {
+				visited[edge.To] = true
+				previous[edge.To] = edge
+				queue = append(queue, edge.To)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+/**************************
+File: http.go
+Author: Mingyi Lim
+Description: This file contains the HTTP API of the server, which exposes the TransactionManager and SiteCoordinator as JSON endpoints:
+
+	POST /tx                   begins a transaction, body {"id": 3, "readOnly": false}. Both fields are optional
+	POST /tx/{id}/read         reads a key, body {"key": 4} or {"key": 4, "asOf": 7}
+	POST /tx/{id}/write        writes a key, body {"key": 4, "value": 101}
+	POST /tx/{id}/commit       ends a transaction
+	POST /sites/{n}/fail       fails a site
+	POST /sites/{n}/recover    recovers a site, resuming operations which waited for it
+	GET  /dump                 returns the last committed value of every key at every site
+
+Each POST runs at the next tick of the clock shared with the TCP clients, and the response holds the tick it ran at.
+Outcomes of operations, including aborts and waits, are answered with 200 and the OperationResultType. Requests which cannot run are answered with {"error": "..."}:
+400 for a malformed request, 404 for an unknown transaction, site or key, and 409 for a request the TransactionManager rejects. Such requests do not take a tick.
+***************************/
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* The response to a POST. Fields which do not apply to the endpoint are left out */
+type operationResponse struct {
+	Tick   int                        `json:"tick"`
+	Tx     int                        `json:"tx,omitempty"`
+	Site   int                        `json:"site,omitempty"`
+	Key    int                        `json:"key,omitempty"`
+	Result domain.OperationResultType `json:"result"`
+	Reason string                     `json:"reason,omitempty"`
+	Value  *int                       `json:"value,omitempty"`
+	Sites  []int                      `json:"sites,omitempty"`
+	Output []string                   `json:"output,omitempty"` // Lines logged by the request, such as reads resumed by a recovery
+}
+
+type siteDump struct {
+	Site   int            `json:"site"`
+	Up     bool           `json:"up"`
+	Values map[string]int `json:"values"`
+}
+
+type dumpResponse struct {
+	Tick  int        `json:"tick"` // The tick the next request will run at
+	Sites []siteDump `json:"sites"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+/* An error which is answered with the given status code */
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+/* Returns the HTTP API of the server. It shares the database and the clock with the TCP clients */
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tx", s.handleBegin)
+	mux.HandleFunc("POST /tx/{id}/read", s.handleRead)
+	mux.HandleFunc("POST /tx/{id}/write", s.handleWrite)
+	mux.HandleFunc("POST /tx/{id}/commit", s.handleCommit)
+	mux.HandleFunc("POST /sites/{n}/fail", s.handleFail)
+	mux.HandleFunc("POST /sites/{n}/recover", s.handleRecover)
+	mux.HandleFunc("GET /dump", s.handleDump)
+	return mux
+}
+
+/*
+*************************
+Private Methods
+***************************
+*/
+
+func (s *Server) handleBegin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID       *int `json:"id"`
+		ReadOnly bool `json:"readOnly"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		writeResponse(w, operationResponse{}, err)
+		return
+	}
+	response, err := s.atNextTick(func(time int, response *operationResponse) error {
+		tx := s.nextTransaction()
+		if body.ID != nil {
+			tx = *body.ID
+		}
+		if tx < 1 {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("invalid transaction id %d", tx)}
+		}
+		begin := s.simulation.transactionManager.Begin
+		if body.ReadOnly {
+			begin = s.simulation.transactionManager.BeginRO
+		}
+		if err := begin(tx, time); err != nil {
+			return &httpError{http.StatusConflict, err}
+		}
+		response.Tx = tx
+		response.Result = domain.Success
+		return nil
+	})
+	writeResponse(w, response, err)
+}
+
+func (s *Server) handleRead(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Key  int  `json:"key"`
+		AsOf *int `json:"asOf"`
+	}
+	tx, err := pathNumber(r, "id", "T")
+	if err == nil {
+		err = decodeBody(r, &body)
+	}
+	if err != nil {
+		writeResponse(w, operationResponse{}, err)
+		return
+	}
+	response, err := s.atNextTick(func(time int, response *operationResponse) error {
+		if err := s.checkOperation(tx, &body.Key); err != nil {
+			return err
+		}
+		var result domain.ReadResult
+		var err error
+		if body.AsOf != nil {
+			result, err = s.simulation.transactionManager.ReadAsOf(tx, body.Key, *body.AsOf, time)
+		} else {
+			result, err = s.simulation.transactionManager.Read(tx, body.Key, time)
+		}
+		if err != nil {
+			return &httpError{http.StatusConflict, err}
+		}
+		response.Tx, response.Key = tx, body.Key
+		response.Result, response.Reason = result.ResultType, result.GetReason()
+		if result.ResultType == domain.Success {
+			response.Value = &result.Value
+		}
+		return nil
+	})
+	writeResponse(w, response, err)
+}
+
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Key   int  `json:"key"`
+		Value *int `json:"value"`
+	}
+	tx, err := pathNumber(r, "id", "T")
+	if err == nil {
+		err = decodeBody(r, &body)
+	}
+	if err == nil && body.Value == nil {
+		err = &httpError{http.StatusBadRequest, fmt.Errorf("missing value")}
+	}
+	if err != nil {
+		writeResponse(w, operationResponse{}, err)
+		return
+	}
+	response, err := s.atNextTick(func(time int, response *operationResponse) error {
+		if err := s.checkOperation(tx, &body.Key); err != nil {
+			return err
+		}
+		result, err := s.simulation.transactionManager.Write(tx, body.Key, *body.Value, time)
+		if err != nil {
+			return &httpError{http.StatusConflict, err}
+		}
+		response.Tx, response.Key = tx, body.Key
+		response.Result, response.Sites = result.ResultType, result.Sites
+		return nil
+	})
+	writeResponse(w, response, err)
+}
+
+func (s *Server) handleCommit(w http.ResponseWriter, r *http.Request) {
+	tx, err := pathNumber(r, "id", "T")
+	if err != nil {
+		writeResponse(w, operationResponse{}, err)
+		return
+	}
+	response, err := s.atNextTick(func(time int, response *operationResponse) error {
+		if err := s.checkOperation(tx, nil); err != nil {
+			return err
+		}
+		result, err := s.simulation.transactionManager.End(tx, time)
+		if err != nil {
+			return &httpError{http.StatusConflict, err}
+		}
+		response.Tx = tx
+		response.Result, response.Reason = result.ResultType, result.GetReason()
+		return nil
+	})
+	writeResponse(w, response, err)
+}
+
+func (s *Server) handleFail(w http.ResponseWriter, r *http.Request) {
+	s.handleSite(w, r, func(site int, time int) error {
+		return s.simulation.siteCoordinator.Fail(site, time)
+	})
+}
+
+func (s *Server) handleRecover(w http.ResponseWriter, r *http.Request) {
+	s.handleSite(w, r, func(site int, time int) error {
+		if err := s.simulation.siteCoordinator.Recover(site, time); err != nil {
+			return err
+		}
+		return s.simulation.transactionManager.Recover(site, time)
+	})
+}
+
+/* Runs a site event at the next tick. The site is checked first, as the SiteCoordinator expects an existing site */
+func (s *Server) handleSite(w http.ResponseWriter, r *http.Request, event func(site int, time int) error) {
+	site, err := pathNumber(r, "n", "")
+	if err != nil {
+		writeResponse(w, operationResponse{}, err)
+		return
+	}
+	response, err := s.atNextTick(func(time int, response *operationResponse) error {
+		if !slices.Contains(s.simulation.siteCoordinator.GetSites(), site) {
+			return &httpError{http.StatusNotFound, fmt.Errorf("site %d does not exist", site)}
+		}
+		if err := event(site, time); err != nil {
+			return &httpError{http.StatusConflict, err}
+		}
+		response.Site = site
+		response.Result = domain.Success
+		return nil
+	})
+	writeResponse(w, response, err)
+}
+
+func (s *Server) handleDump(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	siteCoordinator := s.simulation.siteCoordinator
+	dumps := make(map[int]*siteDump)
+	sites := make([]int, 0)
+	for key := 1; key <= domain.NumKeys; key++ {
+		active := siteCoordinator.GetActiveSitesForKey(key)
+		for _, site := range siteCoordinator.GetSitesForKey(key) {
+			if _, exists := dumps[site]; !exists {
+				dumps[site] = &siteDump{Site: site, Values: make(map[string]int)}
+				sites = append(sites, site)
+			}
+			value, err := siteCoordinator.GetLastCommitted(site, key)
+			if err != nil {
+				continue
+			}
+			dumps[site].Values[fmt.Sprintf("x%d", key)] = value.GetValue()
+			dumps[site].Up = dumps[site].Up || slices.Contains(active, site)
+		}
+	}
+	slices.Sort(sites)
+	response := dumpResponse{Tick: s.simulation.clock.Now(), Sites: make([]siteDump, len(sites))}
+	for i, site := range sites {
+		response.Sites[i] = *dumps[site]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+/*
+Runs a request at the next tick, holding the mutex so that it is ordered with the lines of TCP clients. Output logged while it runs is returned in the response,
+except output about transactions begun by a TCP client, which goes to that client. The tick is only taken if the request succeeds
+*/
+func (s *Server) atNextTick(run func(time int, response *operationResponse) error) (operationResponse, error) {
+	touched := make(map[*connection]bool)
+	defer func() { // Runs once the server is unlocked
+		for client := range touched {
+			client.flush()
+		}
+	}()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var output strings.Builder
+	defer s.routeOutput(&output, touched)()
+	response := operationResponse{Tick: s.simulation.clock.Now()}
+	if err := run(s.simulation.clock.Now(), &response); err != nil {
+		return response, err
+	}
+	s.simulation.tick()
+	if text := strings.TrimSuffix(output.String(), "\n"); text != "" {
+		response.Output = strings.Split(text, "\n")
+	}
+	return response, nil
+}
+
+/* Returns an error if the transaction does not exist, or if a key is given which does not exist */
+func (s *Server) checkOperation(tx int, key *int) error {
+	if _, _, err := s.simulation.transactionManager.GetTransaction(tx); err != nil {
+		return &httpError{http.StatusNotFound, err}
+	}
+	if key != nil && (*key < 1 || *key > domain.NumKeys) {
+		return &httpError{http.StatusNotFound, fmt.Errorf("key x%d does not exist", *key)}
+	}
+	return nil
+}
+
+/* Returns the smallest transaction id which has not been used */
+func (s *Server) nextTransaction() int {
+	tx := 1
+	for {
+		if _, _, err := s.simulation.transactionManager.GetTransaction(tx); err != nil {
+			return tx
+		}
+		tx++
+	}
+}
+
+/* Parses a number from the path, which may be written with a prefix, e.g. both "T3" and "3" for transaction 3 */
+func pathNumber(r *http.Request, name string, prefix string) (int, error) {
+	text := r.PathValue(name)
+	number, err := strconv.Atoi(strings.TrimPrefix(text, prefix))
+	if err != nil {
+		return 0, &httpError{http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, text)}
+	}
+	return number, nil
+}
+
+/* Decodes a JSON body. An empty body leaves every field at its zero value */
+func decodeBody(r *http.Request, body any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(body); err != nil && !errors.Is(err, io.EOF) {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("invalid body: %v", err)}
+	}
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, response operationResponse, err error) {
+	if err == nil {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	status := http.StatusInternalServerError
+	var requestError *httpError
+	if errors.As(err, &requestError) {
+		status = requestError.status
+	}
+	writeJSON(w, status, errorResponse{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,181 @@
+/**************************
+File: ast.go
+Author: Mingyi Lim
+Description: This file contains the syntax tree produced by the parser. A script is a list of lines, each holding the commands issued at a single tick. Every command and argument records the line and column it was parsed from.
+***************************/
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type ArgKind int
+
+const (
+	TxArg   ArgKind = iota // T1
+	KeyArg                 // x4
+	NumArg                 // 111
+	TickArg                // @ 12
+)
+
+/* Returns the placeholder used for the argument kind in usage messages */
+func (k ArgKind) String() string {
+	switch k {
+	case TxArg:
+		return "Tn"
+	case KeyArg:
+		return "xK"
+	case NumArg:
+		return "n"
+	case TickArg:
+		return "@ t"
+	}
+	return "?"
+}
+
+/* Kinds of expectation checked by expect commands */
+type ExpectKind int
+
+const (
+	ExpectRead      ExpectKind = iota // expect R(T1, x2) = 20
+	ExpectState                       // expect T2 aborts
+	ExpectSiteValue                   // expect dump site 4 x3: 111
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Position of a token in the input. Lines and columns start at 1. File is empty for the main input and holds the path for included files */
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s, line %d, column %d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+/* A single argument of a command, e.g. T1 is {TxArg, 1} */
+type Arg struct {
+	Pos   Position
+	Kind  ArgKind
+	Value int
+}
+
+func (a Arg) String() string {
+	switch a.Kind {
+	case TxArg:
+		return fmt.Sprintf("T%d", a.Value)
+	case KeyArg:
+		return fmt.Sprintf("x%d", a.Value)
+	case TickArg:
+		return fmt.Sprintf("@ %d", a.Value)
+	}
+	return fmt.Sprint(a.Value)
+}
+
+/*
+A single command, e.g. W(T1, x4, 111) is {"W", [T1, x4, 111]}
+Expect commands also hold the expectation, e.g. expect dump site 4 x3: 111 is {"expect", [4, x3], {ExpectSiteValue, 111}}
+*/
+type Command struct {
+	Pos    Position
+	Name   string
+	Args   []Arg
+	Expect *Expectation
+}
+
+/* The outcome an expect command checks for. State is commits, aborts or waits for ExpectState. Value is the expected value otherwise */
+type Expectation struct {
+	Kind  ExpectKind
+	State string
+	Value int
+}
+
+/* Returns the value of the first argument of the given kind, and whether such an argument exists */
+func (c Command) Arg(kind ArgKind) (int, bool) {
+	for _, arg := range c.Args {
+		if arg.Kind == kind {
+			return arg.Value, true
+		}
+	}
+	return -1, false
+}
+
+/* Returns the transaction id of the command, or -1 if it has none */
+func (c Command) Tx() int {
+	tx, _ := c.Arg(TxArg)
+	return tx
+}
+
+/* Returns the key of the command, or -1 if it has none */
+func (c Command) Key() int {
+	key, _ := c.Arg(KeyArg)
+	return key
+}
+
+/* Returns the numeric argument (value or site) of the command, or -1 if it has none */
+func (c Command) Number() int {
+	number, _ := c.Arg(NumArg)
+	return number
+}
+
+/* Returns the command in the syntax it was written in, e.g. "R(T1, x4 @ 12)" */
+func (c Command) String() string {
+	if c.Expect != nil {
+		return c.expectString()
+	}
+	var builder strings.Builder
+	builder.WriteString(c.Name)
+	builder.WriteString("(")
+	for i, arg := range c.Args {
+		if i > 0 && arg.Kind == TickArg {
+			builder.WriteString(" ")
+		} else if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(arg.String())
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
+
+/* Returns an expect command in the syntax it was written in */
+func (c Command) expectString() string {
+	switch c.Expect.Kind {
+	case ExpectRead:
+		return fmt.Sprintf("expect R(T%d, x%d) = %d", c.Tx(), c.Key(), c.Expect.Value)
+	case ExpectState:
+		return fmt.Sprintf("expect T%d %s", c.Tx(), c.Expect.State)
+	}
+	return fmt.Sprintf("expect dump site %d x%d: %d", c.Number(), c.Key(), c.Expect.Value)
+}
+
+/*
+All commands issued on a single input line. The commands of a line are executed at the same tick
+Lines produced by macros and loops keep the file and line number they were written at
+*/
+type Line struct {
+	File     string
+	Number   int
+	Commands []Command
+}
+
+/* A parsed script. Lines without commands (blank lines and comments) are omitted */
+type Script struct {
+	Lines []Line
+}
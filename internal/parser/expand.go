@@ -0,0 +1,556 @@
+/**************************
+File: expand.go
+Author: Mingyi Lim
+Description: This file contains the expander for includes, macros and loops. They are expanded into plain lines of commands before the lines are parsed, so the simulation only ever executes plain commands.
+
+	include "file.txt"               // expands the lines of file.txt, relative to the including file
+	macro name(a, b) { ... }         // defines a macro. Calling name(T1, x4) expands its body with $a = T1 and $b = x4
+	repeat N [as i] { ... }          // expands the body N times with $i = 1 ... N
+
+Variables are referenced inside identifiers and numbers, e.g. T$i, x$b or $(i*10+2). Expressions support +, - and * on integers.
+Each line of an expanded body is executed at its own tick. A body may also be written on the same line as its braces.
+Includes and macro definitions are only allowed at the top level of a file.
+***************************/
+
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Maximum depth of nested macro calls, which stops recursive macros from expanding forever */
+const maxExpansionDepth = 64
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A line of tokens, always terminated by a TokenEOL token */
+type sourceLine struct {
+	file   string
+	number int
+	tokens []Token
+}
+
+/* Produces lines of tokens, either from an input or from the body of a block */
+type lineReader interface {
+	readLine() (sourceLine, bool)
+}
+
+/* Reads and tokenizes lines from an input. dir is the directory includes are resolved against */
+type inputReader struct {
+	scanner   *bufio.Scanner
+	file      string
+	dir       string
+	line      int
+	inComment bool
+	parser    *Parser
+}
+
+/* Reads lines from the body of a block */
+type bodyReader struct {
+	lines []sourceLine
+	index int
+}
+
+/* A macro definition. The body is expanded each time the macro is called */
+type macro struct {
+	params []string
+	body   []sourceLine
+}
+
+/* Expands includes, macros and loops into plain lines of commands */
+type expander struct {
+	input    *inputReader
+	queue    []sourceLine
+	macros   map[string]*macro
+	includes []string // Stack of files being included, used to detect include cycles
+	parser   *Parser
+}
+
+/* Creates and returns an expander reading from the given input */
+func createExpander(input io.Reader, dir string, parser *Parser) *expander {
+	return &expander{
+		input:  createInputReader(input, "", dir, parser),
+		queue:  make([]sourceLine, 0),
+		macros: make(map[string]*macro),
+		parser: parser,
+	}
+}
+
+func createInputReader(input io.Reader, file string, dir string, parser *Parser) *inputReader {
+	return &inputReader{scanner: bufio.NewScanner(input), file: file, dir: dir, parser: parser}
+}
+
+/* Returns the next expanded line. Lines expand lazily, so interactive input is executed as it is entered */
+func (e *expander) next() (sourceLine, bool) {
+	for len(e.queue) == 0 {
+		line, ok := e.input.readLine()
+		if !ok {
+			return sourceLine{}, false
+		}
+		e.queue = append(e.queue, e.expandLine(line, e.input, map[string]string{}, 0)...)
+	}
+	line := e.queue[0]
+	e.queue = e.queue[1:]
+	return line, true
+}
+
+/* Returns any error reading the input */
+func (e *expander) err() error {
+	return e.input.scanner.Err()
+}
+
+func (r *inputReader) readLine() (sourceLine, bool) {
+	if !r.scanner.Scan() {
+		if r.inComment {
+			r.parser.errorAt(Position{r.file, r.line + 1, 1}, "unterminated block comment")
+			r.inComment = false
+		}
+		return sourceLine{}, false
+	}
+	r.line++
+	return sourceLine{r.file, r.line, tokenize(r.scanner.Text(), r.file, r.line, &r.inComment)}, true
+}
+
+func (r *bodyReader) readLine() (sourceLine, bool) {
+	if r.index >= len(r.lines) {
+		return sourceLine{}, false
+	}
+	r.index++
+	return r.lines[r.index-1], true
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/*
+Expands a single line read from reader, given the variables in scope. Blocks opened on the line read the rest of their body from reader.
+depth is the number of enclosing blocks, which is 0 at the top level of a file.
+*/
+func (e *expander) expandLine(line sourceLine, reader lineReader, variables map[string]string, depth int) []sourceLine {
+	first := line.tokens[0]
+	if first.Type == TokenIdent {
+		switch first.Text {
+		case "include":
+			if depth > 0 {
+				e.parser.errorAt(first.Pos, "include is only allowed at the top level")
+				return nil
+			}
+			return e.include(line, reader)
+		case "macro":
+			if depth > 0 {
+				e.parser.errorAt(first.Pos, "macro definitions are only allowed at the top level")
+				e.readBlock(line, reader)
+				return nil
+			}
+			e.defineMacro(line, reader)
+			return nil
+		case "repeat":
+			return e.repeat(line, reader, variables, depth)
+		}
+		if _, exists := e.macros[first.Text]; exists {
+			return e.callMacro(line, variables, depth)
+		}
+	}
+	tokens, ok := e.substitute(line.tokens, variables)
+	if !ok {
+		return nil
+	}
+	return []sourceLine{{line.file, line.number, tokens}}
+}
+
+/* Expands include "file". The path is resolved against the directory of the including file */
+func (e *expander) include(line sourceLine, reader lineReader) []sourceLine {
+	tokens := line.tokens
+	if tokens[1].Type != TokenString || tokens[2].Type != TokenEOL {
+		e.parser.errorAt(tokens[0].Pos, `expected include "file"`)
+		return nil
+	}
+	dir := e.input.dir
+	if input, ok := reader.(*inputReader); ok {
+		dir = input.dir
+	}
+	path := filepath.Join(dir, tokens[1].Text)
+	for _, included := range e.includes {
+		if included == path {
+			e.parser.errorAt(tokens[1].Pos, "include cycle: %s includes itself", tokens[1].Text)
+			return nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		e.parser.errorAt(tokens[1].Pos, "cannot include %q: %v", tokens[1].Text, err)
+		return nil
+	}
+	defer file.Close()
+	e.includes = append(e.includes, path)
+	defer func() { e.includes = e.includes[:len(e.includes)-1] }()
+	included := createInputReader(file, path, filepath.Dir(path), e.parser)
+	lines := make([]sourceLine, 0)
+	for {
+		includedLine, ok := included.readLine()
+		if !ok {
+			break
+		}
+		lines = append(lines, e.expandLine(includedLine, included, map[string]string{}, 0)...)
+	}
+	if err := included.scanner.Err(); err != nil {
+		e.parser.errorAt(tokens[1].Pos, "cannot include %q: %v", tokens[1].Text, err)
+	}
+	return lines
+}
+
+/* Defines a macro: macro name(a, b) { ... } */
+func (e *expander) defineMacro(line sourceLine, reader lineReader) {
+	tokens := line.tokens
+	body, ok := e.readBlock(line, reader)
+	if !ok {
+		return
+	}
+	name := tokens[1]
+	if name.Type != TokenIdent || strings.Contains(name.Text, "$") {
+		e.parser.errorAt(name.Pos, "expected macro name, found %s", name)
+		return
+	}
+	if _, exists := commandForms[name.Text]; exists || isKeyword(name.Text) {
+		e.parser.errorAt(name.Pos, "cannot define macro %q, the name is already a command", name.Text)
+		return
+	}
+	if _, exists := e.macros[name.Text]; exists {
+		e.parser.errorAt(name.Pos, "macro %q is already defined", name.Text)
+		return
+	}
+	params := make([]string, 0)
+	index := 2
+	if tokens[index].Type == TokenLParen {
+		index++
+		for tokens[index].Type != TokenRParen {
+			param := tokens[index]
+			if param.Type != TokenIdent || strings.Contains(param.Text, "$") {
+				e.parser.errorAt(param.Pos, "expected parameter name, found %s", param)
+				return
+			}
+			params = append(params, param.Text)
+			index++
+			if tokens[index].Type == TokenComma {
+				index++
+			} else if tokens[index].Type != TokenRParen {
+				e.parser.errorAt(tokens[index].Pos, "expected \",\" or \")\", found %s", tokens[index])
+				return
+			}
+		}
+		index++
+	}
+	if tokens[index].Type != TokenLBrace {
+		e.parser.errorAt(tokens[index].Pos, "expected \"{\", found %s", tokens[index])
+		return
+	}
+	e.macros[name.Text] = &macro{params, body}
+}
+
+/* Expands a macro call: name(T1, x4). The call must be alone on its line */
+func (e *expander) callMacro(line sourceLine, variables map[string]string, depth int) []sourceLine {
+	tokens, ok := e.substitute(line.tokens, variables)
+	if !ok {
+		return nil
+	}
+	name := tokens[0]
+	definition := e.macros[name.Text]
+	if depth >= maxExpansionDepth {
+		e.parser.errorAt(name.Pos, "macro %s nested too deeply, is it recursive?", name.Text)
+		return nil
+	}
+	args := make([]string, 0)
+	index := 1
+	if tokens[index].Type == TokenLParen {
+		index++
+		for tokens[index].Type != TokenRParen {
+			arg := tokens[index]
+			if arg.Type != TokenIdent && arg.Type != TokenNumber {
+				e.parser.errorAt(arg.Pos, "expected macro argument, found %s", arg)
+				return nil
+			}
+			args = append(args, arg.Text)
+			index++
+			if tokens[index].Type == TokenComma {
+				index++
+			} else if tokens[index].Type != TokenRParen {
+				e.parser.errorAt(tokens[index].Pos, "expected \",\" or \")\", found %s", tokens[index])
+				return nil
+			}
+		}
+		index++
+	}
+	if tokens[index].Type != TokenEOL {
+		e.parser.errorAt(tokens[index].Pos, "macro %s must be called on its own line, found %s", name.Text, tokens[index])
+		return nil
+	}
+	if len(args) != len(definition.params) {
+		e.parser.errorAt(name.Pos, "macro %s expects %d arguments, found %d", name.Text, len(definition.params), len(args))
+		return nil
+	}
+	scope := make(map[string]string)
+	for i, param := range definition.params {
+		scope[param] = args[i]
+	}
+	return e.expandBody(definition.body, scope, depth+1)
+}
+
+/* Expands repeat N [as i] { ... } */
+func (e *expander) repeat(line sourceLine, reader lineReader, variables map[string]string, depth int) []sourceLine {
+	body, ok := e.readBlock(line, reader)
+	if !ok {
+		return nil
+	}
+	brace := blockStart(line.tokens)
+	header, ok := e.substitute(append(line.tokens[:brace:brace], Token{TokenEOL, "", line.tokens[brace].Pos}), variables)
+	if !ok {
+		return nil
+	}
+	if header[1].Type != TokenNumber {
+		e.parser.errorAt(header[1].Pos, "expected repeat count, found %s", header[1])
+		return nil
+	}
+	count, err := strconv.Atoi(header[1].Text)
+	if err != nil {
+		e.parser.errorAt(header[1].Pos, "number %s out of range", header[1].Text)
+		return nil
+	}
+	variable := ""
+	switch {
+	case header[2].Type == TokenEOL:
+	case header[2].Type == TokenIdent && header[2].Text == "as" && header[3].Type == TokenIdent && header[4].Type == TokenEOL:
+		variable = header[3].Text
+	default:
+		e.parser.errorAt(header[2].Pos, "expected \"as\" or \"{\" after repeat count, found %s", header[2])
+		return nil
+	}
+	lines := make([]sourceLine, 0)
+	for i := 1; i <= count; i++ {
+		scope := make(map[string]string)
+		for name, value := range variables {
+			scope[name] = value
+		}
+		if variable != "" {
+			scope[variable] = strconv.Itoa(i)
+		}
+		lines = append(lines, e.expandBody(body, scope, depth+1)...)
+	}
+	return lines
+}
+
+/* Expands every line of a block body with the given variables in scope */
+func (e *expander) expandBody(body []sourceLine, variables map[string]string, depth int) []sourceLine {
+	reader := &bodyReader{lines: body}
+	lines := make([]sourceLine, 0)
+	for {
+		line, ok := reader.readLine()
+		if !ok {
+			return lines
+		}
+		lines = append(lines, e.expandLine(line, reader, variables, depth)...)
+	}
+}
+
+/*
+Reads the body of the block opened by the first "{" on line. The body may start on the same line and
+may end on a later line read from reader. Tokens between the braces on a line form a line of the body
+*/
+func (e *expander) readBlock(line sourceLine, reader lineReader) ([]sourceLine, bool) {
+	start := blockStart(line.tokens)
+	if start < 0 {
+		eol := line.tokens[len(line.tokens)-1]
+		e.parser.errorAt(eol.Pos, "expected \"{\", found %s", eol)
+		return nil, false
+	}
+	body := make([]sourceLine, 0)
+	open := line.tokens[start]
+	tokens := line.tokens[start+1:]
+	current := line
+	nesting := 1
+	for {
+		for i, token := range tokens {
+			switch token.Type {
+			case TokenLBrace:
+				nesting++
+			case TokenRBrace:
+				nesting--
+			}
+			if nesting == 0 {
+				if i > 0 {
+					body = append(body, bodyLine(current, tokens[:i]))
+				}
+				if rest := tokens[i+1]; rest.Type != TokenEOL {
+					e.parser.errorAt(rest.Pos, "unexpected %s after \"}\"", rest)
+					return nil, false
+				}
+				return body, true
+			}
+		}
+		if len(tokens) > 1 {
+			body = append(body, bodyLine(current, tokens[:len(tokens)-1]))
+		}
+		next, ok := reader.readLine()
+		if !ok {
+			e.parser.errorAt(open.Pos, "block is never closed, expected \"}\"")
+			return nil, false
+		}
+		current = next
+		tokens = next.tokens
+	}
+}
+
+/* Returns the index of the first "{" on the line, or -1 */
+func blockStart(tokens []Token) int {
+	for i, token := range tokens {
+		if token.Type == TokenLBrace {
+			return i
+		}
+	}
+	return -1
+}
+
+/* Returns a line of the body holding the given tokens */
+func bodyLine(line sourceLine, tokens []Token) sourceLine {
+	lineTokens := make([]Token, len(tokens), len(tokens)+1)
+	copy(lineTokens, tokens)
+	eol := line.tokens[len(line.tokens)-1]
+	return sourceLine{line.file, line.number, append(lineTokens, eol)}
+}
+
+/* Substitutes variable references in the tokens. Returns false if a variable is undefined or an expression is malformed */
+func (e *expander) substitute(tokens []Token, variables map[string]string) ([]Token, bool) {
+	result := make([]Token, len(tokens))
+	for i, token := range tokens {
+		result[i] = token
+		if token.Type != TokenIdent || !strings.Contains(token.Text, "$") {
+			continue
+		}
+		text, err := substituteText(token.Text, variables)
+		if err != nil {
+			e.parser.errorAt(token.Pos, "%v", err)
+			return nil, false
+		}
+		inComment := false
+		expanded := tokenize(text, token.Pos.File, token.Pos.Line, &inComment)
+		if len(expanded) != 2 || (expanded[0].Type != TokenIdent && expanded[0].Type != TokenNumber) {
+			e.parser.errorAt(token.Pos, "%s expands to %q, which is not a single identifier or number", token.Text, text)
+			return nil, false
+		}
+		result[i] = Token{expanded[0].Type, text, token.Pos}
+	}
+	return result, true
+}
+
+/* Replaces $name and $(expression) in text with their values */
+func substituteText(text string, variables map[string]string) (string, error) {
+	var builder strings.Builder
+	for offset := 0; offset < len(text); {
+		if text[offset] != '$' {
+			builder.WriteByte(text[offset])
+			offset++
+			continue
+		}
+		offset++
+		if text[offset] == '(' {
+			end := offset + strings.IndexByte(text[offset:], ')')
+			value, err := evaluate(text[offset+1:end], variables)
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(strconv.Itoa(value))
+			offset = end + 1
+			continue
+		}
+		end := offset
+		for end < len(text) && (isLetter(text[end]) || isDigit(text[end])) {
+			end++
+		}
+		value, exists := variables[text[offset:end]]
+		if !exists {
+			return "", fmt.Errorf("undefined variable $%s", text[offset:end])
+		}
+		builder.WriteString(value)
+		offset = end
+	}
+	return builder.String(), nil
+}
+
+/* Evaluates an integer expression of variables and numbers joined by +, - and *. * binds tighter than + and - */
+func evaluate(expression string, variables map[string]string) (int, error) {
+	expression = strings.ReplaceAll(expression, " ", "")
+	if expression == "" {
+		return 0, fmt.Errorf("empty expression $()")
+	}
+	sum := 0
+	sign := 1
+	for i, term := range splitKeeping(expression, "+-") {
+		if i == 0 && term == "" { // Leading sign, e.g. $(-i)
+			continue
+		}
+		if term == "+" || term == "-" {
+			if term == "-" {
+				sign = -1
+			} else {
+				sign = 1
+			}
+			continue
+		}
+		product := 1
+		for _, factor := range strings.Split(term, "*") {
+			value, err := operand(factor, variables)
+			if err != nil {
+				return 0, fmt.Errorf("invalid expression $(%s): %v", expression, err)
+			}
+			product *= value
+		}
+		sum += sign * product
+	}
+	return sum, nil
+}
+
+/* Splits text around the given separator characters, keeping the separators as their own parts */
+func splitKeeping(text string, separators string) []string {
+	parts := make([]string, 0)
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(separators, text[i]) >= 0 {
+			parts = append(parts, text[start:i], text[i:i+1])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+/* Returns the value of a number or of a variable holding a number */
+func operand(text string, variables map[string]string) (int, error) {
+	if value, err := strconv.Atoi(text); err == nil {
+		return value, nil
+	}
+	value, exists := variables[text]
+	if !exists {
+		return 0, fmt.Errorf("undefined variable %s", text)
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("variable %s holds %s, which is not a number", text, value)
+	}
+	return number, nil
+}
+
+/* Returns whether the name is reserved for includes, macros and loops */
+func isKeyword(name string) bool {
+	return name == "include" || name == "macro" || name == "repeat" || name == "expect"
+}
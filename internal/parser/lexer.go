@@ -0,0 +1,230 @@
+/**************************
+File: lexer.go
+Author: Mingyi Lim
+Description: This file contains the tokenizer for the simulation language. It splits a line of input into tokens, skipping whitespace, line comments and block comments (which may span several lines).
+Identifiers may contain variable references such as T$i or x$(i+1), which are substituted when macros and loops are expanded.
+***************************/
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type TokenType int
+
+const (
+	TokenEOL TokenType = iota
+	TokenIdent
+	TokenNumber
+	TokenLParen
+	TokenRParen
+	TokenComma
+	TokenAt
+	TokenSemicolon
+	TokenLBrace
+	TokenRBrace
+	TokenString
+	TokenEquals
+	TokenColon
+	TokenIllegal
+)
+
+/* Returns a description of the token type for error messages */
+func (t TokenType) String() string {
+	switch t {
+	case TokenEOL:
+		return "end of line"
+	case TokenIdent:
+		return "identifier"
+	case TokenNumber:
+		return "number"
+	case TokenLParen:
+		return `"("`
+	case TokenRParen:
+		return `")"`
+	case TokenComma:
+		return `","`
+	case TokenAt:
+		return `"@"`
+	case TokenSemicolon:
+		return `";"`
+	case TokenLBrace:
+		return `"{"`
+	case TokenRBrace:
+		return `"}"`
+	case TokenString:
+		return "string"
+	case TokenEquals:
+		return `"="`
+	case TokenColon:
+		return `":"`
+	}
+	return "illegal character"
+}
+
+/*
+***********
+Custom Structs
+***********
+*/
+type Token struct {
+	Type TokenType
+	Text string
+	Pos  Position
+}
+
+/* Returns the token as it should appear in error messages */
+func (t Token) String() string {
+	switch t.Type {
+	case TokenEOL:
+		return t.Type.String()
+	case TokenIdent, TokenNumber, TokenString:
+		return fmt.Sprintf("%s %q", t.Type, t.Text)
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+/* Tokenizes a single line. inComment carries block comment state across lines */
+type lexer struct {
+	input     string
+	file      string
+	line      int
+	offset    int
+	inComment *bool
+}
+
+/* Returns all tokens on a line, always terminated by a TokenEOL token */
+func tokenize(input string, file string, line int, inComment *bool) []Token {
+	l := lexer{input: input, file: file, line: line, inComment: inComment}
+	tokens := make([]Token, 0)
+	for {
+		token := l.next()
+		tokens = append(tokens, token)
+		if token.Type == TokenEOL {
+			return tokens
+		}
+	}
+}
+
+/* Returns the next token on the line */
+func (l *lexer) next() Token {
+	l.skipIgnored()
+	start := l.offset
+	pos := Position{l.file, l.line, start + 1}
+	if l.offset >= len(l.input) {
+		return Token{TokenEOL, "", pos}
+	}
+	char := l.input[l.offset]
+	l.offset++
+	switch {
+	case char == '(':
+		return Token{TokenLParen, "(", pos}
+	case char == ')':
+		return Token{TokenRParen, ")", pos}
+	case char == ',':
+		return Token{TokenComma, ",", pos}
+	case char == '@':
+		return Token{TokenAt, "@", pos}
+	case char == ';':
+		return Token{TokenSemicolon, ";", pos}
+	case char == '=':
+		return Token{TokenEquals, "=", pos}
+	case char == ':':
+		return Token{TokenColon, ":", pos}
+	case char == '{':
+		return Token{TokenLBrace, "{", pos}
+	case char == '}':
+		return Token{TokenRBrace, "}", pos}
+	case char == '"':
+		end := strings.IndexByte(l.input[l.offset:], '"')
+		if end < 0 {
+			l.offset = len(l.input)
+			return Token{TokenIllegal, l.input[start:], pos}
+		}
+		l.offset += end + 1
+		return Token{TokenString, l.input[start+1 : l.offset-1], pos}
+	case isDigit(char):
+		for l.offset < len(l.input) && isDigit(l.input[l.offset]) {
+			l.offset++
+		}
+		return Token{TokenNumber, l.input[start:l.offset], pos}
+	case isLetter(char) || char == '$':
+		l.offset--
+		if !l.skipIdent() {
+			return Token{TokenIllegal, l.input[start:l.offset], pos}
+		}
+		return Token{TokenIdent, l.input[start:l.offset], pos}
+	}
+	return Token{TokenIllegal, string(char), pos}
+}
+
+/* Skips whitespace and comments. A line comment skips the rest of the line. "->" also starts a line comment, for the annotations of older scripts such as "end(T1) -> T1 commits" */
+func (l *lexer) skipIgnored() {
+	for l.offset < len(l.input) {
+		rest := l.input[l.offset:]
+		switch {
+		case *l.inComment:
+			if end := strings.Index(rest, "*/"); end >= 0 {
+				l.offset += end + 2
+				*l.inComment = false
+			} else {
+				l.offset = len(l.input)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			l.offset += 2
+			*l.inComment = true
+		case strings.HasPrefix(rest, "//"), strings.HasPrefix(rest, "->"):
+			l.offset = len(l.input)
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r':
+			l.offset++
+		default:
+			return
+		}
+	}
+}
+
+/* Skips an identifier, including variable references such as $i and $(i+1). Returns false if a reference is malformed */
+func (l *lexer) skipIdent() bool {
+	for l.offset < len(l.input) {
+		char := l.input[l.offset]
+		switch {
+		case isLetter(char) || isDigit(char):
+			l.offset++
+		case char == '$' && strings.HasPrefix(l.input[l.offset:], "$("):
+			end := strings.IndexByte(l.input[l.offset:], ')')
+			if end < 0 {
+				l.offset = len(l.input)
+				return false
+			}
+			l.offset += end + 1
+		case char == '$':
+			l.offset++
+			if l.offset >= len(l.input) || !isLetter(l.input[l.offset]) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+func isDigit(char byte) bool {
+	return '0' <= char && char <= '9'
+}
+
+func isLetter(char byte) bool {
+	return ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || char == '_'
+}
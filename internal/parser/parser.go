@@ -0,0 +1,434 @@
+/**************************
+File: parser.go
+Author: Mingyi Lim
+Description: This file contains the parser for the simulation language. It turns tokens into commands, checks each command against its grammar and collects every syntax error in the input instead of stopping at the first one.
+
+Grammar, after includes, macros and loops are expanded (see expand.go):
+
+	script  = { line } ;
+	line    = [ command { ";" command } [ ";" ] ] [ comment ] EOL ;
+	command = name [ "(" [ arg { ( "," arg | "@" number ) } ] ")" ] | expect ;
+	expect  = "expect" ( "R" "(" tx "," key ")" "=" number | tx ( "commits" | "aborts" | "waits" ) | "dump" "site" number key ":" number ) ;
+	arg     = tx | key | number ;
+	tx      = "T" number ;
+	key     = "x" number ;
+
+Comments are either line comments starting with "//" or "->", or block comments, which may span lines.
+The arguments accepted by each command are listed in commandForms.
+***************************/
+
+package parser
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* Argument lists accepted by each command. Commands accepting an empty list may be written without parentheses */
+var commandForms = map[string][][]ArgKind{
+	"begin":       {{TxArg}},
+	"beginRO":     {{TxArg}},
+	"end":         {{TxArg}},
+	"explain":     {{TxArg}},
+	"R":           {{TxArg, KeyArg}, {TxArg, KeyArg, TickArg}},
+	"W":           {{TxArg, KeyArg, NumArg}},
+	"fail":        {{NumArg}},
+	"recover":     {{NumArg}},
+	"dump":        {{}, {KeyArg}, {NumArg}},
+	"dumphistory": {{KeyArg}},
+	"dumpasof":    {{NumArg}},
+	"querystate":  {{}},
+	"graph":       {{}},
+	"verify":      {{}},
+	"exit":        {{}},
+}
+
+/* States which can be expected of a transaction, e.g. expect T2 aborts */
+var expectStates = map[string]bool{"commits": true, "aborts": true, "waits": true}
+
+var txPattern = regexp.MustCompile(`^T(\d+)$`)
+var keyPattern = regexp.MustCompile(`^x(\d+)$`)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A syntax error at a position in the input */
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+/* All syntax errors found in the input, in the order they occur */
+type ErrorList []*Error
+
+func (e ErrorList) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	if len(e) == 1 {
+		return "syntax error at " + messages[0]
+	}
+	return fmt.Sprintf("%d syntax errors:\n%s", len(e), strings.Join(messages, "\n"))
+}
+
+/* Parser reads the input one line at a time, so that interactive input can be executed as it is entered */
+type Parser struct {
+	expander *expander
+	errors   ErrorList
+}
+
+/* Creates and returns a Parser reading from the given input. Included files are resolved against the working directory */
+func NewParser(input io.Reader) *Parser {
+	return NewParserInDir(input, ".")
+}
+
+/* Creates and returns a Parser reading from the given input. Included files are resolved against dir */
+func NewParserInDir(input io.Reader, dir string) *Parser {
+	parser := &Parser{}
+	parser.expander = createExpander(input, dir, parser)
+	return parser
+}
+
+/* Parses the whole input. Returns an ErrorList holding every syntax error if any are found */
+func Parse(input io.Reader) (*Script, error) {
+	return ParseInDir(input, ".")
+}
+
+/* Parses the whole input, resolving included files against dir */
+func ParseInDir(input io.Reader, dir string) (*Script, error) {
+	parser := NewParserInDir(input, dir)
+	script := &Script{Lines: make([]Line, 0)}
+	for {
+		line, ok := parser.ParseLine()
+		if !ok {
+			break
+		}
+		if len(line.Commands) > 0 {
+			script.Lines = append(script.Lines, line)
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+/*
+Parses the next expanded line of input. Returns false once the input is exhausted. Syntax errors are collected and available through Err
+Macro definitions produce no lines, so the line following a definition is returned instead
+*/
+func (p *Parser) ParseLine() (Line, bool) {
+	source, ok := p.expander.next()
+	if !ok {
+		return Line{}, false
+	}
+	lineParser := lineParser{tokens: source.tokens, parser: p}
+	return Line{File: source.file, Number: source.number, Commands: lineParser.parse()}, true
+}
+
+/* Returns the syntax errors found so far, any error reading the input, or nil */
+func (p *Parser) Err() error {
+	if err := p.expander.err(); err != nil {
+		return err
+	}
+	if len(p.errors) > 0 {
+		return p.errors
+	}
+	return nil
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+func (p *Parser) errorAt(pos Position, format string, args ...any) {
+	p.errors = append(p.errors, &Error{pos, fmt.Sprintf(format, args...)})
+}
+
+/* Parses the tokens of a single line */
+type lineParser struct {
+	tokens []Token
+	index  int
+	parser *Parser
+}
+
+func (l *lineParser) peek() Token {
+	return l.tokens[l.index]
+}
+
+func (l *lineParser) advance() Token {
+	token := l.tokens[l.index]
+	if token.Type != TokenEOL {
+		l.index++
+	}
+	return token
+}
+
+/* Parses the semicolon separated commands on the line, in order. On a syntax error the rest of the command is skipped */
+func (l *lineParser) parse() []Command {
+	commands := make([]Command, 0)
+	for l.peek().Type != TokenEOL {
+		command, ok := l.parseCommand()
+		if ok {
+			commands = append(commands, command)
+			if token := l.peek(); token.Type != TokenSemicolon && token.Type != TokenEOL {
+				l.parser.errorAt(token.Pos, "unexpected %s after %s", token, command)
+			}
+		}
+		l.skipPastSemicolon()
+	}
+	return commands
+}
+
+/* Skips tokens up to and including the next semicolon, or up to the end of the line */
+func (l *lineParser) skipPastSemicolon() {
+	for {
+		token := l.advance()
+		if token.Type == TokenSemicolon || token.Type == TokenEOL {
+			return
+		}
+	}
+}
+
+func (l *lineParser) parseCommand() (Command, bool) {
+	token := l.advance()
+	if token.Type != TokenIdent {
+		l.parser.errorAt(token.Pos, "expected command, found %s", token)
+		return Command{}, false
+	}
+	if token.Text == "expect" {
+		return l.parseExpect(token)
+	}
+	forms, exists := commandForms[token.Text]
+	if !exists {
+		l.parser.errorAt(token.Pos, "unknown command %q", token.Text)
+		return Command{}, false
+	}
+	command := Command{Pos: token.Pos, Name: token.Text, Args: make([]Arg, 0)}
+	if l.peek().Type == TokenLParen {
+		l.advance()
+		args, ok := l.parseArgs()
+		if !ok {
+			return Command{}, false
+		}
+		command.Args = args
+	}
+	if !matchesForm(command.Args, forms) {
+		l.parser.errorAt(command.Pos, "wrong arguments for %s, expected %s", command.Name, usage(command.Name, forms))
+		return Command{}, false
+	}
+	return command, true
+}
+
+/* Parses an expectation following the expect keyword */
+func (l *lineParser) parseExpect(keyword Token) (Command, bool) {
+	command := Command{Pos: keyword.Pos, Name: "expect", Args: make([]Arg, 0), Expect: &Expectation{}}
+	subject := l.peek()
+	if subject.Type != TokenIdent {
+		l.parser.errorAt(subject.Pos, "expected R(Tn, xK) = n, Tn commits|aborts|waits or dump site n xK: n after expect, found %s", subject)
+		return Command{}, false
+	}
+	switch {
+	case subject.Text == "R":
+		l.advance()
+		if !l.expect(TokenLParen) {
+			return Command{}, false
+		}
+		args, ok := l.parseArgs()
+		if !ok {
+			return Command{}, false
+		}
+		if !matchesForm(args, [][]ArgKind{{TxArg, KeyArg}}) {
+			l.parser.errorAt(subject.Pos, "wrong arguments for expect R, expected R(Tn, xK)")
+			return Command{}, false
+		}
+		if !l.expect(TokenEquals) {
+			return Command{}, false
+		}
+		value, ok := l.parseNumber()
+		if !ok {
+			return Command{}, false
+		}
+		command.Args = args
+		command.Expect.Kind = ExpectRead
+		command.Expect.Value = value
+	case subject.Text == "dump":
+		l.advance()
+		if word := l.advance(); word.Type != TokenIdent || word.Text != "site" {
+			l.parser.errorAt(word.Pos, "expected \"site\" after expect dump, found %s", word)
+			return Command{}, false
+		}
+		sitePos := l.peek().Pos
+		site, ok := l.parseNumber()
+		if !ok {
+			return Command{}, false
+		}
+		key, ok := l.parseArg()
+		if !ok {
+			return Command{}, false
+		}
+		if key.Kind != KeyArg {
+			l.parser.errorAt(key.Pos, "expected key (xK), found %s", key)
+			return Command{}, false
+		}
+		if !l.expect(TokenColon) {
+			return Command{}, false
+		}
+		value, ok := l.parseNumber()
+		if !ok {
+			return Command{}, false
+		}
+		command.Args = []Arg{{sitePos, NumArg, site}, key}
+		command.Expect.Kind = ExpectSiteValue
+		command.Expect.Value = value
+	default:
+		tx, ok := l.parseArg()
+		if !ok {
+			return Command{}, false
+		}
+		state := l.advance()
+		if tx.Kind != TxArg || state.Type != TokenIdent || !expectStates[state.Text] {
+			l.parser.errorAt(state.Pos, "expected Tn commits, Tn aborts or Tn waits after expect, found %s", state)
+			return Command{}, false
+		}
+		command.Args = []Arg{tx}
+		command.Expect.Kind = ExpectState
+		command.Expect.State = state.Text
+	}
+	return command, true
+}
+
+/* Parses an argument which must be a number */
+func (l *lineParser) parseNumber() (int, bool) {
+	arg, ok := l.parseArg()
+	if ok && arg.Kind != NumArg {
+		l.parser.errorAt(arg.Pos, "expected number, found %s", arg)
+		return -1, false
+	}
+	return arg.Value, ok
+}
+
+/* Consumes the next token if it has the given type, and reports an error otherwise */
+func (l *lineParser) expect(tokenType TokenType) bool {
+	token := l.advance()
+	if token.Type != tokenType {
+		l.parser.errorAt(token.Pos, "expected %s, found %s", tokenType, token)
+		return false
+	}
+	return true
+}
+
+/* Parses arguments up to and including the closing parenthesis */
+func (l *lineParser) parseArgs() ([]Arg, bool) {
+	args := make([]Arg, 0)
+	if l.peek().Type == TokenRParen {
+		l.advance()
+		return args, true
+	}
+	for {
+		arg, ok := l.parseArg()
+		if !ok {
+			return nil, false
+		}
+		args = append(args, arg)
+		token := l.advance()
+		switch token.Type {
+		case TokenRParen:
+			return args, true
+		case TokenComma:
+			continue
+		case TokenAt:
+			tick := l.advance()
+			if tick.Type != TokenNumber {
+				l.parser.errorAt(tick.Pos, "expected tick after \"@\", found %s", tick)
+				return nil, false
+			}
+			value, err := strconv.Atoi(tick.Text)
+			if err != nil {
+				l.parser.errorAt(tick.Pos, "number %s out of range", tick.Text)
+				return nil, false
+			}
+			args = append(args, Arg{token.Pos, TickArg, value})
+			if closing := l.advance(); closing.Type != TokenRParen {
+				l.parser.errorAt(closing.Pos, "expected \")\", found %s", closing)
+				return nil, false
+			}
+			return args, true
+		default:
+			l.parser.errorAt(token.Pos, "expected \",\" or \")\", found %s", token)
+			return nil, false
+		}
+	}
+}
+
+/* Parses a single argument: a transaction (T1), a key (x4) or a number */
+func (l *lineParser) parseArg() (Arg, bool) {
+	token := l.advance()
+	switch token.Type {
+	case TokenNumber:
+		value, err := strconv.Atoi(token.Text)
+		if err != nil {
+			l.parser.errorAt(token.Pos, "number %s out of range", token.Text)
+			return Arg{}, false
+		}
+		return Arg{token.Pos, NumArg, value}, true
+	case TokenIdent:
+		if matches := txPattern.FindStringSubmatch(token.Text); matches != nil {
+			if value, err := strconv.Atoi(matches[1]); err == nil {
+				return Arg{token.Pos, TxArg, value}, true
+			}
+		}
+		if matches := keyPattern.FindStringSubmatch(token.Text); matches != nil {
+			if value, err := strconv.Atoi(matches[1]); err == nil {
+				return Arg{token.Pos, KeyArg, value}, true
+			}
+		}
+	}
+	l.parser.errorAt(token.Pos, "expected transaction (Tn), key (xK) or number, found %s", token)
+	return Arg{}, false
+}
+
+/* Checks whether the argument kinds match one of the accepted forms */
+func matchesForm(args []Arg, forms [][]ArgKind) bool {
+	for _, form := range forms {
+		if len(form) != len(args) {
+			continue
+		}
+		matches := true
+		for i, kind := range form {
+			if args[i].Kind != kind {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+/* Returns the accepted forms of a command, e.g. "R(Tn, xK) or R(Tn, xK @ t)" */
+func usage(name string, forms [][]ArgKind) string {
+	usages := make([]string, len(forms))
+	for i, form := range forms {
+		args := make([]string, len(form))
+		for j, kind := range form {
+			args[j] = kind.String()
+		}
+		usages[i] = fmt.Sprintf("%s(%s)", name, strings.ReplaceAll(strings.Join(args, ", "), ", @", " @"))
+	}
+	return strings.Join(usages, " or ")
+}
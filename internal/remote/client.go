@@ -0,0 +1,188 @@
+/**************************
+File: client.go
+Author: Mingyi Lim
+Description: This file contains the client of a site running in another process. It implements DataManager by calling the site over RPC.
+The SiteCoordinator keeps asking failed sites about their committed history, for instance to decide whether a transaction should wait for them.
+While the process of a site is down, such questions are answered from its WAL, which outlives the process like a disk would. Commits to a site which is down fail.
+The WAL is replayed once per outage, and the replayed state answers every question until the site accepts connections again.
+***************************/
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* The DataManager of a site in another process. A RemoteSite must not be called by several goroutines at once */
+type RemoteSite struct {
+	siteId   int
+	addr     string
+	walPath  string
+	client   *rpc.Client
+	replayed *domain.DataManagerImpl // The state of the site replayed from its WAL while it is down
+	err      error
+}
+
+/* Creates a client for the site listening on addr, whose WAL is at walPath. The connection is made on the first call */
+func CreateRemoteSite(siteId int, addr string, walPath string) *RemoteSite {
+	return &RemoteSite{siteId: siteId, addr: addr, walPath: walPath}
+}
+
+func (r *RemoteSite) Dump() string {
+	return r.query(Request{Method: DumpMethod}).Text
+}
+
+func (r *RemoteSite) DumpKey(key int) string {
+	return r.query(Request{Method: DumpKeyMethod, Key: key}).Text
+}
+
+func (r *RemoteSite) DumpHistory(key int) string {
+	return r.query(Request{Method: DumpHistoryMethod, Key: key}).Text
+}
+
+func (r *RemoteSite) DumpAsOf(time int) string {
+	return r.query(Request{Method: DumpAsOfMethod, Time: time}).Text
+}
+
+func (r *RemoteSite) HasKey(key int) bool {
+	return r.query(Request{Method: HasKeyMethod, Key: key}).OK
+}
+
+func (r *RemoteSite) Read(key int, time int) domain.HistoricalValue {
+	response := r.query(Request{Method: ReadMethod, Key: key, Time: time})
+	return domain.CreateHistoricalValue(response.Value, response.Time)
+}
+
+func (r *RemoteSite) Commit(key int, value int, time int) error {
+	return r.change(Request{Method: CommitMethod, Key: key, Value: value, Time: time})
+}
+
+func (r *RemoteSite) GetLastCommitted(key int) domain.HistoricalValue {
+	response := r.query(Request{Method: LastCommittedMethod, Key: key})
+	return domain.CreateHistoricalValue(response.Value, response.Time)
+}
+
+/* Prepares the writes of a transaction at the site, which fails if the site is down */
+func (r *RemoteSite) Prepare(tx int, writes []domain.PreparedWrite) error {
+	return r.change(Request{Method: PrepareMethod, Tx: tx, Writes: writes})
+}
+
+/* Tells the site the outcome of a transaction, which fails if the site is down */
+func (r *RemoteSite) Decide(tx int, commit bool, time int) error {
+	return r.change(Request{Method: DecideMethod, Tx: tx, Commit: commit, Time: time})
+}
+
+func (r *RemoteSite) InDoubt() []int {
+	return r.query(Request{Method: InDoubtMethod}).Txs
+}
+
+func (r *RemoteSite) History(key int) []domain.HistoricalValue {
+	return historicalValues(r.query(Request{Method: HistoryMethod, Key: key}).Versions)
+}
+
+/* Repairs the history of a key at the site, which fails if the site is down */
+func (r *RemoteSite) Repair(key int, versions []domain.HistoricalValue) error {
+	request := Request{Method: RepairMethod, Key: key, Versions: make([]Version, len(versions))}
+	for i, version := range versions {
+		request.Versions[i] = Version{version.GetValue(), version.GetTime()}
+	}
+	return r.change(request)
+}
+
+func (r *RemoteSite) MerkleTree(keys []int) domain.MerkleTree {
+	return r.query(Request{Method: MerkleTreeMethod, Keys: keys}).Tree
+}
+
+/* Returns the error of the last question which could not be answered, as the site was down and its WAL could not be read. Such questions are answered with zero values */
+func (r *RemoteSite) Err() error {
+	return r.err
+}
+
+/* Closes the connection to the site. The next call connects again */
+func (r *RemoteSite) Disconnect() {
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Asks the site a question, answering with zero values if it cannot be answered. The DataManager interface has no room for the error, which is kept for Err */
+func (r *RemoteSite) query(request Request) Response {
+	response, err := r.call(request)
+	if err != nil {
+		r.err = err
+		return Response{}
+	}
+	return response
+}
+
+/* Calls the site, or reads its WAL if the site cannot be reached */
+func (r *RemoteSite) call(request Request) (Response, error) {
+	response, err := r.remoteCall(request)
+	if err != nil {
+		if r.replayed == nil {
+			dataManager, err := ReplayWAL(r.siteId, r.walPath)
+			if err != nil {
+				return Response{}, fmt.Errorf("site %d is down and its WAL cannot be read: %v", r.siteId, err)
+			}
+			r.replayed = &dataManager
+		}
+		response = apply(r.replayed, request)
+	}
+	raise(response)
+	return response, nil
+}
+
+/* Calls the site with a request which changes it. Unlike a question, a change cannot be answered from the WAL, so it fails while the site is down */
+func (r *RemoteSite) change(request Request) error {
+	response, err := r.remoteCall(request)
+	if err != nil {
+		return &domain.SiteDownError{Site: r.siteId, Err: err}
+	}
+	if response.Err != "" {
+		return errors.New(response.Err)
+	}
+	return raise(response)
+}
+
+/* Calls the site over RPC, connecting first if needed. A failed call drops the connection */
+func (r *RemoteSite) remoteCall(request Request) (Response, error) {
+	var response Response
+	if r.client == nil {
+		client, err := rpc.Dial("tcp", r.addr)
+		if err != nil {
+			return response, err
+		}
+		r.client = client
+		r.replayed = nil // The site is up again, and its WAL may have grown
+	}
+	if err := r.client.Call("Site.Call", request, &response); err != nil {
+		r.Disconnect()
+		return response, err
+	}
+	return response, nil
+}
+
+/* Raises the panic of the DataManager of the site in the calling goroutine, as if the DataManager were local */
+func raise(response Response) error {
+	if response.Panic != "" {
+		panic(response.Panic)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+/**************************
+File: cluster.go
+Author: Mingyi Lim
+Description: This file contains the cluster, a SiteCoordinator whose sites each run as their own process on localhost.
+Failing a site kills its process, and recovering it starts a new process which replays the WAL of the site. The WAL of site n is site-n.wal in the directory of the cluster.
+***************************/
+
+package remote
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+const startTimeout = 5 * time.Second
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Returns the command which runs a site listening on a port of localhost, with its WAL at walPath */
+type Command func(siteId int, port int, walPath string) *exec.Cmd
+
+type ClusterConfig struct {
+	Sites    int
+	Dir      string  // Directory holding the WAL of each site. WALs left in it are removed when the cluster starts, unless Resume is set
+	Resume   bool    // Sites replay the WALs left in Dir, continuing where an earlier cluster stopped
+	BasePort int     // Site n listens on BasePort+n. Free ports are picked if zero
+	Command  Command // SiteCommand if nil
+}
+
+/* A SiteCoordinator whose sites are processes. Fail and Recover kill and restart the processes, and every other call is the one of SiteCoordinatorImpl */
+type Cluster struct {
+	*domain.SiteCoordinatorImpl
+	config    ClusterConfig
+	ports     map[int]int
+	sites     map[int]*RemoteSite
+	processes map[int]*process
+}
+
+/* A running site process. exited is closed once the process has exited. The process exits when lifeline, the write end of its stdin, is closed, which also happens when the cluster's process dies */
+type process struct {
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	lifeline *os.File
+}
+
+/* Runs the site subcommand of the running executable */
+func SiteCommand(siteId int, port int, walPath string) *exec.Cmd {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+	return exec.Command(executable, "site", "--id", strconv.Itoa(siteId), "--port", strconv.Itoa(port), "--wal", walPath, "--exit-on-eof")
+}
+
+/* Starts a process for every site and waits until each of them accepts connections */
+func CreateCluster(config ClusterConfig) (*Cluster, error) {
+	if config.Command == nil {
+		config.Command = SiteCommand
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{
+		config:    config,
+		ports:     make(map[int]int),
+		sites:     make(map[int]*RemoteSite),
+		processes: make(map[int]*process),
+	}
+	dataManagers := make(map[int]domain.DataManager)
+	for site := 1; site <= config.Sites; site++ {
+		walPath := cluster.walPath(site)
+		if !config.Resume {
+			if err := os.Remove(walPath); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		port, err := cluster.pickPort(site)
+		if err != nil {
+			return nil, err
+		}
+		cluster.ports[site] = port
+		cluster.sites[site] = CreateRemoteSite(site, cluster.addr(site), walPath)
+		dataManagers[site] = cluster.sites[site]
+		if err := cluster.start(site); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+	}
+	cluster.SiteCoordinatorImpl = domain.CreateSiteCoordinatorWithSites(dataManagers)
+	return cluster, nil
+}
+
+/* Kills the process of a site, then marks the site as failed */
+func (c *Cluster) Fail(site int, time int) error {
+	if _, exists := c.sites[site]; !exists {
+		return fmt.Errorf("site %d does not exist", site)
+	}
+	c.kill(site)
+	return c.SiteCoordinatorImpl.Fail(site, time)
+}
+
+/* Starts a new process for a failed site, which replays its WAL, then marks the site as recovered */
+func (c *Cluster) Recover(site int, time int) error {
+	if _, exists := c.sites[site]; !exists {
+		return fmt.Errorf("site %d does not exist", site)
+	}
+	if _, running := c.processes[site]; !running {
+		if err := c.start(site); err != nil {
+			return err
+		}
+	}
+	return c.SiteCoordinatorImpl.Recover(site, time)
+}
+
+/* Returns true if the process of a site is running */
+func (c *Cluster) Running(site int) bool {
+	_, running := c.processes[site]
+	return running
+}
+
+/* Kills every site process */
+func (c *Cluster) Close() error {
+	for site := range c.processes {
+		c.kill(site)
+	}
+	return nil
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+func (c *Cluster) walPath(site int) string {
+	return filepath.Join(c.config.Dir, fmt.Sprintf("site-%d.wal", site))
+}
+
+func (c *Cluster) addr(site int) string {
+	return fmt.Sprintf("127.0.0.1:%d", c.ports[site])
+}
+
+/* Returns the port of a site. Without a base port, a free port is found by listening on port 0 */
+func (c *Cluster) pickPort(site int) (int, error) {
+	if c.config.BasePort != 0 {
+		return c.config.BasePort + site, nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+/* Starts the process of a site and waits until it accepts connections */
+func (c *Cluster) start(site int) error {
+	cmd := c.config.Command(site, c.ports[site], c.walPath(site))
+	stdin, lifeline, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, os.Stderr, os.Stderr
+	err = cmd.Start()
+	stdin.Close()
+	if err != nil {
+		lifeline.Close()
+		return fmt.Errorf("site %d: %v", site, err)
+	}
+	started := &process{cmd: cmd, exited: make(chan struct{}), lifeline: lifeline}
+	go func() {
+		cmd.Wait()
+		close(started.exited)
+	}()
+	c.processes[site] = started
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.Dial("tcp", c.addr(site))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-started.exited:
+			started.lifeline.Close()
+			delete(c.processes, site)
+			return fmt.Errorf("site %d exited before accepting connections", site)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			c.kill(site)
+			return fmt.Errorf("site %d did not accept connections within %v", site, startTimeout)
+		}
+	}
+}
+
+/* Kills the process of a site and waits for it to exit */
+func (c *Cluster) kill(site int) {
+	running, exists := c.processes[site]
+	if !exists {
+		return
+	}
+	running.cmd.Process.Kill()
+	<-running.exited
+	running.lifeline.Close()
+	delete(c.processes, site)
+	c.sites[site].Disconnect()
+}
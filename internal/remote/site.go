@@ -0,0 +1,332 @@
+/**************************
+File: site.go
+Author: Mingyi Lim
+Description: This file contains the site server, which runs the DataManager of one site as its own process and serves it over RPC.
+Every commit is appended to the write-ahead log (WAL) of the site and synced before it is acknowledged, and a restarted site replays its WAL, so a site which is killed and restarted comes back with every commit it acknowledged.
+A request which fails is not written to the WAL, so every record of the WAL replays.
+Each line of the WAL is one record: a commit, written as "<key> <value> <time>", the writes a transaction prepared, written as "prepare <tx> <key>=<value> ...",
+or the outcome of a prepared transaction, written as "decide <tx> commit <time>" or "decide <tx> abort <time>". A site restarted after preparing a transaction is still prepared, and awaits its outcome.
+A key repaired by anti-entropy is written as "repair <key> <value>@<time> ...", listing the whole history the key was given.
+A last record without a newline was cut short by a crash while it was written, and was never acknowledged. It is ignored, and dropped before the site appends to its WAL again.
+***************************/
+
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type Method string
+
+const (
+	DumpMethod          Method = "dump"
+	DumpKeyMethod       Method = "dumpKey"
+	DumpHistoryMethod   Method = "dumpHistory"
+	DumpAsOfMethod      Method = "dumpAsOf"
+	HasKeyMethod        Method = "hasKey"
+	ReadMethod          Method = "read"
+	CommitMethod        Method = "commit"
+	LastCommittedMethod Method = "lastCommitted"
+	PrepareMethod       Method = "prepare"
+	DecideMethod        Method = "decide"
+	InDoubtMethod       Method = "inDoubt"
+	HistoryMethod       Method = "history"
+	RepairMethod        Method = "repair"
+	MerkleTreeMethod    Method = "merkleTree"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* A committed version of a key, as sent over RPC */
+type Version struct {
+	Value int
+	Time  int
+}
+
+/* A call on the DataManager of a site */
+type Request struct {
+	Method   Method
+	Key      int
+	Value    int
+	Time     int
+	Tx       int
+	Writes   []domain.PreparedWrite
+	Commit   bool
+	Keys     []int
+	Versions []Version
+}
+
+/* The result of a call. Err holds the error the DataManager returned, and Panic the value it panicked with, which the client raises again */
+type Response struct {
+	Value    int
+	Time     int
+	Text     string
+	OK       bool
+	Txs      []int
+	Versions []Version
+	Tree     domain.MerkleTree
+	Err      string
+	Panic    string
+}
+
+/* The RPC service of a site, registered as "Site" */
+type Site struct {
+	mutex       sync.Mutex
+	dataManager domain.DataManagerImpl
+	wal         *os.File
+}
+
+/* Opens a site, replaying its WAL if there is one. Commits are appended to the WAL from then on */
+func OpenSite(siteId int, walPath string) (*Site, error) {
+	dataManager, complete, err := replayWAL(siteId, walPath)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(walPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := wal.Truncate(complete); err != nil { // Drops a record cut short by a crash, so the next record starts on its own line
+		wal.Close()
+		return nil, err
+	}
+	return &Site{dataManager: dataManager, wal: wal}, nil
+}
+
+/* Opens a site and serves it on a TCP address until the process is killed */
+func ServeSite(siteId int, addr string, walPath string) error {
+	site, err := OpenSite(siteId, walPath)
+	if err != nil {
+		return err
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Site", site); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server.Accept(listener)
+	return nil
+}
+
+/* Returns the DataManager of a site with every commit in its WAL applied. A missing WAL is a site which has never committed, and a last record cut short by a crash is ignored */
+func ReplayWAL(siteId int, walPath string) (domain.DataManagerImpl, error) {
+	dataManager, _, err := replayWAL(siteId, walPath)
+	return dataManager, err
+}
+
+/* Handles a request. Exported for net/rpc */
+func (s *Site) Call(request Request, response *Response) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	*response = apply(&s.dataManager, request)
+	if response.Err != "" || response.Panic != "" {
+		return nil // A failed request changed nothing, so it is not logged and does not replay
+	}
+	if record, logged := formatRecord(request); logged {
+		if _, err := fmt.Fprintln(s.wal, record); err != nil {
+			return err
+		}
+		if err := s.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Replays the complete records of a WAL, those ending in a newline. Returns the length of the WAL they take up */
+func replayWAL(siteId int, walPath string) (domain.DataManagerImpl, int64, error) {
+	dataManager := domain.CreateDataManager(siteId)
+	contents, err := os.ReadFile(walPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dataManager, 0, nil
+	}
+	if err != nil {
+		return dataManager, 0, err
+	}
+	complete := contents[:bytes.LastIndexByte(contents, '\n')+1]
+	scanner := bufio.NewScanner(bytes.NewReader(complete))
+	for line := 1; scanner.Scan(); line++ {
+		request, err := parseRecord(scanner.Text())
+		if err != nil {
+			return dataManager, 0, fmt.Errorf("%s, line %d: %v", walPath, line, err)
+		}
+		if response := apply(&dataManager, request); response.Err != "" || response.Panic != "" {
+			return dataManager, 0, fmt.Errorf("%s, line %d: %s%s", walPath, line, response.Err, response.Panic)
+		}
+	}
+	return dataManager, int64(len(complete)), scanner.Err()
+}
+
+/* Runs a request against a DataManager. A panic is returned in the response instead of bringing down the site */
+func apply(dataManager *domain.DataManagerImpl, request Request) (response Response) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			response = Response{Panic: fmt.Sprint(recovered)}
+		}
+	}()
+	switch request.Method {
+	case DumpMethod:
+		response.Text = dataManager.Dump()
+	case DumpKeyMethod:
+		response.Text = dataManager.DumpKey(request.Key)
+	case DumpHistoryMethod:
+		response.Text = dataManager.DumpHistory(request.Key)
+	case DumpAsOfMethod:
+		response.Text = dataManager.DumpAsOf(request.Time)
+	case HasKeyMethod:
+		response.OK = dataManager.HasKey(request.Key)
+	case ReadMethod:
+		version := dataManager.Read(request.Key, request.Time)
+		response.Value, response.Time = version.GetValue(), version.GetTime()
+	case CommitMethod:
+		response.Err = errorText(dataManager.Commit(request.Key, request.Value, request.Time))
+	case PrepareMethod:
+		response.Err = errorText(dataManager.Prepare(request.Tx, request.Writes))
+	case DecideMethod:
+		response.Err = errorText(dataManager.Decide(request.Tx, request.Commit, request.Time))
+	case InDoubtMethod:
+		response.Txs = dataManager.InDoubt()
+	case HistoryMethod:
+		for _, version := range dataManager.History(request.Key) {
+			response.Versions = append(response.Versions, Version{version.GetValue(), version.GetTime()})
+		}
+	case RepairMethod:
+		response.Err = errorText(dataManager.Repair(request.Key, historicalValues(request.Versions)))
+	case MerkleTreeMethod:
+		response.Tree = dataManager.MerkleTree(request.Keys)
+	case LastCommittedMethod:
+		version := dataManager.GetLastCommitted(request.Key)
+		response.Value, response.Time = version.GetValue(), version.GetTime()
+	default:
+		response.Panic = fmt.Sprintf("unknown method %q", request.Method)
+	}
+	return response
+}
+
+/* Returns the WAL record of a request which changes the site, and false for a request which does not */
+func formatRecord(request Request) (string, bool) {
+	switch request.Method {
+	case CommitMethod:
+		return fmt.Sprintf("%d %d %d", request.Key, request.Value, request.Time), true
+	case PrepareMethod:
+		fields := []string{"prepare", fmt.Sprint(request.Tx)}
+		for _, write := range request.Writes {
+			fields = append(fields, fmt.Sprintf("%d=%d", write.Key, write.Value))
+		}
+		return strings.Join(fields, " "), true
+	case DecideMethod:
+		outcome := "abort"
+		if request.Commit {
+			outcome = "commit"
+		}
+		return fmt.Sprintf("decide %d %s %d", request.Tx, outcome, request.Time), true
+	case RepairMethod:
+		fields := []string{"repair", fmt.Sprint(request.Key)}
+		for _, version := range request.Versions {
+			fields = append(fields, fmt.Sprintf("%d@%d", version.Value, version.Time))
+		}
+		return strings.Join(fields, " "), true
+	}
+	return "", false
+}
+
+/* Returns the request recorded by a line of the WAL */
+func parseRecord(record string) (Request, error) {
+	fields := strings.Fields(record)
+	if len(fields) == 0 {
+		return Request{}, errors.New("empty record")
+	}
+	switch fields[0] {
+	case "prepare":
+		request := Request{Method: PrepareMethod, Writes: make([]domain.PreparedWrite, 0)}
+		if len(fields) < 2 {
+			return request, errors.New("prepare without a transaction")
+		}
+		if _, err := fmt.Sscan(fields[1], &request.Tx); err != nil {
+			return request, err
+		}
+		for _, field := range fields[2:] {
+			var write domain.PreparedWrite
+			if _, err := fmt.Sscanf(field, "%d=%d", &write.Key, &write.Value); err != nil {
+				return request, fmt.Errorf("bad prepared write %q", field)
+			}
+			request.Writes = append(request.Writes, write)
+		}
+		return request, nil
+	case "decide":
+		request := Request{Method: DecideMethod}
+		var outcome string
+		if _, err := fmt.Sscan(strings.Join(fields[1:], " "), &request.Tx, &outcome, &request.Time); err != nil {
+			return request, err
+		}
+		if outcome != "commit" && outcome != "abort" {
+			return request, fmt.Errorf("bad outcome %q", outcome)
+		}
+		request.Commit = outcome == "commit"
+		return request, nil
+	case "repair":
+		request := Request{Method: RepairMethod, Versions: make([]Version, 0)}
+		if len(fields) < 2 {
+			return request, errors.New("repair without a key")
+		}
+		if _, err := fmt.Sscan(fields[1], &request.Key); err != nil {
+			return request, err
+		}
+		for _, field := range fields[2:] {
+			var version Version
+			if _, err := fmt.Sscanf(field, "%d@%d", &version.Value, &version.Time); err != nil {
+				return request, fmt.Errorf("bad version %q", field)
+			}
+			request.Versions = append(request.Versions, version)
+		}
+		return request, nil
+	}
+	request := Request{Method: CommitMethod}
+	_, err := fmt.Sscan(record, &request.Key, &request.Value, &request.Time)
+	return request, err
+}
+
+/* Returns the text of an error as sent in a response, which is empty for no error */
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func historicalValues(versions []Version) []domain.HistoricalValue {
+	values := make([]domain.HistoricalValue, len(versions))
+	for i, version := range versions {
+		values[i] = domain.CreateHistoricalValue(version.Value, version.Time)
+	}
+	return values
+}
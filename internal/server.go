@@ -0,0 +1,239 @@
+/**************************
+File: server.go
+Author: Mingyi Lim
+Description: This file contains the server, which lets several clients drive a single database over TCP using the simulation language.
+Lines are executed one at a time in the order they arrive, each at its own tick of a clock shared by all clients, so the clients build a single history.
+The output of a line is sent only to the connection which sent it, and ends with "tick N", the tick the line ran at. Output about a transaction is sent to the connection which began it,
+so a read which waited for a site is answered on its own connection when another client recovers the site.
+Output is queued for each connection while a line runs, and written once the server is unlocked, so a slow client never holds up the others.
+***************************/
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/mingyi850/repcrec/internal/clock"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/parser"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Serves a single database to many connections. The mutex is held while a line executes, which orders all lines into one sequence of ticks */
+type Server struct {
+	mutex       sync.Mutex
+	simulation  *simulation
+	owners      map[int]*connection // The connection which began each transaction
+	connections map[*connection]bool
+	listener    net.Listener
+	closed      bool
+}
+
+/* A client connection. Its output is queued in pending while the server is locked, and written to conn once the server is unlocked */
+type connection struct {
+	conn    net.Conn
+	mutex   sync.Mutex // Guards pending
+	writing sync.Mutex // Held while queued output is written, so that output reaches the client in the order it was queued
+	pending bytes.Buffer
+}
+
+/* Creates a server for the database. Errors are reported to the connection which caused them and the offending command is skipped */
+func CreateServer(siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager) *Server {
+	return CreateServerWithClock(siteCoordinator, transactionManager, clock.CreateLogicalClock())
+}
+
+/* Creates a server whose lines and requests run at the times given by a clock */
+func CreateServerWithClock(siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager, serverClock clock.Clock) *Server {
+	return CreateServerWithOptions(siteCoordinator, transactionManager, Options{Clock: serverClock})
+}
+
+/* Creates a server running lines and requests with the given options. ContinueOnError is always set, as errors are reported to the connection which caused them */
+func CreateServerWithOptions(siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager, options Options) *Server {
+	options.ContinueOnError = true
+	return &Server{
+		simulation:  createSimulation(siteCoordinator, transactionManager, options),
+		owners:      make(map[int]*connection),
+		connections: make(map[*connection]bool),
+	}
+}
+
+/* Listens on a TCP address and serves connections until the server is closed */
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+/* Accepts connections until the server is closed. Each connection is read on its own goroutine */
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConnection(conn)
+	}
+}
+
+/* Stops accepting connections and closes every open connection */
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for client := range s.connections {
+		client.conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+/*
+*************************
+Private Methods
+***************************
+*/
+
+/* Reads lines from a connection and executes them until the client disconnects or sends exit */
+func (s *Server) serveConnection(conn net.Conn) {
+	client := &connection{conn: conn}
+	s.mutex.Lock()
+	s.connections[client] = true
+	s.mutex.Unlock()
+	defer s.disconnect(client)
+	lineParser := parser.NewParser(conn)
+	reported := 0
+	for {
+		line, ok := lineParser.ParseLine()
+		syntaxErrors := parser.ErrorList{}
+		if err := lineParser.Err(); err != nil {
+			errorList, isSyntax := err.(parser.ErrorList)
+			if !isSyntax {
+				fmt.Fprintf(client, "Error: %v\n", err)
+				client.flush()
+				return
+			}
+			syntaxErrors = errorList[reported:]
+			reported = len(errorList)
+		}
+		if !ok {
+			return
+		}
+		if exit := s.execute(client, line, syntaxErrors); exit {
+			return
+		}
+	}
+}
+
+/* Executes a line at the next tick on behalf of a connection, then writes the output it queued for each connection. Returns true if the connection sent exit */
+func (s *Server) execute(client *connection, line parser.Line, syntaxErrors parser.ErrorList) bool {
+	touched := map[*connection]bool{client: true}
+	exit := s.executeLine(client, touched, line, syntaxErrors)
+	for touchedClient := range touched {
+		touchedClient.flush()
+	}
+	return exit
+}
+
+/* Executes a line at the next tick with the server locked. Output is queued for the connection, and output about each transaction for the connection which began it */
+func (s *Server) executeLine(client *connection, touched map[*connection]bool, line parser.Line, syntaxErrors parser.ErrorList) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.routeOutput(client, touched)()
+	for _, syntaxError := range syntaxErrors {
+		s.simulation.report(&CommandError{File: syntaxError.Pos.File, Line: syntaxError.Pos.Line, Err: syntaxError})
+	}
+	for _, command := range line.Commands {
+		if command.Name == "begin" || command.Name == "beginRO" {
+			if _, exists := s.owners[command.Tx()]; !exists {
+				s.owners[command.Tx()] = client
+			}
+		}
+	}
+	time := s.simulation.clock.Now()
+	exit, err := s.simulation.executeLine(line)
+	s.simulation.errors = nil // Errors have been reported to the connection, so they are not kept for a summary
+	if exit {
+		return true
+	}
+	if err != nil {
+		s.simulation.logger.Log(fmt.Sprintf("Error at line %d: %v", line.Number, err))
+	}
+	s.simulation.logger.Log(fmt.Sprintf("tick %d", time))
+	return false
+}
+
+/*
+Sends the output of the simulation to a writer, and output about each transaction begun by a connection to that connection, recording it in touched.
+Must be called with the server locked. Returns a function restoring the previous output
+*/
+func (s *Server) routeOutput(output io.Writer, touched map[*connection]bool) func() {
+	logger := s.simulation.logger
+	previousOutput := logger.SetOutput(output)
+	previousRoute := logger.SetTransactionOutput(func(transaction int) io.Writer {
+		owner, exists := s.owners[transaction]
+		if !exists {
+			return nil
+		}
+		touched[owner] = true
+		return owner
+	})
+	return func() {
+		logger.SetOutput(previousOutput)
+		logger.SetTransactionOutput(previousRoute)
+	}
+}
+
+/* Closes and forgets a connection. Output about its transactions goes to whichever connection causes it from now on */
+func (s *Server) disconnect(client *connection) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client.conn.Close()
+	delete(s.connections, client)
+	for tx, owner := range s.owners {
+		if owner == client {
+			delete(s.owners, tx)
+		}
+	}
+}
+
+/* Queues output for the connection */
+func (c *connection) Write(output []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pending.Write(output)
+}
+
+/* Writes the output queued for the connection. Must be called with the server unlocked, as the client may be slow to read */
+func (c *connection) flush() {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	c.mutex.Lock()
+	output := bytes.Clone(c.pending.Bytes())
+	c.pending.Reset()
+	c.mutex.Unlock()
+	if len(output) > 0 {
+		c.conn.Write(output)
+	}
+}
@@ -0,0 +1,333 @@
+/**************************
+File: shrink.go
+Author: Mingyi Lim
+Description: This file contains the test-case minimizer. It delta-debugs a failing script down to a small script which still fails in the same way.
+A failure is one of:
+
+	expect: the first expectation which does not hold still fails with the same message
+	invariant: the first invariant violated when the script is run by the fault-injection driver is still violated
+	panic: running the script still panics
+
+Each pass tries to remove whole transactions, then each site failure together with its recovery, then chunks of lines. Passes repeat until none of them removes anything.
+***************************/
+
+package shrink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/mingyi850/repcrec/internal/workload"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type Failure string
+
+const (
+	FailedExpectation  Failure = "expect"
+	InvariantViolation Failure = "invariant"
+	Panic              Failure = "panic"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Returns true if a candidate script fails in the same way as the original script */
+type Predicate func(lines []parser.Line) bool
+
+/* The shrunk script and the number of candidate scripts run to find it */
+type Result struct {
+	Lines []parser.Line
+	Runs  int
+}
+
+/* Position of a command within a script */
+type position struct {
+	line    int
+	command int
+}
+
+type shrinker struct {
+	fails Predicate
+	runs  int
+}
+
+/*
+Returns a predicate which holds for scripts failing in the same way as the given script, along with a description of the failure.
+Returns an error if the script does not fail in that way
+*/
+func NewPredicate(failure Failure, lines []parser.Line) (Predicate, string, error) {
+	switch failure {
+	case FailedExpectation:
+		failed := failedExpectations(lines)
+		if len(failed) == 0 {
+			return nil, "", fmt.Errorf("every expectation of the script holds")
+		}
+		target := failed[0]
+		return func(candidate []parser.Line) bool {
+			return slices.Contains(failedExpectations(candidate), target)
+		}, target, nil
+	case InvariantViolation:
+		violation, err := runDriver(lines)
+		if err != nil {
+			return nil, "", err
+		}
+		if violation == nil {
+			return nil, "", fmt.Errorf("every invariant holds throughout the script")
+		}
+		return func(candidate []parser.Line) bool {
+			candidateViolation, err := runDriver(candidate)
+			return err == nil && candidateViolation != nil && candidateViolation.Invariant == violation.Invariant
+		}, violation.Error(), nil
+	case Panic:
+		message := runForPanic(lines)
+		if message == "" {
+			return nil, "", fmt.Errorf("the script does not panic")
+		}
+		return func(candidate []parser.Line) bool {
+			return runForPanic(candidate) == message // Another panic is another bug
+		}, "panic: " + message, nil
+	}
+	return nil, "", fmt.Errorf("unknown failure %q, expected %s, %s or %s", failure, FailedExpectation, InvariantViolation, Panic)
+}
+
+/* Shrinks a script to a smaller one for which the predicate still holds. The predicate must hold for the given script */
+func Shrink(lines []parser.Line, fails Predicate) Result {
+	s := &shrinker{fails: fails}
+	for {
+		before := countCommands(lines)
+		lines = s.removeTransactions(lines)
+		lines = s.removeSiteEvents(lines)
+		lines = s.removeLines(lines)
+		if countCommands(lines) == before {
+			return Result{lines, s.runs}
+		}
+	}
+}
+
+/* Returns the script, one line per line of the script with its commands separated by semicolons */
+func Format(lines []parser.Line) string {
+	var builder strings.Builder
+	for _, line := range lines {
+		commands := make([]string, len(line.Commands))
+		for i, command := range line.Commands {
+			commands[i] = command.String()
+		}
+		builder.WriteString(strings.Join(commands, "; "))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+func (s *shrinker) test(lines []parser.Line) bool {
+	s.runs++
+	return s.fails(lines)
+}
+
+/* Tries to remove every command of each transaction, including expectations about it */
+func (s *shrinker) removeTransactions(lines []parser.Line) []parser.Line {
+	transactions := make([]int, 0)
+	for _, line := range lines {
+		for _, command := range line.Commands {
+			if tx := command.Tx(); tx != -1 && !slices.Contains(transactions, tx) {
+				transactions = append(transactions, tx)
+			}
+		}
+	}
+	for _, tx := range transactions {
+		candidate := filter(lines, func(_ position, command parser.Command) bool {
+			return command.Tx() == tx
+		})
+		if s.test(candidate) {
+			lines = candidate
+		}
+	}
+	return lines
+}
+
+/* Tries to remove each site failure together with the recovery of the site which follows it, then each remaining recovery on its own */
+func (s *shrinker) removeSiteEvents(lines []parser.Line) []parser.Line {
+	for _, name := range []string{"fail", "recover"} {
+		for index := 0; ; {
+			event, ok := findCommand(lines, name, index)
+			if !ok {
+				break
+			}
+			site := lines[event.line].Commands[event.command].Number()
+			recovery, hasRecovery := position{}, false
+			if name == "fail" {
+				recovery, hasRecovery = findRecovery(lines, event, site)
+			}
+			candidate := filter(lines, func(at position, _ parser.Command) bool {
+				return at == event || (hasRecovery && at == recovery)
+			})
+			if s.test(candidate) {
+				lines = candidate // The next event now has the same index
+			} else {
+				index++
+			}
+		}
+	}
+	return lines
+}
+
+/* Removes chunks of lines, halving the chunk size whenever no chunk can be removed (ddmin) */
+func (s *shrinker) removeLines(lines []parser.Line) []parser.Line {
+	chunks := 2
+	for len(lines) >= 2 {
+		size := (len(lines) + chunks - 1) / chunks
+		removed := false
+		for start := 0; start < len(lines); start += size {
+			candidate := append(slices.Clone(lines[:start]), lines[min(start+size, len(lines)):]...)
+			if s.test(candidate) {
+				lines = candidate
+				chunks = max(chunks-1, 2)
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			if chunks >= len(lines) {
+				break
+			}
+			chunks = min(chunks*2, len(lines))
+		}
+	}
+	return lines
+}
+
+/* Returns the commands of the script which do not match drop. Lines left without commands are removed */
+func filter(lines []parser.Line, drop func(at position, command parser.Command) bool) []parser.Line {
+	result := make([]parser.Line, 0, len(lines))
+	for i, line := range lines {
+		commands := make([]parser.Command, 0, len(line.Commands))
+		for j, command := range line.Commands {
+			if !drop(position{i, j}, command) {
+				commands = append(commands, command)
+			}
+		}
+		if len(commands) > 0 {
+			result = append(result, parser.Line{File: line.File, Number: line.Number, Commands: commands})
+		}
+	}
+	return result
+}
+
+/* Returns the position of the index-th command with the given name */
+func findCommand(lines []parser.Line, name string, index int) (position, bool) {
+	for i, line := range lines {
+		for j, command := range line.Commands {
+			if command.Name != name {
+				continue
+			}
+			if index == 0 {
+				return position{i, j}, true
+			}
+			index--
+		}
+	}
+	return position{}, false
+}
+
+/* Returns the position of the first recovery of a site after the given position */
+func findRecovery(lines []parser.Line, after position, site int) (position, bool) {
+	for i := after.line; i < len(lines); i++ {
+		for j, command := range lines[i].Commands {
+			if (i > after.line || j > after.command) && command.Name == "recover" && command.Number() == site {
+				return position{i, j}, true
+			}
+		}
+	}
+	return position{}, false
+}
+
+func countCommands(lines []parser.Line) int {
+	count := 0
+	for _, line := range lines {
+		count += len(line.Commands)
+	}
+	return count
+}
+
+/* Runs a script and returns every expectation which did not hold with its message. Returns nil if the run stopped on another error */
+func failedExpectations(lines []parser.Line) []string {
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	err := internal.RunScript(&parser.Script{Lines: lines}, siteCoordinator, domain.CreateTransactionManager(siteCoordinator), internal.Options{Output: io.Discard})
+	var commandErrors internal.CommandErrors
+	if !errors.As(err, &commandErrors) {
+		return nil
+	}
+	failed := make([]string, 0)
+	for _, commandError := range commandErrors {
+		var expectationError *internal.ExpectationError
+		if errors.As(commandError.Err, &expectationError) {
+			failed = append(failed, fmt.Sprintf("%s: %v", commandError.Command, commandError.Err))
+		}
+	}
+	return failed
+}
+
+/* Runs a script, continuing past errors, and returns the value it panicked with or an empty string if it did not panic */
+func runForPanic(lines []parser.Line) (message string) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			message = fmt.Sprint(recovered)
+		}
+	}()
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	internal.RunScript(&parser.Script{Lines: lines}, siteCoordinator, domain.CreateTransactionManager(siteCoordinator), internal.Options{ContinueOnError: true, Output: io.Discard})
+	return ""
+}
+
+/*
+Runs a script with the fault-injection driver. Every command which changes state is a step and runs at its own tick, so commands sharing a line run at consecutive ticks.
+Commands which only inspect state are left out
+*/
+func runDriver(lines []parser.Line) (*workload.Violation, error) {
+	steps := make([]workload.Step, 0)
+	for _, line := range lines {
+		for _, command := range line.Commands {
+			switch command.Name {
+			case "begin":
+				steps = append(steps, workload.Step{Kind: workload.BeginStep, Tx: command.Tx()})
+			case "beginRO":
+				steps = append(steps, workload.Step{Kind: workload.BeginROStep, Tx: command.Tx()})
+			case "R":
+				if _, asOf := command.Arg(parser.TickArg); asOf {
+					return nil, fmt.Errorf("%s: reads as of a past tick cannot be run by the driver", command.Pos)
+				}
+				steps = append(steps, workload.Step{Kind: workload.ReadStep, Tx: command.Tx(), Key: command.Key()})
+			case "W":
+				steps = append(steps, workload.Step{Kind: workload.WriteStep, Tx: command.Tx(), Key: command.Key(), Value: command.Number()})
+			case "end":
+				steps = append(steps, workload.Step{Kind: workload.EndStep, Tx: command.Tx()})
+			case "fail":
+				steps = append(steps, workload.Step{Kind: workload.FailStep, Site: command.Number()})
+			case "recover":
+				steps = append(steps, workload.Step{Kind: workload.RecoverStep, Site: command.Number()})
+			}
+		}
+	}
+	return workload.RunSteps("", steps), nil
+}
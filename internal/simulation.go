@@ -7,247 +7,365 @@ Description: This file contains the implementation of the Simulation function. T
 package internal
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"regexp"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/mingyi850/repcrec/internal/clock"
 	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/mingyi850/repcrec/internal/utils"
 )
 
-/* Simulation reads the input file and interacts with TransactionManager and SiteCoordinator */
-func Simulation(file *os.File, siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager) error {
-	time := 1
-	scanner := bufio.NewScanner(file)
-	commentFlag := false
-	for scanner.Scan() {
-		line := scanner.Text()
-		switch {
-		case isCommentStart(line): // Allows multiline comments
-			commentFlag = true
-			continue
-		case isCommentEnd(line): // Allows multiline comments
-			commentFlag = false
-			continue
-		case isComment(line, commentFlag): // Allows single line comments
-			continue
-		case line == "": // Skip empty lines
-			continue
-		case isBegin(line):
-			transaction, err := extractBegin(line)
-			if err != nil {
-				return err
-			}
-			if err = transactionManager.Begin(transaction, time); err != nil {
-				fmt.Println(err)
-				return err
-			}
-		case isEnd(line):
-			transaction, err := extractEnd(line)
-			if err != nil {
-				return err
-			}
-			result, err := transactionManager.End(transaction, time)
-			if err != nil {
-				return err
-			}
-			domain.HandleCommitResult(transaction, result)
-		case isWrite(line):
-			transaction, key, value, err := extractWrite(line)
-			if err != nil {
-				return err
-			}
-			result, err := transactionManager.Write(transaction, key, value, time)
-			if err != nil {
-				return err
-			}
-			domain.HandleWriteResult(transaction, key, result)
-		case isRead(line):
-			transaction, key, err := extractRead(line)
-			if err != nil {
-				return err
-			}
-			value, err := transactionManager.Read(transaction, key, time)
-			if err != nil {
-				return err
-			}
-			domain.HandleReadResult(transaction, key, value)
-		case isFail(line):
-			site, err := extractFail(line)
-			if err != nil {
-				return err
-			}
-			siteCoordinator.Fail(site, time)
-		case isRecover(line):
-			site, err := extractRecover(line)
-			if err != nil {
-				return err
-			}
-			siteCoordinator.Recover(site, time)
-			transactionManager.Recover(site, time)
-		case isDump(line):
-			result := siteCoordinator.Dump()
-			fmt.Println(result)
-		case isExit(line):
-			return nil
-		default:
-			return fmt.Errorf("could not parse line %q", line)
-		}
-		time++
-	}
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-	return nil
-}
-
 /*
-*************************
-Parser functions to check the type of line
-***************************
+***********
+Custom Structs
+***********
 */
-func isCommentStart(line string) bool {
-	return strings.HasPrefix(line, "/*")
-}
 
-func isCommentEnd(line string) bool {
-	return strings.HasSuffix(line, "*/")
+/* Options controlling how a simulation is run */
+type Options struct {
+	// Log errors and skip the offending command instead of stopping the run
+	ContinueOnError bool
+	// Clock giving the time of each line. A logical clock starting at tick 1 if nil
+	Clock clock.Clock
+	// Run anti-entropy after every AntiEntropyEvery lines, repairing diverged replicas. Never if zero
+	AntiEntropyEvery int
+	// Where the output of the run is written, including the output of the transaction manager. Stdout if nil
+	Output io.Writer
 }
-func isComment(line string, commentFlag bool) bool {
-	return commentFlag || strings.HasPrefix(line, "//")
+
+/* An error raised by a single command, or a syntax error on a single line. File is empty for the main input */
+type CommandError struct {
+	File    string
+	Line    int
+	Command string
+	Err     error
 }
-func isBegin(line string) bool {
-	return strings.HasPrefix(line, "begin")
+
+func (e *CommandError) Error() string {
+	location := fmt.Sprintf("line %d", e.Line)
+	if e.File != "" {
+		location = fmt.Sprintf("%s, line %d", e.File, e.Line)
+	}
+	if e.Command == "" {
+		return fmt.Sprintf("%s: %v", location, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", location, e.Command, e.Err)
 }
 
-func isEnd(line string) bool {
-	return strings.HasPrefix(line, "end")
+func (e *CommandError) Unwrap() error {
+	return e.Err
 }
 
-func isWrite(line string) bool {
-	return strings.HasPrefix(line, "W(")
+/* All errors encountered by a run with ContinueOnError set, in the order they occurred */
+type CommandErrors []*CommandError
+
+func (e CommandErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = "  " + err.Error()
+	}
+	noun := "errors"
+	if len(e) == 1 {
+		noun = "error"
+	}
+	return fmt.Sprintf("%d %s encountered:\n%s", len(e), noun, strings.Join(messages, "\n"))
 }
 
-func isRead(line string) bool {
-	return strings.HasPrefix(line, "R(")
+/* Returns the errors, so that errors.Is and errors.As look through each of them */
+func (e CommandErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
 }
 
-func isFail(line string) bool {
-	return strings.HasPrefix(line, "fail")
+/* Holds the components a script is run against and the clock. Each line of commands is executed at its own tick */
+type simulation struct {
+	siteCoordinator    domain.SiteCoordinator
+	transactionManager domain.TransactionManager
+	clock              clock.Clock
+	options            Options
+	errors             CommandErrors
+	lines              int // Lines and requests which have taken a tick, counted for anti-entropy
+	logger             *utils.Logger
 }
 
-func isRecover(line string) bool {
-	return strings.HasPrefix(line, "recover")
+/*
+Simulation reads the input file and interacts with TransactionManager and SiteCoordinator
+Regular files are parsed completely before execution, so that all syntax errors are reported at once. Their includes are resolved against the directory of the file.
+Other input (such as stdin) is executed line by line as it is entered.
+*/
+func Simulation(file *os.File, siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager) error {
+	return SimulationWithOptions(file, siteCoordinator, transactionManager, Options{})
 }
 
-func isDump(line string) bool {
-	return strings.HasPrefix(line, "dump")
+/*
+Runs the simulation with the given options.
+With ContinueOnError set, each error is logged with its line number and the offending command is skipped.
+Failed expectations are always logged and skipped. The run then returns CommandErrors holding every error skipped, or nil if there were none.
+Syntax errors in a regular file still stop the run before anything is executed.
+*/
+func SimulationWithOptions(file *os.File, siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager, options Options) error {
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		script, err := parser.ParseInDir(file, filepath.Dir(file.Name()))
+		if err != nil {
+			return err
+		}
+		return RunScript(script, siteCoordinator, transactionManager, options)
+	}
+	sim := createSimulation(siteCoordinator, transactionManager, options)
+	lineParser := parser.NewParser(file)
+	reported := 0
+	for {
+		line, ok := lineParser.ParseLine()
+		if err := lineParser.Err(); err != nil {
+			syntaxErrors, isSyntax := err.(parser.ErrorList)
+			if !options.ContinueOnError || !isSyntax {
+				return sim.result(err)
+			}
+			for _, syntaxError := range syntaxErrors[reported:] {
+				sim.report(&CommandError{File: syntaxError.Pos.File, Line: syntaxError.Pos.Line, Err: syntaxError})
+			}
+			reported = len(syntaxErrors)
+		}
+		if !ok {
+			return sim.result(nil)
+		}
+		if len(line.Commands) == 0 { // Skip empty lines and comments
+			continue
+		}
+		exit, err := sim.executeLine(line)
+		if err != nil || exit {
+			return sim.result(err)
+		}
+	}
 }
 
-func isExit(line string) bool {
-	return strings.HasPrefix(line, "exit")
+/* Runs a parsed script from the first tick. Returns the same errors as SimulationWithOptions */
+func RunScript(script *parser.Script, siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager, options Options) error {
+	sim := createSimulation(siteCoordinator, transactionManager, options)
+	for _, line := range script.Lines {
+		exit, err := sim.executeLine(line)
+		if err != nil || exit {
+			return sim.result(err)
+		}
+	}
+	return sim.result(nil)
 }
 
 /*
 *************************
-Parser functions to extract data from the line
+Private Methods
 ***************************
 */
 
-// Example: R(T1, x4) -> 1, 4
-func extractRead(line string) (int, int, error) {
-	re := regexp.MustCompile(`R\(T(\d+),\s*x(\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		tx, err := strconv.Atoi(matches[1])
-		if err != nil {
-			return -1, -1, fmt.Errorf("could not convert transaction ID in line %q: %v", line, err)
+func createSimulation(siteCoordinator domain.SiteCoordinator, transactionManager domain.TransactionManager, options Options) *simulation {
+	simulationClock := options.Clock
+	if simulationClock == nil {
+		simulationClock = clock.CreateLogicalClock()
+	}
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	logger := utils.CreateLogger(output)
+	transactionManager.SetLogger(logger)
+	return &simulation{
+		siteCoordinator:    siteCoordinator,
+		transactionManager: transactionManager,
+		clock:              simulationClock,
+		options:            options,
+		logger:             logger,
+	}
+}
+
+/*
+Executes all commands on a line at the current time of the clock, then advances the clock. Returns true if the script should exit
+Commands sharing a line run from left to right, so later commands observe the effects of earlier ones
+Failed expectations are reported and skipped even without ContinueOnError. Lines holding only expectations do not advance the tick
+*/
+func (s *simulation) executeLine(line parser.Line) (bool, error) {
+	for _, command := range line.Commands {
+		exit, err := s.execute(command)
+		var expectationError *ExpectationError
+		if err != nil && (s.options.ContinueOnError || errors.As(err, &expectationError)) {
+			s.report(&CommandError{File: line.File, Line: line.Number, Command: command.String(), Err: err})
+			continue
 		}
-		key, err := strconv.Atoi(matches[2])
-		if err != nil {
-			return -1, -1, fmt.Errorf("could not convert key ID in line %q: %v", line, err)
+		if err != nil || exit {
+			return exit, err
+		}
+	}
+	if !onlyExpectations(line) {
+		s.tick()
+	}
+	return false, nil
+}
+
+/* Advances the clock past a line or request, running anti-entropy after every AntiEntropyEvery of them */
+func (s *simulation) tick() {
+	s.clock.Advance()
+	s.lines++
+	if s.options.AntiEntropyEvery > 0 && s.lines%s.options.AntiEntropyEvery == 0 {
+		for _, divergence := range s.siteCoordinator.AntiEntropy(true) {
+			s.logger.Log("anti-entropy: " + divergence.String())
+		}
+	}
+}
+
+/* Returns true if every command on the line is an expect command. Such lines check the state without taking a tick */
+func onlyExpectations(line parser.Line) bool {
+	for _, command := range line.Commands {
+		if command.Name != "expect" {
+			return false
 		}
-		return tx, key, nil
 	}
-	return -1, -1, fmt.Errorf("could not extract read line %q", line)
+	return true
+}
+
+/* Logs an error which has been skipped over, so that it can be summarised at the end of the run */
+func (s *simulation) report(err *CommandError) {
+	s.logger.Log(fmt.Sprintf("Error at %s", err))
+	s.errors = append(s.errors, err)
+}
+
+/* Returns the error the run ended with. If errors were skipped over, this is the summary of every error encountered */
+func (s *simulation) result(err error) error {
+	if err != nil || len(s.errors) == 0 {
+		return err
+	}
+	return s.errors
 }
 
-// Example W(T2, x6, v) -> 2, 6, v
-func extractWrite(line string) (int, int, int, error) {
-	re := regexp.MustCompile(`W\(T(\d+),\s*x(\d+),\s*(\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 2 {
-		tx, err := strconv.Atoi(matches[1])
+/* Executes a single command at the current tick. Returns true if the script should exit */
+func (s *simulation) execute(command parser.Command) (bool, error) {
+	if err := s.checkArgs(command); err != nil {
+		return false, err
+	}
+	time := s.clock.Now()
+	switch command.Name {
+	case "beginRO":
+		if err := s.transactionManager.BeginRO(command.Tx(), time); err != nil {
+			return false, err
+		}
+	case "begin":
+		if err := s.transactionManager.Begin(command.Tx(), time); err != nil {
+			return false, err
+		}
+	case "end":
+		result, err := s.transactionManager.End(command.Tx(), time)
 		if err != nil {
-			return -1, -1, -1, fmt.Errorf("could not convert transaction ID in line %q: %v", line, err)
+			return false, err
 		}
-		key, err := strconv.Atoi(matches[2])
+		domain.HandleCommitResult(s.logger, command.Tx(), result)
+	case "W":
+		result, err := s.transactionManager.Write(command.Tx(), command.Key(), command.Number(), time)
 		if err != nil {
-			return -1, -1, -1, fmt.Errorf("could not convert key ID in line %q: %v", line, err)
+			return false, err
+		}
+		domain.HandleWriteResult(s.logger, command.Tx(), command.Key(), result)
+	case "R":
+		var value domain.ReadResult
+		var err error
+		if asOf, exists := command.Arg(parser.TickArg); exists {
+			value, err = s.transactionManager.ReadAsOf(command.Tx(), command.Key(), asOf, time)
+		} else {
+			value, err = s.transactionManager.Read(command.Tx(), command.Key(), time)
 		}
-		value, err := strconv.Atoi(matches[3])
 		if err != nil {
-			return -1, -1, -1, fmt.Errorf("could not convert value in line %q: %v", line, err)
+			return false, err
 		}
-		return tx, key, value, nil
-	}
-	return -1, -1, -1, fmt.Errorf("could not extract write line %q", line)
-}
-
-// Example begin(T1) -> 1
-func extractBegin(line string) (int, error) {
-	re := regexp.MustCompile(`begin\(T(\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		tx, err := strconv.Atoi(matches[1])
+		domain.HandleReadResult(s.logger, command.Tx(), command.Key(), value)
+	case "fail":
+		if err := s.siteCoordinator.Fail(command.Number(), time); err != nil {
+			return false, err
+		}
+	case "recover":
+		if err := s.siteCoordinator.Recover(command.Number(), time); err != nil {
+			return false, err
+		}
+		s.transactionManager.Recover(command.Number(), time)
+	case "dumphistory":
+		result, err := s.siteCoordinator.DumpHistory(command.Key())
+		if err != nil {
+			return false, err
+		}
+		s.logger.Log(result)
+	case "dumpasof":
+		s.logger.Log(s.siteCoordinator.DumpAsOf(command.Number()))
+	case "dump":
+		result, err := s.dump(command)
 		if err != nil {
-			return -1, fmt.Errorf("could not convert transaction ID in line %q: %v", line, err)
+			return false, err
 		}
-		return tx, nil
+		s.logger.Log(result)
+	case "querystate":
+		s.logger.Log(s.transactionManager.QueryState())
+		s.logger.Log(s.siteCoordinator.QueryState())
+	case "graph":
+		s.logger.Log(s.transactionManager.GetTransactionGraph().ExportDot())
+	case "verify":
+		s.logger.Log(s.verify())
+	case "explain":
+		explanation, err := s.transactionManager.Explain(command.Tx())
+		if err != nil {
+			return false, err
+		}
+		s.logger.Log(explanation)
+	case "expect":
+		return false, s.checkExpectation(command)
+	case "exit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: unsupported command %q", command.Pos, command.Name)
 	}
-	return -1, fmt.Errorf("could not extract begin line %q", line)
+	return false, nil
 }
 
-// Example end(T1) -> 1
-func extractEnd(line string) (int, error) {
-	re := regexp.MustCompile(`end\(T(\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		tx, err := strconv.Atoi(matches[1])
-		if err != nil {
-			return -1, fmt.Errorf("could not convert transaction ID in line %q: %v", line, err)
+/* Returns an error if a command names a key or site which does not exist. The components expect existing ones, and a client must not be able to crash a server with them */
+func (s *simulation) checkArgs(command parser.Command) error {
+	if command.Name == "expect" { // Expectations report missing keys and sites as failed expectations
+		return nil
+	}
+	if key, exists := command.Arg(parser.KeyArg); exists && (key < 1 || key > domain.NumKeys) {
+		return fmt.Errorf("key x%d does not exist", key)
+	}
+	switch command.Name {
+	case "fail", "recover", "dump":
+		if site, exists := command.Arg(parser.NumArg); exists && !slices.Contains(s.siteCoordinator.GetSites(), site) {
+			return fmt.Errorf("site %d does not exist", site)
 		}
-		return tx, nil
 	}
-	return -1, fmt.Errorf("could not extract end line %q", line)
+	return nil
 }
 
-// Example fail(3) -> 3
-func extractFail(line string) (int, error) {
-	re := regexp.MustCompile(`fail\((\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		site, err := strconv.Atoi(matches[1])
-		if err != nil {
-			return -1, fmt.Errorf("could not convert site ID in line %q: %v", line, err)
-		}
-		return site, nil
+// Example dump() -> all sites, dump(x4) -> key 4 at every site, dump(3) -> site 3
+func (s *simulation) dump(command parser.Command) (string, error) {
+	if key, exists := command.Arg(parser.KeyArg); exists {
+		return s.siteCoordinator.DumpKey(key)
+	}
+	if site, exists := command.Arg(parser.NumArg); exists {
+		return s.siteCoordinator.DumpSite(site)
 	}
-	return -1, fmt.Errorf("could not extract fail line %q", line)
+	return s.siteCoordinator.Dump(), nil
 }
 
-// Example recover(3) -> 3
-func extractRecover(line string) (int, error) {
-	re := regexp.MustCompile(`recover\((\d+)\)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		site, err := strconv.Atoi(matches[1])
-		if err != nil {
-			return -1, fmt.Errorf("could not convert site ID in line %q: %v", line, err)
-		}
-		return site, nil
+/* Runs anti-entropy, repairing diverged replicas, and returns a line for each diverged replica, or a line saying the replicas agree */
+func (s *simulation) verify() string {
+	divergences := s.siteCoordinator.AntiEntropy(true)
+	if len(divergences) == 0 {
+		return "verify: replicas agree"
+	}
+	lines := make([]string, len(divergences))
+	for i, divergence := range divergences {
+		lines[i] = "verify: " + divergence.String()
 	}
-	return -1, fmt.Errorf("could not extract recover line %q", line)
+	return strings.Join(lines, "\n")
 }
@@ -2,36 +2,87 @@ package utils
 
 import (
 	"fmt"
+	"io"
 )
 
-func LogRead(transaction int, key int, value int) {
-	fmt.Printf("x%d: %d\n", key, value)
+/*
+Writes the output of a simulation, or of a component, to its own writer, so that several of them can run at once.
+Output about a transaction can be routed to a writer of its own, e.g. the connection of the client which began it
+*/
+type Logger struct {
+	output io.Writer
+	route  func(transaction int) io.Writer
 }
 
-func LogAbort(transaction int, reason string) {
+/* Creates a Logger writing to the given writer, e.g. io.Discard for a component whose output nobody reads */
+func CreateLogger(output io.Writer) *Logger {
+	return &Logger{output: output}
+}
+
+/* Redirects the output of the logger, returning the previous writer so that it can be restored */
+func (l *Logger) SetOutput(writer io.Writer) io.Writer {
+	previous := l.output
+	l.output = writer
+	return previous
+}
+
+/*
+Routes output about each transaction to the writer chosen by route. Output goes to the output writer when route is nil or returns nil.
+Returns the previous route so that it can be restored
+*/
+func (l *Logger) SetTransactionOutput(route func(transaction int) io.Writer) func(transaction int) io.Writer {
+	previous := l.route
+	l.route = route
+	return previous
+}
+
+/* Writes a line of output */
+func (l *Logger) Log(text string) {
+	fmt.Fprintln(l.output, text)
+}
+
+func (l *Logger) LogRead(transaction int, key int, value int) {
+	fmt.Fprintf(l.writerFor(transaction), "x%d: %d\n", key, value)
+}
+
+func (l *Logger) LogAbort(transaction int, reason string) {
 	if reason == "" {
-		fmt.Printf("T%d aborts\n", transaction)
+		fmt.Fprintf(l.writerFor(transaction), "T%d aborts\n", transaction)
 	} else {
-		fmt.Printf("T%d aborts: %s\n", transaction, reason)
+		fmt.Fprintf(l.writerFor(transaction), "T%d aborts: %s\n", transaction, reason)
 	}
 }
 
-func LogAborted(transaction int) {
-	fmt.Printf("T%d already aborted\n", transaction)
+func (l *Logger) LogAborted(transaction int) {
+	fmt.Fprintf(l.writerFor(transaction), "T%d already aborted\n", transaction)
+}
+
+func (l *Logger) LogWait(transaction int) {
+	fmt.Fprintf(l.writerFor(transaction), "T%d waits\n", transaction)
 }
 
-func LogWait(transaction int) {
-	fmt.Printf("T%d waits\n", transaction)
+func (l *Logger) LogWaiting(transaction int) {
+	fmt.Fprintf(l.writerFor(transaction), "T%d waiting\n", transaction)
 }
 
-func LogWaiting(transaction int) {
-	fmt.Printf("T%d waiting\n", transaction)
+func (l *Logger) LogCommit(transaction int) {
+	fmt.Fprintf(l.writerFor(transaction), "T%d commits\n", transaction)
 }
 
-func LogCommit(transaction int) {
-	fmt.Printf("T%d commits\n", transaction)
+func (l *Logger) LogWrite(transaction int, key int, sites []int) {
+	fmt.Fprintf(l.writerFor(transaction), "T%d writes x%d: sites: %v\n", transaction, key, sites)
 }
 
-func LogWrite(transaction int, key int, sites []int) {
-	fmt.Printf("T%d writes x%d: sites: %v\n", transaction, key, sites)
+/* Writes the RW cycle which aborted a transaction in DOT format, headed by a DOT comment naming the transaction */
+func (l *Logger) LogGraph(transaction int, dot string) {
+	fmt.Fprintf(l.writerFor(transaction), "// RW cycle which aborted T%d\n%s\n", transaction, dot)
+}
+
+func (l *Logger) writerFor(transaction int) io.Writer {
+	if l.route != nil {
+		if writer := l.route(transaction); writer != nil {
+			return writer
+		}
+	}
+	return l.output
 }
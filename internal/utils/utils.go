@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"cmp"
+	"slices"
+)
+
 func GetRange(start int, end int, interval int) []int {
 	result := make([]int, (end-start)/interval+1)
 	for i := 0; i < len(result); i++ {
@@ -23,3 +28,9 @@ func AddIfAbsent[K comparable, V any](m map[K]V, key K, value V) {
 		m[key] = value
 	}
 }
+
+func GetSortedMapKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := GetMapKeys(m)
+	slices.Sort(keys)
+	return keys
+}
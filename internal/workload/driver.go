@@ -0,0 +1,265 @@
+/**************************
+File: driver.go
+Author: Mingyi Lim
+Description: This file contains the fault-injection driver. It runs generated steps directly against a TransactionManagerImpl while sites fail and recover at random, and checks the following invariants after every tick:
+
+	replicas agree: every site which can serve a read of a key holds the last committed value of the key
+	no lost writes: every committed write is held by at least one site holding the key
+	committed reads: every read returns the value committed as of the start of its transaction
+	serializable: the committed history has no dependency cycles
+
+A run is deterministic for its steps, so a violation is reproduced by replaying the script of the steps up to the tick it was found at.
+***************************/
+
+package workload
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/history"
+	"github.com/mingyi850/repcrec/internal/utils"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type Invariant string
+
+const (
+	ReplicasAgree  Invariant = "replicas agree"
+	NoLostWrites   Invariant = "no lost writes"
+	CommittedReads Invariant = "committed reads"
+	Serializable   Invariant = "serializable"
+	ValidCommands  Invariant = "valid commands" // Generated steps never use a transaction or site wrongly, so an error is a bug
+)
+
+/* Number of sites of the database, as created by CreateSiteCoordinator(10) */
+const numSites = 10
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* An invariant which did not hold. Script replays the steps up to and including the tick the violation was found at */
+type Violation struct {
+	Tick      int
+	Invariant Invariant
+	Detail    string
+	Script    string
+}
+
+/* Returns the violation as "<invariant> violated at tick <n>: <detail>" */
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s violated at tick %d: %s", v.Invariant, v.Tick, v.Detail)
+}
+
+/* Runs steps against a fresh database. Keeps track of what is needed to check invariants between ticks */
+type driver struct {
+	siteCoordinator    *domain.SiteCoordinatorImpl
+	transactionManager *domain.TransactionManagerImpl
+	starts             map[int]int   // Start tick of each transaction
+	waitingReads       map[int][]int // Keys of reads which are waiting for a site, by transaction
+	history            history.History
+}
+
+/*
+Generates the steps for a config and runs them, checking invariants after every tick.
+Returns the first violation found, or nil if every invariant held throughout
+*/
+func Run(config Config) (*Violation, error) {
+	steps, err := Steps(config)
+	if err != nil {
+		return nil, err
+	}
+	return RunSteps("// Generated by repcrec gen "+config.String(), steps), nil
+}
+
+/* Runs steps against a fresh database, where the i-th step runs at tick i+1. The header is the first line of the script of a violation */
+func RunSteps(header string, steps []Step) *Violation {
+	siteCoordinator := domain.CreateSiteCoordinator(numSites)
+	defer siteCoordinator.Close()
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	transactionManager.SetLogger(utils.CreateLogger(io.Discard))
+	d := &driver{
+		siteCoordinator:    siteCoordinator,
+		transactionManager: transactionManager,
+		starts:             make(map[int]int),
+		waitingReads:       make(map[int][]int),
+	}
+	for i, step := range steps {
+		tick := i + 1
+		invariant, detail := d.execute(step, tick)
+		if invariant == "" {
+			invariant, detail = d.check(tick)
+		}
+		if invariant != "" {
+			header := fmt.Sprintf("%s\n// Stopped at tick %d: %s violated: %s", header, tick, invariant, detail)
+			return &Violation{tick, invariant, detail, Script(header, steps[:i+1])}
+		}
+	}
+	return nil
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Executes a single step at a tick, as the simulation would. Returns the invariant violated by the step, if any */
+func (d *driver) execute(step Step, tick int) (Invariant, string) {
+	if err := d.checkStep(step); err != nil {
+		return ValidCommands, fmt.Sprintf("%s: %v", step, err)
+	}
+	var err error
+	switch step.Kind {
+	case BeginStep:
+		err = d.transactionManager.Begin(step.Tx, tick)
+		d.starts[step.Tx] = tick
+	case BeginROStep:
+		err = d.transactionManager.BeginRO(step.Tx, tick)
+		d.starts[step.Tx] = tick
+	case ReadStep:
+		var result domain.ReadResult
+		result, err = d.transactionManager.Read(step.Tx, step.Key, tick)
+		if err != nil {
+			break
+		}
+		switch result.ResultType {
+		case domain.Success:
+			if expected := d.snapshotValue(step.Key, d.starts[step.Tx]); result.Value != expected {
+				return CommittedReads, fmt.Sprintf("T%d read x%d = %d, but %d was committed when it began at tick %d", step.Tx, step.Key, result.Value, expected, d.starts[step.Tx])
+			}
+		case domain.Wait, domain.Waiting:
+			d.waitingReads[step.Tx] = append(d.waitingReads[step.Tx], step.Key)
+		}
+	case WriteStep:
+		_, err = d.transactionManager.Write(step.Tx, step.Key, step.Value, tick)
+	case EndStep:
+		_, err = d.transactionManager.End(step.Tx, tick)
+	case FailStep:
+		err = d.siteCoordinator.Fail(step.Site, tick)
+	case RecoverStep:
+		err = d.siteCoordinator.Recover(step.Site, tick)
+		if err == nil {
+			err = d.transactionManager.Recover(step.Site, tick)
+		}
+	case DumpStep:
+		d.siteCoordinator.Dump()
+	}
+	if err != nil {
+		return ValidCommands, fmt.Sprintf("%s: %v", step, err)
+	}
+	return "", ""
+}
+
+/* Returns an error if a step names a key or site which does not exist, as a script passed to the driver may */
+func (d *driver) checkStep(step Step) error {
+	switch step.Kind {
+	case ReadStep, WriteStep:
+		if step.Key < 1 || step.Key > domain.NumKeys {
+			return fmt.Errorf("key x%d does not exist", step.Key)
+		}
+	case FailStep, RecoverStep:
+		if !slices.Contains(d.siteCoordinator.GetSites(), step.Site) {
+			return fmt.Errorf("site %d does not exist", step.Site)
+		}
+	}
+	return nil
+}
+
+/* Checks every invariant after a tick. Returns the first invariant which does not hold, if any */
+func (d *driver) check(tick int) (Invariant, string) {
+	d.history = d.transactionManager.History()
+	checks := []func(tick int) (Invariant, string){d.checkWaitingReads, d.checkReplicas, d.checkCommittedWrites, d.checkSerializable}
+	for _, check := range checks {
+		if invariant, detail := check(tick); invariant != "" {
+			return invariant, detail
+		}
+	}
+	return "", ""
+}
+
+/* Reads which waited for a site complete when it recovers. Checks them once their transaction is no longer waiting */
+func (d *driver) checkWaitingReads(tick int) (Invariant, string) {
+	for _, tx := range utils.GetSortedMapKeys(d.waitingReads) {
+		transaction, waiting, err := d.transactionManager.GetTransaction(tx)
+		if err != nil || waiting {
+			continue
+		}
+		keys := d.waitingReads[tx]
+		delete(d.waitingReads, tx)
+		if transaction.GetState() == domain.TxAborted {
+			continue
+		}
+		for _, key := range keys {
+			value, _ := transaction.GetLastRead(key)
+			if expected := d.snapshotValue(key, d.starts[tx]); value != expected {
+				return CommittedReads, fmt.Sprintf("T%d read x%d = %d after waiting, but %d was committed when it began at tick %d", tx, key, value, expected, d.starts[tx])
+			}
+		}
+	}
+	return "", ""
+}
+
+/* Every site which can serve a read of a key must hold its last committed value */
+func (d *driver) checkReplicas(tick int) (Invariant, string) {
+	for key := 1; key <= domain.NumKeys; key++ {
+		expected := d.snapshotValue(key, tick)
+		for _, site := range d.siteCoordinator.GetValidSitesForRead(key, tick) {
+			value, err := d.siteCoordinator.GetLastCommitted(site, key)
+			if err != nil {
+				return ReplicasAgree, err.Error()
+			}
+			if value.GetValue() != expected {
+				return ReplicasAgree, fmt.Sprintf("site %d can serve reads of x%d and has %d, but the last committed value is %d", site, key, value.GetValue(), expected)
+			}
+		}
+	}
+	return "", ""
+}
+
+/* Every committed write must be held by at least one site, as the version committed at its commit time */
+func (d *driver) checkCommittedWrites(tick int) (Invariant, string) {
+	for _, transaction := range d.history.Transactions {
+		for _, key := range utils.GetSortedMapKeys(transaction.Writes) {
+			found := false
+			for _, site := range d.siteCoordinator.GetSitesForKey(key) {
+				version := d.siteCoordinator.Sites[site].Read(key, transaction.CommitTime)
+				if version.GetTime() == transaction.CommitTime && version.GetValue() == transaction.Writes[key] {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return NoLostWrites, fmt.Sprintf("T%d committed x%d = %d at tick %d, but no site holds it", transaction.ID, key, transaction.Writes[key], transaction.CommitTime)
+			}
+		}
+	}
+	return "", ""
+}
+
+func (d *driver) checkSerializable(tick int) (Invariant, string) {
+	if result := history.Check(d.history); !result.Serializable() {
+		return Serializable, result.String()
+	}
+	return "", ""
+}
+
+/* Returns the value of a key committed last at or before a tick, according to the committed history */
+func (d *driver) snapshotValue(key int, tick int) int {
+	value := key * 10
+	for _, transaction := range d.history.Transactions { // In commit order
+		if written, exists := transaction.Writes[key]; exists && transaction.CommitTime <= tick {
+			value = written
+		}
+	}
+	return value
+}
@@ -0,0 +1,319 @@
+/**************************
+File: generator.go
+Author: Mingyi Lim
+Description: This file contains the random workload generator. It generates steps, which are written as scripts in the simulation language, so generated workloads can be replayed, minimized and added to test/resources.
+Every transaction begins before it is used and ends exactly once, so a generated script always runs without errors. Sites fail and recover at random, and every failed site is recovered at the end of the script so that waiting transactions can finish.
+***************************/
+
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+type Distribution string
+
+const (
+	Uniform Distribution = "uniform" // Every key is equally likely
+	Zipf    Distribution = "zipf"    // The k-th hottest key is chosen with probability proportional to 1/k^ZipfExponent
+)
+
+type StepKind string
+
+const (
+	BeginStep   StepKind = "begin"
+	BeginROStep StepKind = "beginRO"
+	ReadStep    StepKind = "R"
+	WriteStep   StepKind = "W"
+	EndStep     StepKind = "end"
+	FailStep    StepKind = "fail"
+	RecoverStep StepKind = "recover"
+	DumpStep    StepKind = "dump"
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Parameters of a generated workload. The same config always generates the same script */
+type Config struct {
+	Seed               int64
+	Transactions       int          // Number of transactions
+	MaxOperations      int          // Each transaction reads or writes between 1 and MaxOperations times before it ends
+	Concurrency        int          // Maximum number of transactions running at once
+	ReadRatio          float64      // Probability that an operation is a read rather than a write
+	ReadOnlyRatio      float64      // Probability that a transaction is read-only (begun with beginRO)
+	Keys               int          // Operations use keys x1 to xKeys
+	Sites              int          // Sites which may fail, 1 to Sites
+	Distribution       Distribution // How keys are chosen
+	ZipfExponent       float64      // Skew of the Zipf distribution, larger is more skewed
+	FailProbability    float64      // Probability that a site fails at each tick
+	RecoverProbability float64      // Probability that a failed site recovers at each tick
+}
+
+/* A single generated command. Only the fields used by its kind are set */
+type Step struct {
+	Kind  StepKind
+	Tx    int
+	Key   int
+	Value int
+	Site  int
+}
+
+/* Generates scripts from a config. Values written are unique, T3 writes 3001, 3002 and so on */
+type generator struct {
+	config     Config
+	random     *rand.Rand
+	keyWeights []float64 // Cumulative probability of each key rank
+	keyRanks   []int     // Key of each rank, so that the hottest keys are spread across sites
+	steps      []Step
+	active     []*generatedTransaction
+	started    int
+	downSites  map[int]bool
+}
+
+type generatedTransaction struct {
+	id         int
+	readOnly   bool
+	operations int // Operations left before the transaction ends
+	writes     int
+}
+
+/* Returns the default config: 10 transactions over all 20 keys and 10 sites, with uniform keys and no failures */
+func DefaultConfig() Config {
+	return Config{
+		Seed:          1,
+		Transactions:  10,
+		MaxOperations: 4,
+		Concurrency:   3,
+		ReadRatio:     0.5,
+		Keys:          domain.NumKeys,
+		Sites:         10,
+		Distribution:  Uniform,
+		ZipfExponent:  1.0,
+	}
+}
+
+/* Checks that the config describes a workload which can be generated */
+func (c Config) Validate() error {
+	switch {
+	case c.Transactions < 1:
+		return fmt.Errorf("transactions must be at least 1, got %d", c.Transactions)
+	case c.MaxOperations < 1:
+		return fmt.Errorf("max operations must be at least 1, got %d", c.MaxOperations)
+	case c.Concurrency < 1:
+		return fmt.Errorf("concurrency must be at least 1, got %d", c.Concurrency)
+	case c.Keys < 1 || c.Keys > domain.NumKeys:
+		return fmt.Errorf("keys must be between 1 and %d, got %d", domain.NumKeys, c.Keys)
+	case c.Sites < 0 || c.Sites > 10:
+		return fmt.Errorf("sites must be between 0 and 10, got %d", c.Sites)
+	case c.Distribution != Uniform && c.Distribution != Zipf:
+		return fmt.Errorf("distribution must be %s or %s, got %q", Uniform, Zipf, c.Distribution)
+	case c.Distribution == Zipf && c.ZipfExponent <= 0:
+		return fmt.Errorf("zipf exponent must be positive, got %v", c.ZipfExponent)
+	}
+	probabilities := []struct {
+		name  string
+		value float64
+	}{
+		{"read ratio", c.ReadRatio},
+		{"read-only ratio", c.ReadOnlyRatio},
+		{"fail probability", c.FailProbability},
+		{"recover probability", c.RecoverProbability},
+	}
+	for _, probability := range probabilities {
+		if probability.value < 0 || probability.value > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", probability.name, probability.value)
+		}
+	}
+	return nil
+}
+
+/* Returns the flags of the gen command which generate this config */
+func (c Config) String() string {
+	return fmt.Sprintf("--seed %d --transactions %d --max-ops %d --concurrency %d --read-ratio %v --read-only-ratio %v --keys %d --sites %d --distribution %s --zipf-exponent %v --fail-prob %v --recover-prob %v",
+		c.Seed, c.Transactions, c.MaxOperations, c.Concurrency, c.ReadRatio, c.ReadOnlyRatio, c.Keys, c.Sites, c.Distribution, c.ZipfExponent, c.FailProbability, c.RecoverProbability)
+}
+
+/* Generates a script for the config. Each line of the script is a single command, so each command runs at its own tick */
+func Generate(config Config) (string, error) {
+	steps, err := Steps(config)
+	if err != nil {
+		return "", err
+	}
+	return Script("// Generated by repcrec gen "+config.String(), steps), nil
+}
+
+/* Generates the commands of the script for the config. The i-th step runs at tick i+1 */
+func Steps(config Config) ([]Step, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	g := &generator{
+		config:    config,
+		random:    rand.New(rand.NewSource(config.Seed)),
+		steps:     make([]Step, 0),
+		active:    make([]*generatedTransaction, 0),
+		downSites: make(map[int]bool),
+	}
+	g.initKeys()
+	for g.started < config.Transactions || len(g.active) > 0 {
+		g.step()
+	}
+	g.recoverAll()
+	g.steps = append(g.steps, Step{Kind: DumpStep})
+	return g.steps, nil
+}
+
+/* Writes steps as a script, one command per line, below a header comment */
+func Script(header string, steps []Step) string {
+	lines := []string{header}
+	for _, step := range steps {
+		lines = append(lines, step.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+/* Returns the step as a command of the simulation language, e.g. "W(T3, x4, 3001)" */
+func (s Step) String() string {
+	switch s.Kind {
+	case BeginStep, BeginROStep, EndStep:
+		return fmt.Sprintf("%s(T%d)", s.Kind, s.Tx)
+	case ReadStep:
+		return fmt.Sprintf("R(T%d, x%d)", s.Tx, s.Key)
+	case WriteStep:
+		return fmt.Sprintf("W(T%d, x%d, %d)", s.Tx, s.Key, s.Value)
+	case FailStep, RecoverStep:
+		return fmt.Sprintf("%s(%d)", s.Kind, s.Site)
+	}
+	return fmt.Sprintf("%s()", s.Kind)
+}
+
+/*
+*******
+Private Methods
+*******
+*/
+
+/* Sets up the key distribution. Ranks are mapped to keys in a random order, so the hottest keys are a mix of replicated and unreplicated keys */
+func (g *generator) initKeys() {
+	g.keyRanks = g.random.Perm(g.config.Keys)
+	g.keyWeights = make([]float64, g.config.Keys)
+	total := 0.0
+	for rank := range g.keyWeights {
+		weight := 1.0
+		if g.config.Distribution == Zipf {
+			weight = 1 / math.Pow(float64(rank+1), g.config.ZipfExponent)
+		}
+		total += weight
+		g.keyWeights[rank] = total
+	}
+	for rank := range g.keyWeights {
+		g.keyWeights[rank] /= total
+	}
+}
+
+/* Returns a key drawn from the key distribution */
+func (g *generator) nextKey() int {
+	rank := sort.SearchFloat64s(g.keyWeights, g.random.Float64())
+	if rank >= len(g.keyRanks) {
+		rank = len(g.keyRanks) - 1
+	}
+	return g.keyRanks[rank] + 1
+}
+
+/* Emits the command for the next tick: a site failure or recovery, a begin, or an operation or end of a running transaction */
+func (g *generator) step() {
+	if g.config.Sites > 0 {
+		if g.random.Float64() < g.config.FailProbability {
+			if site, ok := g.pickSite(false); ok {
+				g.downSites[site] = true
+				g.emit(Step{Kind: FailStep, Site: site})
+				return
+			}
+		}
+		if g.random.Float64() < g.config.RecoverProbability {
+			if site, ok := g.pickSite(true); ok {
+				delete(g.downSites, site)
+				g.emit(Step{Kind: RecoverStep, Site: site})
+				return
+			}
+		}
+	}
+	canBegin := g.started < g.config.Transactions && len(g.active) < g.config.Concurrency
+	if canBegin && (len(g.active) == 0 || g.random.Intn(2) == 0) {
+		g.begin()
+		return
+	}
+	index := g.random.Intn(len(g.active))
+	transaction := g.active[index]
+	if transaction.operations == 0 {
+		g.emit(Step{Kind: EndStep, Tx: transaction.id})
+		g.active = append(g.active[:index], g.active[index+1:]...)
+		return
+	}
+	transaction.operations--
+	key := g.nextKey()
+	if transaction.readOnly || g.random.Float64() < g.config.ReadRatio {
+		g.emit(Step{Kind: ReadStep, Tx: transaction.id, Key: key})
+		return
+	}
+	transaction.writes++
+	g.emit(Step{Kind: WriteStep, Tx: transaction.id, Key: key, Value: transaction.id*1000 + transaction.writes})
+}
+
+func (g *generator) begin() {
+	g.started++
+	transaction := &generatedTransaction{
+		id:         g.started,
+		readOnly:   g.random.Float64() < g.config.ReadOnlyRatio,
+		operations: 1 + g.random.Intn(g.config.MaxOperations),
+	}
+	g.active = append(g.active, transaction)
+	if transaction.readOnly {
+		g.emit(Step{Kind: BeginROStep, Tx: transaction.id})
+	} else {
+		g.emit(Step{Kind: BeginStep, Tx: transaction.id})
+	}
+}
+
+/* Picks a random site which is down if down is true, or up otherwise */
+func (g *generator) pickSite(down bool) (int, bool) {
+	candidates := make([]int, 0)
+	for site := 1; site <= g.config.Sites; site++ {
+		if g.downSites[site] == down {
+			candidates = append(candidates, site)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1, false
+	}
+	return candidates[g.random.Intn(len(candidates))], true
+}
+
+/* Recovers every failed site, in order */
+func (g *generator) recoverAll() {
+	for site := 1; site <= g.config.Sites; site++ {
+		if g.downSites[site] {
+			delete(g.downSites, site)
+			g.emit(Step{Kind: RecoverStep, Site: site})
+		}
+	}
+}
+
+func (g *generator) emit(step Step) {
+	g.steps = append(g.steps, step)
+}
@@ -0,0 +1,359 @@
+/**************************
+File: repcrec.go
+Author: Mingyi Lim
+Description: This file contains the client library, which embeds the database in a Go program.
+A DB holds the sites and the transaction manager along with a clock. Every call on a DB or a Tx runs at the next time of the clock, so callers never pass times.
+The clock counts ticks unless another is given to CreateDBWithClock.
+Results which are not successes are returned as errors: ErrAborted when the transaction was aborted, and ErrWouldWait when the operation has to wait for a site to recover.
+GetContext and PutContext block instead of returning ErrWouldWait, parking the calling goroutine until a site recovers or the context is done.
+
+	db := repcrec.CreateDB()
+	defer db.Close()
+	tx, _ := db.Begin()
+	tx.Put(2, 101)
+	value, err := tx.Get(4)
+	err = tx.Commit()
+***************************/
+
+package repcrec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/mingyi850/repcrec/internal/clock"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/utils"
+)
+
+/*
+***********
+Consts and Enums
+***********
+*/
+const numSites = 10
+
+var (
+	// The transaction was aborted. The error wraps ErrAborted and holds the reason
+	ErrAborted = errors.New("transaction aborted")
+	// The operation is queued until a site holding the key recovers. The transaction waits meanwhile, and every operation on it returns ErrWouldWait.
+	// Once the site recovers the operation has run, and retrying it returns its result
+	ErrWouldWait = errors.New("operation would wait for a site to recover")
+	// The transaction has already been committed or rolled back
+	ErrTxDone = errors.New("transaction has already been committed or rolled back")
+)
+
+/*
+***********
+Custom Structs
+***********
+*/
+
+/* Gives the time of each call. Times only have to increase from one call to the next */
+type Clock = clock.Clock
+
+/* An embedded database of 20 keys replicated over 10 sites. A DB is safe for concurrent use, and runs one call at a time */
+type DB struct {
+	mutex              sync.Mutex
+	siteCoordinator    *domain.SiteCoordinatorImpl
+	transactionManager domain.TransactionManager
+	clock              Clock
+	nextTx             int
+	recovered          chan struct{} // Closed and replaced whenever a site recovers, waking blocked calls
+}
+
+/* A transaction begun on a DB. A Tx should be used by one goroutine at a time */
+type Tx struct {
+	db   *DB
+	id   int
+	done bool
+}
+
+/* Creates a database with every key holding its initial value, 10 times the key */
+func CreateDB() *DB {
+	return CreateDBWithClock(CreateLogicalClock())
+}
+
+/* Creates a database whose calls run at the times given by a clock */
+func CreateDBWithClock(dbClock Clock) *DB {
+	siteCoordinator := domain.CreateSiteCoordinator(numSites)
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	transactionManager.SetLogger(utils.CreateLogger(io.Discard)) // The manager logs results as it would for a script, which callers get as return values instead
+	return &DB{
+		siteCoordinator:    siteCoordinator,
+		transactionManager: transactionManager,
+		clock:              dbClock,
+		nextTx:             1,
+		recovered:          make(chan struct{}),
+	}
+}
+
+/* Stops the sites of the database. The DB must not be used afterwards */
+func (db *DB) Close() error {
+	return db.siteCoordinator.Close()
+}
+
+/* Creates a clock counting ticks from 1, as scripts do */
+func CreateLogicalClock() Clock {
+	return clock.CreateLogicalClock()
+}
+
+/* Creates a clock reading monotonic nanoseconds since the Unix epoch */
+func CreateWallClock() Clock {
+	return clock.CreateWallClock(clock.SystemSource())
+}
+
+/* Creates a hybrid logical clock, which follows physical time in milliseconds and counts calls within a millisecond */
+func CreateHybridClock() Clock {
+	return clock.CreateHybridClock(clock.SystemSource())
+}
+
+/* Begins a transaction, which reads from a snapshot taken at the current tick */
+func (db *DB) Begin() (*Tx, error) {
+	return db.begin(db.transactionManager.Begin)
+}
+
+/*
+Begins a read-only transaction. Read-only transactions never write, so they never lose a write conflict,
+but a commit which would close a RW cycle aborts them like any other transaction
+*/
+func (db *DB) BeginReadOnly() (*Tx, error) {
+	return db.begin(db.transactionManager.BeginRO)
+}
+
+/* Fails a site. Transactions which wrote to it are aborted when they commit */
+func (db *DB) Fail(site int) error {
+	if site < 1 || site > numSites {
+		return fmt.Errorf("site %d does not exist", site)
+	}
+	return db.tick(func(time int) error {
+		return db.siteCoordinator.Fail(site, time)
+	})
+}
+
+/* Recovers a site. Operations which were waiting for it run now */
+func (db *DB) Recover(site int) error {
+	if site < 1 || site > numSites {
+		return fmt.Errorf("site %d does not exist", site)
+	}
+	return db.tick(func(time int) error {
+		if err := db.siteCoordinator.Recover(site, time); err != nil {
+			return err
+		}
+		close(db.recovered)
+		db.recovered = make(chan struct{})
+		return db.transactionManager.Recover(site, time)
+	})
+}
+
+/* Returns the id of the transaction */
+func (tx *Tx) ID() int {
+	return tx.id
+}
+
+/* Returns the value of a key in the snapshot of the transaction. Writes made by the transaction itself are not seen until it commits */
+func (tx *Tx) Get(key int) (int, error) {
+	value := 0
+	err := tx.operation(key, func(time int) error {
+		result, err := tx.db.transactionManager.Read(tx.id, key, time)
+		if err != nil {
+			return err
+		}
+		value = result.Value
+		return tx.resultError(result.ResultType, result.GetReason())
+	})
+	return value, err
+}
+
+/*
+Returns the value of a key like Get, but blocks while the read waits for a site to recover, and returns the value it reads once the site recovers.
+If the context is done first, the transaction is rolled back, as its queued operations cannot be withdrawn one by one, and the error of the context is returned
+*/
+func (tx *Tx) GetContext(ctx context.Context, key int) (int, error) {
+	value := 0
+	err := tx.blocking(ctx, func() error {
+		var err error
+		value, err = tx.Get(key)
+		return err
+	}, func(transaction *domain.Transaction) {
+		value, _ = transaction.GetLastRead(key)
+	})
+	return value, err
+}
+
+/* Writes a value to a key like Put, but blocks while the write waits for a site to recover. Cancellation is handled as in GetContext */
+func (tx *Tx) PutContext(ctx context.Context, key int, value int) error {
+	return tx.blocking(ctx, func() error {
+		return tx.Put(key, value)
+	}, func(*domain.Transaction) {})
+}
+
+/* Writes a value to a key at every site holding it which is up. The write is visible to other transactions once the transaction commits */
+func (tx *Tx) Put(key int, value int) error {
+	return tx.operation(key, func(time int) error {
+		result, err := tx.db.transactionManager.Write(tx.id, key, value, time)
+		if err != nil {
+			return err
+		}
+		return tx.resultError(result.ResultType, "")
+	})
+}
+
+/* Commits the transaction. Returns ErrAborted if it could not commit. The transaction is done afterwards, unless it was waiting */
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.db.tick(func(time int) error {
+		if tx.waiting() {
+			return ErrWouldWait
+		}
+		result, err := tx.db.transactionManager.End(tx.id, time)
+		if err != nil {
+			return err
+		}
+		tx.done = true
+		return tx.resultError(result.ResultType, result.GetReason())
+	})
+}
+
+/* Aborts the transaction, dropping its writes and any operation waiting to run. Rolling back a transaction which was already aborted does nothing */
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.db.tick(func(time int) error {
+		tx.done = true
+		transaction, _, err := tx.db.transactionManager.GetTransaction(tx.id)
+		if err != nil {
+			return err
+		}
+		if transaction.GetState() == domain.TxAborted {
+			return nil
+		}
+		return tx.db.transactionManager.Abort(tx.id, time)
+	})
+}
+
+/*
+*************************
+Private Methods
+***************************
+*/
+
+/* Begins a transaction with the next unused id */
+func (db *DB) begin(begin func(tx int, time int) error) (*Tx, error) {
+	var tx *Tx
+	err := db.tick(func(time int) error {
+		if err := begin(db.nextTx, time); err != nil {
+			return err
+		}
+		tx = &Tx{db: db, id: db.nextTx}
+		db.nextTx++
+		return nil
+	})
+	return tx, err
+}
+
+/* Runs a call at the next time of the clock, holding the mutex. The tick is taken even if the call fails, as the components may have acted on it */
+func (db *DB) tick(call func(time int) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	defer db.clock.Advance()
+	return call(db.clock.Now())
+}
+
+/* Runs a read or write on a key. An operation on a waiting transaction is not passed on, as it would queue another copy of the operation */
+func (tx *Tx) operation(key int, call func(time int) error) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if key < 1 || key > domain.NumKeys {
+		return fmt.Errorf("key x%d does not exist", key)
+	}
+	return tx.db.tick(func(time int) error {
+		if tx.waiting() {
+			return ErrWouldWait
+		}
+		return call(time)
+	})
+}
+
+/*
+Runs an operation, blocking while the transaction waits. If the transaction was already waiting, the operation runs once it stops waiting.
+If the operation itself was queued, it runs when the site recovers, and its outcome is collected from the transaction
+*/
+func (tx *Tx) blocking(ctx context.Context, run func() error, collect func(transaction *domain.Transaction)) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	queued := false
+	for {
+		tx.db.mutex.Lock()
+		transaction, waiting, err := tx.db.transactionManager.GetTransaction(tx.id)
+		recovered := tx.db.recovered
+		if err == nil && !waiting && transaction.GetState() == domain.TxAborted {
+			err = tx.abortError(transaction)
+		} else if err == nil && !waiting && queued {
+			collect(transaction)
+			tx.db.mutex.Unlock()
+			return nil
+		}
+		tx.db.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !waiting {
+			if err := run(); !errors.Is(err, ErrWouldWait) {
+				return err
+			}
+			queued = true
+		}
+		select {
+		case <-ctx.Done():
+		case <-recovered:
+		}
+	}
+}
+
+/* Returns ErrAborted with the reason the transaction was aborted. Must be called with the mutex held */
+func (tx *Tx) abortError(transaction *domain.Transaction) error {
+	decisions := transaction.GetDecisions()
+	for i := len(decisions) - 1; i >= 0; i-- {
+		if decisions[i].GetType() == domain.DecisionAbort {
+			return fmt.Errorf("%w: %s", ErrAborted, decisions[i].GetDetail())
+		}
+	}
+	return ErrAborted
+}
+
+/* Returns true if the transaction is waiting for a site to recover. Must be called with the mutex held */
+func (tx *Tx) waiting() bool {
+	_, waiting, _ := tx.db.transactionManager.GetTransaction(tx.id)
+	return waiting
+}
+
+/* Returns the error for an operation result, or nil if the operation succeeded. Must be called with the mutex held */
+func (tx *Tx) resultError(resultType domain.OperationResultType, reason string) error {
+	switch resultType {
+	case domain.Success:
+		return nil
+	case domain.Wait, domain.Waiting:
+		return ErrWouldWait
+	}
+	if resultType == domain.Abort && reason != "" {
+		return fmt.Errorf("%w: %s", ErrAborted, reason)
+	}
+	transaction, _, err := tx.db.transactionManager.GetTransaction(tx.id)
+	if err != nil {
+		return ErrAborted
+	}
+	return tx.abortError(transaction) // The transaction was aborted by an earlier operation
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+/* Returns a source which reads the given times in order, then keeps reading the last one */
+func readings(times ...int64) clock.Source {
+	index := 0
+	return func() int64 {
+		reading := times[min(index, len(times)-1)]
+		index++
+		return reading
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Run("Logical clock should count events from 1", func(t *testing.T) {
+		logical := clock.CreateLogicalClock()
+		assert.Equal(t, 1, logical.Now())
+		assert.Equal(t, 1, logical.Now())
+		logical.Advance()
+		assert.Equal(t, 2, logical.Now())
+	})
+
+	t.Run("Wall clock should increase even when the source stands still", func(t *testing.T) {
+		wall := clock.CreateWallClock(readings(1000, 5000, 5000))
+		assert.Equal(t, 1000, wall.Now())
+		wall.Advance()
+		assert.Equal(t, 5000, wall.Now())
+		wall.Advance()
+		assert.Equal(t, 5001, wall.Now())
+	})
+
+	t.Run("Hybrid clock should count events within a millisecond", func(t *testing.T) {
+		hybrid := clock.CreateHybridClock(readings(3_000_000, 3_500_000, 4_000_000))
+		physical, logical := clock.SplitHybrid(hybrid.Now())
+		assert.Equal(t, []int{3, 0}, []int{physical, logical})
+		hybrid.Advance()
+		physical, logical = clock.SplitHybrid(hybrid.Now())
+		assert.Equal(t, []int{3, 1}, []int{physical, logical})
+		before := hybrid.Now()
+		hybrid.Advance()
+		physical, logical = clock.SplitHybrid(hybrid.Now())
+		assert.Equal(t, []int{4, 0}, []int{physical, logical})
+		assert.Greater(t, hybrid.Now(), before)
+	})
+
+	t.Run("Hybrid clock should not go backwards once its counter has carried into the next millisecond", func(t *testing.T) {
+		now := int64(3_000_000)
+		hybrid := clock.CreateHybridClock(func() int64 { return now })
+		for event := 0; event < 1<<16+5; event++ {
+			hybrid.Advance()
+		}
+		now = 4_000_000
+		physical, logical := clock.SplitHybrid(hybrid.Now())
+		assert.Equal(t, []int{4, 5}, []int{physical, logical})
+		before := hybrid.Now()
+		hybrid.Advance() // Physical time moves on to 4ms, which the counter has already passed
+		physical, logical = clock.SplitHybrid(hybrid.Now())
+		assert.Equal(t, []int{4, 6}, []int{physical, logical})
+		assert.Greater(t, hybrid.Now(), before)
+	})
+
+	t.Run("Create should reject unknown clocks", func(t *testing.T) {
+		for _, kind := range []clock.Kind{clock.Logical, clock.Wall, clock.Hybrid} {
+			created, err := clock.Create(kind)
+			assert.NoError(t, err)
+			before := created.Now()
+			created.Advance()
+			assert.Greater(t, created.Now(), before)
+		}
+		_, err := clock.Create("sundial")
+		assert.EqualError(t, err, `unknown clock "sundial", expected logical, wall or hybrid`)
+	})
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func createDataManagers() map[int]domain.DataManager {
+	sites := make(map[int]domain.DataManager)
+	for site := 1; site <= 10; site++ {
+		dataManager := domain.CreateDataManager(site)
+		sites[site] = &dataManager
+	}
+	return sites
+}
+
+/* Commits a version of a key at every site holding it except the skipped ones */
+func commitExcept(sites map[int]domain.DataManager, key int, value int, time int, skipped ...int) {
+	for site, dataManager := range sites {
+		if dataManager.HasKey(key) && !containsSite(skipped, site) {
+			dataManager.Commit(key, value, time)
+		}
+	}
+}
+
+func containsSite(sites []int, site int) bool {
+	for _, s := range sites {
+		if s == site {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAntiEntropy(t *testing.T) {
+	t.Run("Merkle trees should differ only at the keys whose histories differ", func(t *testing.T) {
+		first := domain.CreateDataManager(2)
+		second := domain.CreateDataManager(2)
+		keys := []int{2, 4, 6, 8, 10}
+		assert.Equal(t, first.MerkleTree(keys).Root(), second.MerkleTree(keys).Root())
+		second.Commit(4, 101, 3)
+		second.Commit(10, 102, 4)
+		assert.NotEqual(t, first.MerkleTree(keys).Root(), second.MerkleTree(keys).Root())
+		assert.Equal(t, []int{4, 10}, first.MerkleTree(keys).Diff(second.MerkleTree(keys)))
+	})
+
+	t.Run("A replica missing commits should be reported, and given them when repairing", func(t *testing.T) {
+		sites := createDataManagers()
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(sites)
+		commitExcept(sites, 2, 101, 5, 3)
+		divergences := siteCoordinator.AntiEntropy(false)
+		assert.Len(t, divergences, 1)
+		assert.Equal(t, "x2 at site 3: missing 101 at 5", divergences[0].String())
+		assert.Equal(t, 20, sites[3].GetLastCommitted(2).GetValue())
+		divergences = siteCoordinator.AntiEntropy(true)
+		assert.Len(t, divergences, 1)
+		assert.True(t, divergences[0].Repaired)
+		assert.Equal(t, sites[1].History(2), sites[3].History(2))
+		assert.Empty(t, siteCoordinator.AntiEntropy(true))
+	})
+
+	t.Run("A replica holding another value for a version should be settled by the majority", func(t *testing.T) {
+		sites := createDataManagers()
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(sites)
+		commitExcept(sites, 4, 101, 5, 6)
+		sites[6].Commit(4, 999, 5)
+		divergences := siteCoordinator.AntiEntropy(true)
+		assert.Len(t, divergences, 1)
+		assert.Equal(t, "x4 at site 6: conflicting 999 at 5; repaired", divergences[0].String())
+		assert.Equal(t, domain.CreateHistoricalValue(101, 5), sites[6].GetLastCommitted(4))
+	})
+
+	t.Run("Versions committed at the same time should be matched in order", func(t *testing.T) {
+		sites := createDataManagers()
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(sites)
+		commitExcept(sites, 6, 101, 5)
+		commitExcept(sites, 6, 102, 5, 7)
+		divergences := siteCoordinator.AntiEntropy(true)
+		assert.Len(t, divergences, 1)
+		assert.Equal(t, "x6 at site 7: missing 102 at 5; repaired", divergences[0].String())
+		assert.Equal(t, sites[1].History(6), sites[7].History(6))
+	})
+
+	t.Run("Sites which are down should not be compared or repaired", func(t *testing.T) {
+		sites := createDataManagers()
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(sites)
+		assert.NoError(t, siteCoordinator.Fail(3, 1))
+		commitExcept(sites, 2, 101, 5, 3)
+		assert.Empty(t, siteCoordinator.AntiEntropy(true))
+		assert.Equal(t, 20, sites[3].GetLastCommitted(2).GetValue())
+		assert.NoError(t, siteCoordinator.Recover(3, 6))
+		assert.Len(t, siteCoordinator.AntiEntropy(true), 1)
+		assert.Equal(t, 101, sites[3].GetLastCommitted(2).GetValue())
+	})
+
+	t.Run("Repairing a key the site does not hold should be an error", func(t *testing.T) {
+		dataManager := domain.CreateDataManager(1)
+		assert.Error(t, dataManager.Repair(1, []domain.HistoricalValue{domain.CreateHistoricalValue(10, -1)}))
+	})
+}
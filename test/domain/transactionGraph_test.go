@@ -194,4 +194,50 @@ func TestTransactionGraph(t *testing.T) {
 		graph.PurgeGraph(4)
 		assert.Equal(t, 1, len(graph.GetNodes()))
 	})
+
+	t.Run("FindRWCycle should return the offending cycle", func(t *testing.T) {
+		graph := domain.CreateTransactionGraph()
+		graph.AddNode(1, 1)
+		graph.AddNode(2, 2)
+		graph.AddNode(3, 3)
+		graph.AddEdge(1, 2, domain.RW)
+		graph.AddEdge(2, 3, domain.WW)
+		graph.AddEdge(3, 1, domain.RW)
+
+		cycle := graph.FindRWCycle(1)
+		assert.Equal(t, 3, len(cycle))
+		assert.Equal(t, 1, cycle[0].From())
+		assert.Equal(t, 2, cycle[0].To())
+		assert.Equal(t, domain.RW, cycle[0].Type())
+		assert.Equal(t, 1, cycle[2].To())
+	})
+
+	t.Run("ExportDot should include commit times and typed edges", func(t *testing.T) {
+		graph := domain.CreateTransactionGraph()
+		graph.AddNode(1, 4)
+		graph.AddNode(2, 7)
+		graph.AddEdge(1, 2, domain.WR)
+		graph.AddEdge(2, 1, domain.RW)
+
+		dot := graph.ExportDot()
+		assert.Contains(t, dot, "digraph TransactionGraph {")
+		assert.Contains(t, dot, `T1 [label="T1\ncommit=4"];`)
+		assert.Contains(t, dot, `T2 [label="T2\ncommit=7"];`)
+		assert.Contains(t, dot, `T1 -> T2 [label="WR"];`)
+		assert.Contains(t, dot, `T2 -> T1 [label="RW", color=red];`)
+	})
+
+	t.Run("TryCommitTransaction should return the cycle which caused the abort", func(t *testing.T) {
+		graph := domain.CreateTransactionGraph()
+		graph.AddNode(1, 1)
+		graph.AddNode(2, 2)
+		graph.AddEdge(1, 2, domain.RW)
+
+		success, cycle := graph.TryCommitTransaction(3, map[int]domain.ConflictType{2: domain.RW}, map[int]domain.ConflictType{1: domain.WW}, 10)
+		assert.Equal(t, false, success)
+		assert.Equal(t, 3, len(cycle))
+		dot := graph.ExportCycleDot(cycle)
+		assert.Contains(t, dot, `T3 [label="T3\nuncommitted", style=dashed];`)
+		assert.Contains(t, dot, `T3 -> T1 [label="WW"];`)
+	})
 }
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+/* Keeps the records of a transaction log in memory */
+type memoryLog struct {
+	records []domain.LogRecord
+}
+
+func (m *memoryLog) Append(record domain.LogRecord) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+func TestTransactionLog(t *testing.T) {
+	t.Run("A restored manager should keep committed transactions and the graph, and abort transactions in flight", func(t *testing.T) {
+		siteCoordinator := domain.CreateSiteCoordinator(10)
+		defer siteCoordinator.Close()
+		log := &memoryLog{}
+		transactionManager := domain.CreateTransactionManagerWithLog(siteCoordinator, log)
+		transactionManager.Begin(1, 1)
+		transactionManager.Begin(2, 2)
+		transactionManager.BeginRO(3, 3)
+		transactionManager.Read(1, 2, 4)
+		transactionManager.Write(2, 2, 202, 5)
+		transactionManager.Read(3, 4, 6)
+		transactionManager.End(2, 7)
+		transactionManager.Write(1, 4, 101, 8)
+		transactionManager.End(1, 9) // T1 read x2 before T2 committed it, an RW edge from T1 to T2
+		transactionManager.Begin(4, 10)
+		transactionManager.Write(4, 6, 404, 11)
+
+		restored, err := domain.RestoreTransactionManager(siteCoordinator, log.records, log)
+		assert.NoError(t, err)
+		assert.Equal(t, transactionManager.History(), restored.History())
+		assert.Equal(t, transactionManager.GetTransactionGraph().GetGraph(), restored.GetTransactionGraph().GetGraph())
+		assert.Equal(t, transactionManager.DecisionLog, restored.DecisionLog)
+		for tx, state := range map[int]domain.TransactionState{1: domain.TxCommitted, 2: domain.TxCommitted, 3: domain.TxAborted, 4: domain.TxAborted} {
+			transaction, _, err := restored.GetTransaction(tx)
+			assert.NoError(t, err)
+			assert.Equal(t, state, transaction.GetState())
+		}
+		explanation, _ := restored.Explain(4)
+		assert.Contains(t, explanation, "transaction manager restarted")
+		assert.Error(t, restored.Begin(1, 12))
+		assert.Equal(t, domain.RW, restored.GetTransactionGraph().GetEdges(1)[2])
+		last := log.records[len(log.records)-1]
+		assert.Equal(t, domain.LogRecord{Type: domain.LogAbort, Tx: 4, Time: 10, Reason: "transaction manager restarted"}, last) // Writes are not logged, so the log ends at the begin of T4
+
+		count := len(log.records)
+		_, err = domain.RestoreTransactionManager(siteCoordinator, log.records, log)
+		assert.NoError(t, err)
+		assert.Len(t, log.records, count) // A second restart finds nothing in flight
+	})
+
+	t.Run("A restored manager should abort transactions still prepared at sites", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		flaky.Prepare(5, []domain.PreparedWrite{{Key: 2, Value: 505}})
+		records := []domain.LogRecord{{Type: domain.LogBegin, Tx: 5, Time: 1}}
+		_, err := domain.RestoreTransactionManager(siteCoordinator, records, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, flaky.InDoubt())
+		assert.Equal(t, 20, flaky.GetLastCommitted(2).GetValue())
+	})
+
+	t.Run("A restored manager should return an error telling a site an outcome, unless the site is down", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(11), unreachable: true}
+		sites := createSites(&unreachableSite{DataManagerImpl: domain.CreateDataManager(2)})
+		sites[11] = flaky // Every site is resolved, not only sites 1 to 10
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(sites)
+		flaky.Prepare(5, []domain.PreparedWrite{{Key: 2, Value: 505}})
+		records := []domain.LogRecord{{Type: domain.LogBegin, Tx: 5, Time: 1}}
+		_, err := domain.RestoreTransactionManager(siteCoordinator, records, nil)
+		assert.EqualError(t, err, "unreachable")
+		flaky.down = true
+		_, err = domain.RestoreTransactionManager(siteCoordinator, records, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{5}, flaky.InDoubt()) // Resolved when the site recovers
+	})
+
+	t.Run("An abort should be logged before any prepared site hears it", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		log := &memoryLog{}
+		transactionManager := domain.CreateTransactionManagerWithLog(siteCoordinator, log)
+		transactionManager.Begin(1, 1)
+		transactionManager.Begin(2, 2)
+		transactionManager.Write(1, 3, 101, 3)
+		transactionManager.Write(2, 2, 202, 4)
+		transactionManager.Write(2, 3, 303, 5)
+		transactionManager.End(1, 6)
+		logged := make([]domain.LogRecordType, 0)
+		flaky.onDecide = func(tx int, commit bool) {
+			logged = append(logged, log.records[len(log.records)-1].Type)
+		}
+		result, _ := transactionManager.End(2, 7) // Site 2 prepares x2 before site 4 finds x3 stale
+		assert.Equal(t, domain.Abort, result.ResultType)
+		assert.Equal(t, []domain.LogRecordType{domain.LogAbort}, logged)
+	})
+
+	t.Run("A decision should be kept until every prepared site has heard it, after a restart too", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		log := &memoryLog{}
+		transactionManager := domain.CreateTransactionManagerWithLog(siteCoordinator, log)
+		transactionManager.Begin(1, 1)
+		transactionManager.Write(1, 2, 101, 2)
+		flaky.down = true
+		transactionManager.End(1, 3)
+		restored, err := domain.RestoreTransactionManager(siteCoordinator, log.records, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[int]domain.CommitDecision{1: {Commit: true, Time: 3}}, restored.DecisionLog)
+
+		flaky.down = false
+		siteCoordinator.Recover(2, 4)
+		assert.NoError(t, transactionManager.Recover(2, 4))
+		assert.Empty(t, transactionManager.DecisionLog)
+		assert.Equal(t, domain.LogRecord{Type: domain.LogForget, Tx: 1, Time: 4}, log.records[len(log.records)-1])
+		restored, err = domain.RestoreTransactionManager(siteCoordinator, log.records, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, restored.DecisionLog)
+	})
+
+	t.Run("Opening a log should drop a record cut short by a crash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tm.log")
+		log, records, err := domain.OpenTransactionLog(path)
+		assert.NoError(t, err)
+		assert.Empty(t, records)
+		assert.NoError(t, log.Append(domain.LogRecord{Type: domain.LogBegin, Tx: 1, Time: 1}))
+		log.Close()
+		file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(`{"type":"begin","tx":2,`)
+		file.Close()
+
+		log, records, err = domain.OpenTransactionLog(path)
+		assert.NoError(t, err)
+		assert.Equal(t, []domain.LogRecord{{Type: domain.LogBegin, Tx: 1, Time: 1}}, records)
+		assert.NoError(t, log.Append(domain.LogRecord{Type: domain.LogAbort, Tx: 1, Time: 2}))
+		log.Close()
+		_, records, err = domain.OpenTransactionLog(path)
+		assert.NoError(t, err)
+		assert.Len(t, records, 2)
+	})
+
+	t.Run("Replaying a log which ends a transaction that never began should be an error", func(t *testing.T) {
+		siteCoordinator := domain.CreateSiteCoordinator(10)
+		defer siteCoordinator.Close()
+		_, err := domain.RestoreTransactionManager(siteCoordinator, []domain.LogRecord{{Type: domain.LogCommit, Tx: 1, Time: 2}}, nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+/* A site which crashes before hearing decisions while unreachable is set. onDecide, if set, is called with every decision the site is sent */
+type unreachableSite struct {
+	domain.DataManagerImpl
+	unreachable bool
+	down        bool // Fails as a site in another process which is down, instead of one which cannot be reached
+	onDecide    func(tx int, commit bool)
+}
+
+func (u *unreachableSite) Decide(tx int, commit bool, time int) error {
+	if u.onDecide != nil {
+		u.onDecide(tx, commit)
+	}
+	if u.down {
+		return &domain.SiteDownError{Site: 2, Err: errors.New("connection refused")}
+	}
+	if u.unreachable {
+		return errors.New("unreachable")
+	}
+	return u.DataManagerImpl.Decide(tx, commit, time)
+}
+
+func createSites(flaky *unreachableSite) map[int]domain.DataManager {
+	sites := make(map[int]domain.DataManager)
+	for site := 1; site <= 10; site++ {
+		dataManager := domain.CreateDataManager(site)
+		sites[site] = &dataManager
+	}
+	sites[2] = flaky
+	return sites
+}
+
+func TestTwoPhaseCommit(t *testing.T) {
+	t.Run("A site should hold prepared writes until it hears the decision", func(t *testing.T) {
+		dataManager := domain.CreateDataManager(2)
+		assert.NoError(t, dataManager.Prepare(1, []domain.PreparedWrite{{Key: 2, Value: 101}}))
+		assert.NoError(t, dataManager.Prepare(2, []domain.PreparedWrite{{Key: 4, Value: 202}}))
+		assert.Equal(t, []int{1, 2}, dataManager.InDoubt())
+		assert.Equal(t, 20, dataManager.GetLastCommitted(2).GetValue())
+		assert.NoError(t, dataManager.Decide(1, true, 5))
+		assert.NoError(t, dataManager.Decide(2, false, 5))
+		assert.NoError(t, dataManager.Decide(1, true, 6)) // Already decided
+		assert.Empty(t, dataManager.InDoubt())
+		assert.Equal(t, domain.CreateHistoricalValue(101, 5), dataManager.GetLastCommitted(2))
+		assert.Equal(t, 40, dataManager.GetLastCommitted(4).GetValue())
+	})
+
+	t.Run("A site which misses a commit decision should be failed and commit on recovery", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		transactionManager := domain.CreateTransactionManager(siteCoordinator)
+		transactionManager.Begin(1, 1)
+		transactionManager.Write(1, 2, 101, 2)
+		flaky.unreachable = true
+		result, err := transactionManager.End(1, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Success, result.ResultType)
+		assert.Equal(t, domain.CommitDecision{Commit: true, Time: 3}, transactionManager.DecisionLog[1])
+		assert.Equal(t, []int{1}, siteCoordinator.InDoubt(2))
+		assert.NotContains(t, siteCoordinator.GetActiveSitesForKey(2), 2)
+		assert.Equal(t, 20, flaky.GetLastCommitted(2).GetValue())
+		flaky.unreachable = false
+		siteCoordinator.Recover(2, 4)
+		assert.NoError(t, transactionManager.Recover(2, 4))
+		assert.Empty(t, siteCoordinator.InDoubt(2))
+		assert.Empty(t, transactionManager.DecisionLog) // Every site has heard the decision
+		assert.Equal(t, domain.CreateHistoricalValue(101, 3), flaky.GetLastCommitted(2))
+	})
+
+	t.Run("A transaction aborted after preparing should discard its writes at every site", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		transactionManager := domain.CreateTransactionManager(siteCoordinator)
+		transactionManager.Begin(1, 1)
+		transactionManager.Begin(2, 2)
+		transactionManager.Write(1, 3, 101, 3)
+		transactionManager.Write(2, 2, 202, 4)
+		transactionManager.Write(2, 3, 303, 5)
+		transactionManager.End(1, 6)
+		result, _ := transactionManager.End(2, 7) // Sites 1 to 3 prepare x2 before site 4 finds x3 stale
+		assert.Equal(t, domain.Abort, result.ResultType)
+		assert.NotContains(t, transactionManager.DecisionLog, 2) // Every prepared site heard the abort
+		for site := 1; site <= 10; site++ {
+			assert.Empty(t, siteCoordinator.InDoubt(site))
+		}
+		assert.Equal(t, 20, flaky.GetLastCommitted(2).GetValue())
+	})
+
+	t.Run("An in-doubt transaction without a logged decision should be aborted on recovery", func(t *testing.T) {
+		flaky := &unreachableSite{DataManagerImpl: domain.CreateDataManager(2)}
+		siteCoordinator := domain.CreateSiteCoordinatorWithSites(createSites(flaky))
+		transactionManager := domain.CreateTransactionManager(siteCoordinator)
+		flaky.Prepare(7, []domain.PreparedWrite{{Key: 2, Value: 707}})
+		siteCoordinator.Fail(2, 1)
+		siteCoordinator.Recover(2, 2)
+		assert.NoError(t, transactionManager.Recover(2, 2))
+		assert.Empty(t, siteCoordinator.InDoubt(2))
+		assert.Equal(t, 20, flaky.GetLastCommitted(2).GetValue())
+	})
+}
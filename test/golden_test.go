@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+)
+
+/* Regenerates the golden files instead of comparing against them: go test ./test -run TestGolden -update */
+var update = flag.Bool("update", false, "regenerate the .expected golden files in test/resources")
+
+/* Number of unchanged lines shown around each difference */
+const diffContext = 2
+
+/*
+Runs every scenario in test/resources and compares the full output with the golden file next to it,
+e.g. test5.txt is compared with test5.expected
+*/
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("resources/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		t.Run(name, func(t *testing.T) {
+			actual, err := runTranscript(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			goldenFile := strings.TrimSuffix(file, ".txt") + ".expected"
+			if *update {
+				if err := os.WriteFile(goldenFile, []byte(actual), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("%v\nRun go test ./test -run TestGolden -update to create it", err)
+			}
+			if actual != string(expected) {
+				t.Errorf("output of %s differs from %s:\n%s\nRun go test ./test -run TestGolden -update if the change is intended",
+					file, goldenFile, diffLines(string(expected), actual))
+			}
+		})
+	}
+}
+
+/* Runs a scenario as the command line does, returning everything printed, followed by the final error or success line */
+func runTranscript(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var output bytes.Buffer
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	if err := internal.SimulationWithOptions(file, siteCoordinator, transactionManager, internal.Options{Output: &output}); err != nil {
+		fmt.Fprintln(&output, err.Error())
+	} else {
+		fmt.Fprintln(&output, "Completed Successfully")
+	}
+	return output.String(), nil
+}
+
+/*
+Returns a line diff of expected and actual. Removed lines start with "-", added lines with "+",
+and each group of changes is headed by its line number in the expected output
+*/
+func diffLines(expected string, actual string) string {
+	a := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	type diffLine struct {
+		prefix string
+		text   string
+		line   int // Line number in expected
+	}
+	lines := make([]diffLine, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{" ", a[i], i + 1})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diffLine{"-", a[i], i + 1})
+			i++
+		default:
+			lines = append(lines, diffLine{"+", b[j], i + 1})
+			j++
+		}
+	}
+	var builder strings.Builder
+	last := -2 // Index of the last line written, so that a header starts each group of changes
+	for index, line := range lines {
+		nearChange := false
+		for k := max(0, index-diffContext); k <= min(len(lines)-1, index+diffContext); k++ {
+			if lines[k].prefix != " " {
+				nearChange = true
+				break
+			}
+		}
+		if !nearChange {
+			continue
+		}
+		if last != index-1 {
+			builder.WriteString(fmt.Sprintf("@@ line %d @@\n", line.line))
+		}
+		builder.WriteString(fmt.Sprintf("%s %s\n", line.prefix, line.text))
+		last = index
+	}
+	return builder.String()
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/history"
+	"github.com/mingyi850/repcrec/internal/workload"
+	"github.com/stretchr/testify/assert"
+)
+
+/* Runs a script through the simulation, discarding its output, and returns the committed history */
+func runHistory(t *testing.T, script string) history.History {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	if err := internal.SimulationWithOptions(file, siteCoordinator, transactionManager, internal.Options{Output: io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	return transactionManager.History()
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("Check should accept a serial history", func(t *testing.T) {
+		result := history.Check(history.History{Transactions: []history.Transaction{
+			{ID: 1, CommitTime: 3, Reads: []history.Read{{Key: 2, Value: 20, VersionTime: -1}}, Writes: map[int]int{2: 21}},
+			{ID: 2, CommitTime: 6, Reads: []history.Read{{Key: 2, Value: 21, VersionTime: 3}}, Writes: map[int]int{4: 41}},
+		}})
+		assert.True(t, result.Serializable())
+		assert.Contains(t, result.Edges, history.Edge{From: 0, To: 1, Type: history.WW, Key: 2})
+		assert.Contains(t, result.Edges, history.Edge{From: 1, To: 2, Type: history.WR, Key: 2})
+		assert.Contains(t, result.Edges, history.Edge{From: 0, To: 1, Type: history.WR, Key: 2})
+		assert.Equal(t, 4, len(result.Edges)) // T1 read x2 before overwriting it, which is not a dependency on another transaction
+	})
+
+	t.Run("Check should report write skew as G2", func(t *testing.T) {
+		result := history.Check(history.History{Transactions: []history.Transaction{
+			{ID: 1, CommitTime: 5, Reads: []history.Read{{Key: 2, Value: 20, VersionTime: -1}}, Writes: map[int]int{4: 41}},
+			{ID: 2, CommitTime: 6, Reads: []history.Read{{Key: 4, Value: 40, VersionTime: -1}}, Writes: map[int]int{2: 21}},
+		}})
+		assert.False(t, result.Serializable())
+		assert.Equal(t, 1, len(result.Anomalies))
+		assert.Equal(t, history.G2, result.Anomalies[0].Type)
+		assert.ElementsMatch(t, []int{1, 2}, result.Anomalies[0].Transactions())
+		assert.Contains(t, result.String(), "G2: T1 -rw(x2)-> T2 -rw(x4)-> T1")
+	})
+
+	t.Run("Check should report circular information flow as G1c", func(t *testing.T) {
+		result := history.Check(history.History{Transactions: []history.Transaction{
+			{ID: 1, CommitTime: 5, Reads: []history.Read{{Key: 4, Value: 41, VersionTime: 6}}, Writes: map[int]int{2: 21}},
+			{ID: 2, CommitTime: 6, Reads: []history.Read{{Key: 2, Value: 21, VersionTime: 5}}, Writes: map[int]int{4: 41}},
+		}})
+		types := make([]history.AnomalyType, 0)
+		for _, anomaly := range result.Anomalies {
+			types = append(types, anomaly.Type)
+		}
+		assert.Contains(t, types, history.G1c)
+	})
+
+	t.Run("Check should report a write cycle as G0", func(t *testing.T) {
+		// Versions are ordered by commit time, so a write cycle needs a transaction which appears twice, e.g. in histories merged from two runs
+		result := history.Check(history.History{Transactions: []history.Transaction{
+			{ID: 1, CommitTime: 5, Writes: map[int]int{2: 21}},
+			{ID: 2, CommitTime: 6, Writes: map[int]int{2: 22, 4: 42}},
+			{ID: 3, CommitTime: 7, Writes: map[int]int{4: 43, 6: 63}},
+			{ID: 1, CommitTime: 8, Writes: map[int]int{6: 61}},
+		}})
+		assert.Equal(t, history.G0, result.Anomalies[0].Type)
+	})
+
+	t.Run("Check should report reads which match no committed write", func(t *testing.T) {
+		result := history.Check(history.History{Transactions: []history.Transaction{
+			{ID: 1, CommitTime: 3, Writes: map[int]int{2: 21}},
+			{ID: 2, CommitTime: 6, Reads: []history.Read{{Key: 2, Value: 25, VersionTime: 3}, {Key: 4, Value: 44, VersionTime: 4}}},
+		}})
+		assert.Equal(t, 2, len(result.Anomalies))
+		assert.Equal(t, history.IncorrectRead, result.Anomalies[0].Type)
+		assert.Contains(t, result.Anomalies[0].Detail, "T1 committed x2 = 21 at 3")
+		assert.Contains(t, result.Anomalies[1].Detail, "no committed transaction wrote x4 at 4")
+	})
+
+	t.Run("History should record what each committed read observed", func(t *testing.T) {
+		recorded := runHistory(t, "begin(T1)\nW(T1, x2, 21)\nend(T1)\nbegin(T2)\nR(T2, x2)\nR(T2, x3)\nend(T2)\nbegin(T3)\nW(T3, x4, 1)\nR(T3, x4)\n")
+		assert.Equal(t, 2, len(recorded.Transactions))
+		assert.Equal(t, map[int]int{2: 21}, recorded.Transactions[0].Writes)
+		assert.Equal(t, 3, recorded.Transactions[0].CommitTime)
+		assert.Equal(t, []history.Read{{Key: 2, Value: 21, VersionTime: 3}, {Key: 3, Value: 30, VersionTime: -1}}, recorded.Transactions[1].Reads)
+		assert.True(t, history.Check(recorded).Serializable())
+	})
+
+	t.Run("History should leave out reads as of a past tick", func(t *testing.T) {
+		recorded := runHistory(t, "begin(T1)\nW(T1, x4, 111)\nend(T1)\nbeginRO(T2)\nR(T2, x4 @ 1)\nR(T2, x4)\nend(T2)\n")
+		assert.Equal(t, []history.Read{{Key: 4, Value: 111, VersionTime: 3}}, recorded.Transactions[1].Reads)
+		assert.True(t, recorded.Transactions[1].ReadOnly)
+	})
+
+	t.Run("Generated workloads should have serializable committed histories", func(t *testing.T) {
+		for seed := int64(1); seed <= 200; seed++ {
+			config := workload.DefaultConfig()
+			config.Seed = seed
+			config.Transactions = 15
+			config.Concurrency = 5
+			config.Keys = 6
+			config.Distribution = workload.Zipf
+			config.ReadOnlyRatio = 0.1
+			config.FailProbability = 0.05
+			config.RecoverProbability = 0.2
+			script, err := workload.Generate(config)
+			assert.Nil(t, err)
+			result := history.Check(runHistory(t, script))
+			assert.True(t, result.Serializable(), "seed %d: %s\n%s", seed, result, script)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func startHTTPServer(t *testing.T) string {
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	t.Cleanup(func() { siteCoordinator.Close() })
+	server := internal.CreateServer(siteCoordinator, domain.CreateTransactionManager(siteCoordinator))
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL
+}
+
+/* Sends a request and returns the status code and decoded JSON body */
+func request(t *testing.T, method string, url string, body string) (int, map[string]any) {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	decoded := make(map[string]any)
+	if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, decoded
+}
+
+func TestHTTP(t *testing.T) {
+	t.Run("Transactions should run at consecutive ticks", func(t *testing.T) {
+		url := startHTTPServer(t)
+		status, body := request(t, "POST", url+"/tx", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, map[string]any{"tick": 1.0, "tx": 1.0, "result": "success"}, body)
+		_, body = request(t, "POST", url+"/tx", `{"id": 5, "readOnly": true}`)
+		assert.Equal(t, map[string]any{"tick": 2.0, "tx": 5.0, "result": "success"}, body)
+		_, body = request(t, "POST", url+"/tx/1/write", `{"key": 2, "value": 101}`)
+		assert.Equal(t, "success", body["result"])
+		assert.Len(t, body["sites"], 10)
+		_, body = request(t, "POST", url+"/tx/T1/commit", "")
+		assert.Equal(t, map[string]any{"tick": 4.0, "tx": 1.0, "result": "success"}, body)
+		_, body = request(t, "POST", url+"/tx/5/read", `{"key": 2}`)
+		assert.Equal(t, 20.0, body["value"]) // T5 began before T1 committed
+		_, body = request(t, "POST", url+"/tx/5/read", `{"key": 2, "asOf": 4}`)
+		assert.Equal(t, 101.0, body["value"])
+	})
+
+	t.Run("Aborts should be reported with their reason", func(t *testing.T) {
+		url := startHTTPServer(t)
+		request(t, "POST", url+"/tx", "")
+		request(t, "POST", url+"/tx/1/write", `{"key": 2, "value": 101}`)
+		request(t, "POST", url+"/sites/3/fail", "")
+		_, body := request(t, "POST", url+"/tx/1/commit", "")
+		assert.Equal(t, "abort", body["result"])
+		assert.NotEmpty(t, body["reason"])
+	})
+
+	t.Run("A read which waited should be answered by the recovery", func(t *testing.T) {
+		url := startHTTPServer(t)
+		request(t, "POST", url+"/tx", "")
+		_, body := request(t, "POST", url+"/sites/4/fail", "")
+		assert.Equal(t, map[string]any{"tick": 2.0, "site": 4.0, "result": "success"}, body)
+		_, body = request(t, "POST", url+"/tx/1/read", `{"key": 3}`)
+		assert.Equal(t, "wait", body["result"])
+		assert.Nil(t, body["value"])
+		_, body = request(t, "POST", url+"/sites/4/recover", "")
+		assert.Equal(t, []any{"x3: 30"}, body["output"])
+	})
+
+	t.Run("Dump should list every site", func(t *testing.T) {
+		url := startHTTPServer(t)
+		request(t, "POST", url+"/sites/4/fail", "")
+		status, body := request(t, "GET", url+"/dump", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2.0, body["tick"])
+		sites := body["sites"].([]any)
+		assert.Len(t, sites, 10)
+		site4 := sites[3].(map[string]any)
+		assert.Equal(t, false, site4["up"])
+		assert.Equal(t, map[string]any{"x2": 20.0, "x3": 30.0, "x4": 40.0, "x6": 60.0, "x8": 80.0, "x10": 100.0, "x12": 120.0, "x13": 130.0, "x14": 140.0, "x16": 160.0, "x18": 180.0, "x20": 200.0}, site4["values"])
+		assert.Equal(t, true, sites[0].(map[string]any)["up"])
+	})
+
+	t.Run("Requests which cannot run should be rejected without taking a tick", func(t *testing.T) {
+		url := startHTTPServer(t)
+		status, body := request(t, "POST", url+"/tx/9/read", `{"key": 2}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.Equal(t, "Transaction 9 does not exist", body["error"])
+		status, _ = request(t, "POST", url+"/sites/11/fail", "")
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = request(t, "POST", url+"/sites/0/recover", "")
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = request(t, "POST", url+"/tx/x/commit", "")
+		assert.Equal(t, http.StatusBadRequest, status)
+		request(t, "POST", url+"/tx", "")
+		status, _ = request(t, "POST", url+"/tx/1/write", `{"key": 2}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = request(t, "POST", url+"/tx/1/read", `{"key": 21}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = request(t, "POST", url+"/tx", `{"id": 1}`)
+		assert.Equal(t, http.StatusConflict, status)
+		_, body = request(t, "POST", url+"/tx", "")
+		assert.Equal(t, map[string]any{"tick": 2.0, "tx": 2.0, "result": "success"}, body)
+	})
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser(t *testing.T) {
+	t.Run("Parse should produce commands with positions", func(t *testing.T) {
+		script, err := parser.Parse(strings.NewReader("begin(T1)\n\n  W(T1, x4, 111)\nR(T1, x4 @ 12)\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(script.Lines))
+		write := script.Lines[1].Commands[0]
+		assert.Equal(t, "W", write.Name)
+		assert.Equal(t, parser.Position{Line: 3, Column: 3}, write.Pos)
+		assert.Equal(t, 1, write.Tx())
+		assert.Equal(t, 4, write.Key())
+		assert.Equal(t, 111, write.Number())
+		read := script.Lines[2].Commands[0]
+		tick, exists := read.Arg(parser.TickArg)
+		assert.Equal(t, true, exists)
+		assert.Equal(t, 12, tick)
+		assert.Equal(t, "R(T1, x4 @ 12)", read.String())
+	})
+
+	t.Run("Parse should distinguish begin and beginRO", func(t *testing.T) {
+		script, err := parser.Parse(strings.NewReader("beginRO(T2)\nbegin(T3)"))
+		assert.Nil(t, err)
+		assert.Equal(t, "beginRO", script.Lines[0].Commands[0].Name)
+		assert.Equal(t, "begin", script.Lines[1].Commands[0].Name)
+	})
+
+	t.Run("Parse should skip line comments and block comments", func(t *testing.T) {
+		input := "/* header\nR(T1, x1)\n*/\nbegin(T1) // inline comment\n/* inline */ end(T1) -> T1 commits\ndump"
+		script, err := parser.Parse(strings.NewReader(input))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(script.Lines))
+		assert.Equal(t, 4, script.Lines[0].Number)
+		assert.Equal(t, "end", script.Lines[1].Commands[0].Name)
+		assert.Equal(t, "dump", script.Lines[2].Commands[0].Name)
+	})
+
+	t.Run("Parse should report every syntax error with its position", func(t *testing.T) {
+		input := "begin(T1)\nR(T1 x4)\nW(T1, x4)\nfoo(T1)\nend(T1) commits\n/* never closed"
+		_, err := parser.Parse(strings.NewReader(input))
+		errors, ok := err.(parser.ErrorList)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 5, len(errors))
+		assert.Equal(t, parser.Position{Line: 2, Column: 6}, errors[0].Pos)
+		assert.Contains(t, errors[1].Msg, "expected W(Tn, xK, n)")
+		assert.Contains(t, errors[2].Msg, `unknown command "foo"`)
+		assert.Equal(t, parser.Position{Line: 5, Column: 9}, errors[3].Pos)
+		assert.Contains(t, errors[4].Msg, "unterminated block comment")
+		assert.Contains(t, err.Error(), "5 syntax errors")
+	})
+
+	t.Run("Parse should group semicolon separated commands on one line", func(t *testing.T) {
+		script, err := parser.Parse(strings.NewReader("W(T1,x1,101); R(T2,x2)\nend(T1);\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(script.Lines))
+		assert.Equal(t, 2, len(script.Lines[0].Commands))
+		assert.Equal(t, "W", script.Lines[0].Commands[0].Name)
+		assert.Equal(t, "R", script.Lines[0].Commands[1].Name)
+		assert.Equal(t, 1, len(script.Lines[1].Commands))
+	})
+
+	t.Run("Parse should recover from an error at the next semicolon", func(t *testing.T) {
+		_, err := parser.Parse(strings.NewReader("R(T1); W(T1, x1); end(T1) begin(T2)"))
+		errors, ok := err.(parser.ErrorList)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 3, len(errors))
+		assert.Equal(t, 8, errors[1].Pos.Column)
+		assert.Contains(t, errors[2].Msg, `unexpected identifier "begin" after end(T1)`)
+	})
+
+	t.Run("Parse should expand loops with variables and expressions", func(t *testing.T) {
+		input := "repeat 2 as i {\n  begin(T$i); W(T$i, x$(i*2), $(i+100))\n}\nrepeat 2 { dump }"
+		script, err := parser.Parse(strings.NewReader(input))
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(script.Lines))
+		assert.Equal(t, "W(T2, x4, 102)", script.Lines[1].Commands[1].String())
+		assert.Equal(t, 2, script.Lines[1].Number) // Expanded lines keep the line they were written at
+		assert.Equal(t, "dump", script.Lines[3].Commands[0].Name)
+	})
+
+	t.Run("Parse should expand macros and nested loops", func(t *testing.T) {
+		input := "macro rw(tx, key) {\n  R($tx, $key)\n  W($tx, $key, 1)\n}\nrepeat 2 as i { repeat 2 as j { rw(T$i, x$j) } }"
+		script, err := parser.Parse(strings.NewReader(input))
+		assert.Nil(t, err)
+		assert.Equal(t, 8, len(script.Lines))
+		assert.Equal(t, "R(T1, x1)", script.Lines[0].Commands[0].String())
+		assert.Equal(t, "W(T1, x2, 1)", script.Lines[3].Commands[0].String())
+		assert.Equal(t, "R(T2, x1)", script.Lines[4].Commands[0].String())
+	})
+
+	t.Run("Parse should expand included files relative to the directory", func(t *testing.T) {
+		input := "include \"include/transfer.txt\"\ntransfer(T1, x2, x4, 7)"
+		script, err := parser.ParseInDir(strings.NewReader(input), "../resources")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(script.Lines))
+		assert.Equal(t, "W(T1, x4, 7)", script.Lines[2].Commands[0].String())
+		assert.Contains(t, script.Lines[2].File, "transfer.txt")
+	})
+
+	t.Run("Parse should report expansion errors", func(t *testing.T) {
+		input := "repeat 2 as i {\n  begin(T$j)\n}\nmacro m(a) { R($a, x1) }\nm(T1, T2)\nmacro begin() { }\nrepeat 3 {\ninclude \"missing.txt\""
+		_, err := parser.Parse(strings.NewReader(input))
+		errors, ok := err.(parser.ErrorList)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 5, len(errors))
+		assert.Contains(t, errors[0].Msg, "undefined variable $j")
+		assert.Contains(t, errors[2].Msg, "macro m expects 1 arguments, found 2")
+		assert.Contains(t, errors[3].Msg, "already a command")
+		assert.Contains(t, errors[4].Msg, "block is never closed")
+	})
+
+	t.Run("Parse should parse expect directives", func(t *testing.T) {
+		input := "expect R(T1,x2) = 20\nexpect T2 aborts; expect T3 waits\nexpect dump site 4 x3: 111"
+		script, err := parser.Parse(strings.NewReader(input))
+		assert.Nil(t, err)
+		read := script.Lines[0].Commands[0]
+		assert.Equal(t, parser.ExpectRead, read.Expect.Kind)
+		assert.Equal(t, 20, read.Expect.Value)
+		assert.Equal(t, "expect R(T1, x2) = 20", read.String())
+		assert.Equal(t, parser.ExpectState, script.Lines[1].Commands[1].Expect.Kind)
+		assert.Equal(t, "waits", script.Lines[1].Commands[1].Expect.State)
+		dump := script.Lines[2].Commands[0]
+		assert.Equal(t, 4, dump.Number())
+		assert.Equal(t, 3, dump.Key())
+		assert.Equal(t, 111, dump.Expect.Value)
+		assert.Equal(t, "expect dump site 4 x3: 111", dump.String())
+	})
+
+	t.Run("Parse should report malformed expect directives", func(t *testing.T) {
+		input := "expect R(T1, x2) 20\nexpect T2 finishes\nexpect dump site x3: 111\nexpect dump site 4 x3 = 111"
+		_, err := parser.Parse(strings.NewReader(input))
+		errors, ok := err.(parser.ErrorList)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 4, len(errors))
+		assert.Contains(t, errors[0].Msg, `expected "=", found number "20"`)
+		assert.Contains(t, errors[1].Msg, "expected Tn commits, Tn aborts or Tn waits")
+		assert.Contains(t, errors[2].Msg, "expected number")
+		assert.Contains(t, errors[3].Msg, `expected ":"`)
+	})
+}
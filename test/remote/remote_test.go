@@ -0,0 +1,176 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+/* The test binary runs a site instead of the tests when started by testSiteCommand */
+func TestMain(m *testing.M) {
+	if id := os.Getenv("REPCREC_TEST_SITE"); id != "" {
+		go func() {
+			io.Copy(io.Discard, os.Stdin) // Exits with the test, like repcrec site --exit-on-eof
+			os.Exit(0)
+		}()
+		siteId, _ := strconv.Atoi(id)
+		if err := remote.ServeSite(siteId, os.Getenv("REPCREC_TEST_ADDR"), os.Getenv("REPCREC_TEST_WAL")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func testSiteCommand(siteId int, port int, walPath string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("REPCREC_TEST_SITE=%d", siteId),
+		fmt.Sprintf("REPCREC_TEST_ADDR=127.0.0.1:%d", port),
+		"REPCREC_TEST_WAL="+walPath)
+	return cmd
+}
+
+func createCluster(t *testing.T) *remote.Cluster {
+	cluster, err := remote.CreateCluster(remote.ClusterConfig{Sites: 10, Dir: t.TempDir(), Command: testSiteCommand})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cluster.Close() })
+	return cluster
+}
+
+func TestCluster(t *testing.T) {
+	t.Run("A killed site should come back with the commits in its WAL", func(t *testing.T) {
+		cluster := createCluster(t)
+		assert.NoError(t, cluster.Sites[4].Commit(3, 101, 5))
+		assert.NoError(t, cluster.Fail(4, 6))
+		assert.False(t, cluster.Running(4))
+		assert.Equal(t, 101, cluster.Sites[4].GetLastCommitted(3).GetValue()) // Answered from the WAL while the site is down
+		assert.Error(t, cluster.Sites[4].Commit(3, 202, 7))
+		assert.NoError(t, cluster.Recover(4, 8))
+		assert.True(t, cluster.Running(4))
+		last := cluster.Sites[4].GetLastCommitted(3)
+		assert.Equal(t, 101, last.GetValue())
+		assert.Equal(t, 5, last.GetTime())
+	})
+
+	t.Run("A killed site should still be prepared after it restarts, and resolve from the decision log", func(t *testing.T) {
+		cluster := createCluster(t)
+		transactionManager := domain.CreateTransactionManager(cluster)
+		assert.NoError(t, cluster.Sites[2].Prepare(7, []domain.PreparedWrite{{Key: 2, Value: 707}}))
+		assert.NoError(t, cluster.Fail(2, 4))
+		assert.Equal(t, []int{7}, cluster.InDoubt(2)) // Answered from the WAL while the site is down
+		assert.Error(t, cluster.DecideSite(2, 7, true, 5))
+		transactionManager.DecisionLog[7] = domain.CommitDecision{Commit: true, Time: 3}
+		assert.NoError(t, cluster.Recover(2, 6))
+		assert.Equal(t, []int{7}, cluster.InDoubt(2))
+		assert.NoError(t, transactionManager.Recover(2, 6))
+		assert.Empty(t, cluster.InDoubt(2))
+		assert.Equal(t, domain.CreateHistoricalValue(707, 3), cluster.Sites[2].GetLastCommitted(2))
+	})
+
+	t.Run("A repaired site should keep its repair after it restarts", func(t *testing.T) {
+		cluster := createCluster(t)
+		for _, site := range cluster.GetSitesForKey(2) {
+			if site != 3 {
+				assert.NoError(t, cluster.Sites[site].Commit(2, 101, 5))
+			}
+		}
+		divergences := cluster.AntiEntropy(true)
+		assert.Len(t, divergences, 1)
+		assert.True(t, divergences[0].Repaired)
+		assert.NoError(t, cluster.Fail(3, 6))
+		assert.NoError(t, cluster.Recover(3, 7))
+		assert.Equal(t, cluster.Sites[1].History(2), cluster.Sites[3].History(2))
+		assert.Empty(t, cluster.AntiEntropy(false))
+	})
+
+	t.Run("A site which is down should be answered from its WAL once per outage", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "site-4.wal")
+		assert.NoError(t, os.WriteFile(walPath, []byte("3 101 5\n"), 0644))
+		site := remote.CreateRemoteSite(4, "127.0.0.1:1", walPath) // Nothing listens on port 1
+		assert.Equal(t, 101, site.GetLastCommitted(3).GetValue())
+		assert.NoError(t, os.WriteFile(walPath, []byte("not a record\n"), 0644))
+		assert.Equal(t, 101, site.GetLastCommitted(3).GetValue()) // The WAL is not read again
+		assert.NoError(t, site.Err())
+	})
+
+	t.Run("A site which is down with an unreadable WAL should return an error instead of panicking", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "site-4.wal")
+		assert.NoError(t, os.WriteFile(walPath, []byte("not a record\n"), 0644))
+		site := remote.CreateRemoteSite(4, "127.0.0.1:1", walPath)
+		assert.Equal(t, 0, site.GetLastCommitted(3).GetValue())
+		assert.ErrorContains(t, site.Err(), "WAL cannot be read")
+	})
+
+	t.Run("A request which fails should return its error and not be written to the WAL", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "site-3.wal")
+		site, err := remote.OpenSite(3, walPath)
+		assert.NoError(t, err)
+		var response remote.Response
+		assert.NoError(t, site.Call(remote.Request{Method: remote.RepairMethod, Key: 1, Versions: []remote.Version{{Value: 101, Time: 5}}}, &response))
+		assert.Equal(t, "site 3 does not hold x1", response.Err)
+		assert.NoError(t, site.Call(remote.Request{Method: remote.CommitMethod, Key: 2, Value: 101, Time: 6}, &response))
+		assert.Empty(t, response.Err)
+		wal, err := os.ReadFile(walPath)
+		assert.NoError(t, err)
+		assert.Equal(t, "2 101 6\n", string(wal))
+		_, err = remote.ReplayWAL(3, walPath)
+		assert.NoError(t, err)
+	})
+
+	t.Run("A WAL whose last record was cut short by a crash should replay, and be appended to after its complete records", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "site-3.wal")
+		assert.NoError(t, os.WriteFile(walPath, []byte("2 101 6\n2 20"), 0644))
+		dataManager, err := remote.ReplayWAL(3, walPath)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.CreateHistoricalValue(101, 6), dataManager.GetLastCommitted(2))
+		site, err := remote.OpenSite(3, walPath)
+		assert.NoError(t, err)
+		var response remote.Response
+		assert.NoError(t, site.Call(remote.Request{Method: remote.CommitMethod, Key: 2, Value: 202, Time: 7}, &response))
+		wal, err := os.ReadFile(walPath)
+		assert.NoError(t, err)
+		assert.Equal(t, "2 101 6\n2 202 7\n", string(wal))
+	})
+
+	t.Run("Failing and recovering a site which does not exist should be an error", func(t *testing.T) {
+		cluster := createCluster(t)
+		assert.Error(t, cluster.Fail(11, 1))
+		assert.Error(t, cluster.Recover(0, 1))
+	})
+
+	for _, file := range []string{"../resources/test10.txt", "../resources/test19.txt", "../resources/test21.txt", "../resources/test24.txt"} {
+		t.Run(fmt.Sprintf("%s should have the same outcome when sites are processes", file), func(t *testing.T) {
+			direct := domain.CreateSiteCoordinator(10)
+			defer direct.Close()
+			directManager := domain.CreateTransactionManager(direct)
+			cluster := createCluster(t)
+			clusterManager := domain.CreateTransactionManager(cluster)
+			for _, run := range []struct {
+				siteCoordinator    domain.SiteCoordinator
+				transactionManager domain.TransactionManager
+			}{{direct, directManager}, {cluster, clusterManager}} {
+				file, err := os.Open(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				internal.SimulationWithOptions(file, run.siteCoordinator, run.transactionManager, internal.Options{ContinueOnError: true, Output: io.Discard})
+				file.Close()
+			}
+			assert.Equal(t, direct.Dump(), cluster.Dump())
+			assert.Equal(t, directManager.History(), clusterManager.History())
+		})
+	}
+}
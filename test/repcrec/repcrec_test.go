@@ -0,0 +1,212 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mingyi850/repcrec"
+	"github.com/stretchr/testify/assert"
+)
+
+func createDB(t *testing.T) *repcrec.DB {
+	db := repcrec.CreateDB()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func begin(t *testing.T, db *repcrec.DB) *repcrec.Tx {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestClient(t *testing.T) {
+	t.Run("Transactions should read committed values from their snapshot", func(t *testing.T) {
+		db := createDB(t)
+		first, second := begin(t, db), begin(t, db)
+		assert.Equal(t, 1, first.ID())
+		assert.Equal(t, 2, second.ID())
+		assert.NoError(t, first.Put(2, 101))
+		value, err := first.Get(2)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, value) // Reads see the snapshot, not the transaction's own writes
+		assert.NoError(t, first.Commit())
+		value, err = second.Get(2)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, value)
+		value, err = begin(t, db).Get(2)
+		assert.NoError(t, err)
+		assert.Equal(t, 101, value)
+	})
+
+	t.Run("A transaction which loses a write conflict should be aborted", func(t *testing.T) {
+		db := createDB(t)
+		first, second := begin(t, db), begin(t, db)
+		assert.NoError(t, first.Put(2, 101))
+		assert.NoError(t, second.Put(2, 202))
+		assert.NoError(t, first.Commit())
+		err := second.Commit()
+		assert.ErrorIs(t, err, repcrec.ErrAborted)
+		assert.Contains(t, err.Error(), "stale")
+		assert.ErrorIs(t, second.Commit(), repcrec.ErrTxDone)
+	})
+
+	t.Run("An operation which waits should run once the site recovers", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		assert.NoError(t, db.Fail(4))
+		_, err := tx.Get(3)
+		assert.ErrorIs(t, err, repcrec.ErrWouldWait)
+		assert.ErrorIs(t, tx.Put(2, 101), repcrec.ErrWouldWait) // The transaction waits, so other operations wait too
+		assert.NoError(t, db.Recover(4))
+		value, err := tx.Get(3)
+		assert.NoError(t, err)
+		assert.Equal(t, 30, value)
+		assert.NoError(t, tx.Commit())
+	})
+
+	t.Run("Rollback should discard writes", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		assert.NoError(t, tx.Put(2, 101))
+		assert.NoError(t, tx.Rollback())
+		assert.ErrorIs(t, tx.Put(2, 101), repcrec.ErrTxDone)
+		value, _ := begin(t, db).Get(2)
+		assert.Equal(t, 20, value)
+	})
+
+	t.Run("Rollback should drop an operation waiting for a site", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		db.Fail(4)
+		assert.ErrorIs(t, tx.Put(3, 101), repcrec.ErrWouldWait)
+		assert.NoError(t, tx.Rollback())
+		assert.NoError(t, db.Recover(4))
+		value, _ := begin(t, db).Get(3)
+		assert.Equal(t, 30, value)
+	})
+
+	t.Run("Read-only transactions should reject writes", func(t *testing.T) {
+		db := createDB(t)
+		tx, err := db.BeginReadOnly()
+		assert.NoError(t, err)
+		err = tx.Put(2, 101)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, repcrec.ErrAborted))
+		_, err = tx.Get(21)
+		assert.EqualError(t, err, "key x21 does not exist")
+		assert.Error(t, db.Fail(11))
+	})
+
+	t.Run("A read-only transaction which closes a RW cycle should be aborted", func(t *testing.T) {
+		db := createDB(t)
+		writer := begin(t, db)
+		_, err := writer.Get(6)
+		assert.NoError(t, err)
+		overwriter := begin(t, db)
+		assert.NoError(t, overwriter.Put(6, 66))
+		assert.NoError(t, overwriter.Commit())
+		reader, err := db.BeginReadOnly()
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Put(4, 44))
+		assert.NoError(t, writer.Commit())
+		_, err = reader.Get(4) // Misses the write of writer, which committed after reader began
+		assert.NoError(t, err)
+		_, err = reader.Get(6) // Sees the write of overwriter: reader -rw-> writer -rw-> overwriter -wr-> reader
+		assert.NoError(t, err)
+		assert.ErrorIs(t, reader.Commit(), repcrec.ErrAborted)
+	})
+
+	t.Run("Databases should be usable from several goroutines at once", func(t *testing.T) {
+		done := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			db := createDB(t)
+			go func() {
+				defer func() { done <- struct{}{} }()
+				for value := 1; value <= 100; value++ {
+					tx := begin(t, db)
+					assert.NoError(t, tx.Put(2, value))
+					assert.NoError(t, tx.Commit())
+				}
+			}()
+		}
+		<-done
+		<-done
+	})
+}
+
+func TestBlockingClient(t *testing.T) {
+	t.Run("A blocked read should return the value it reads once the site recovers", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		db.Fail(4)
+		values := make(chan int)
+		go func() {
+			value, err := tx.GetContext(context.Background(), 3)
+			assert.NoError(t, err)
+			values <- value
+		}()
+		select {
+		case <-values:
+			t.Fatal("read returned before the site recovered")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.NoError(t, db.Recover(4))
+		assert.Equal(t, 30, <-values)
+		assert.NoError(t, tx.Commit())
+	})
+
+	t.Run("A blocked write should complete once the site recovers", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		db.Fail(4)
+		done := make(chan error)
+		go func() { done <- tx.PutContext(context.Background(), 3, 101) }()
+		time.Sleep(20 * time.Millisecond)
+		db.Recover(4)
+		assert.NoError(t, <-done)
+		assert.NoError(t, tx.Commit())
+		value, _ := begin(t, db).Get(3)
+		assert.Equal(t, 101, value)
+	})
+
+	t.Run("Operations which do not wait should not block", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		value, err := tx.GetContext(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, value)
+		assert.NoError(t, tx.PutContext(context.Background(), 2, 101))
+	})
+
+	t.Run("A blocked call should roll back its transaction when the deadline passes", func(t *testing.T) {
+		db := createDB(t)
+		tx := begin(t, db)
+		db.Fail(4)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := tx.GetContext(ctx, 3)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, tx.Commit(), repcrec.ErrTxDone)
+		db.Recover(4) // The dropped read does not run
+	})
+
+	t.Run("A call on an aborted transaction should return the reason it was aborted", func(t *testing.T) {
+		db := createDB(t)
+		for site := 1; site <= 10; site++ {
+			db.Fail(site)
+			db.Recover(site)
+		}
+		tx := begin(t, db) // Every site holding x2 was down between the last commit to x2 and the start of T1
+		_, err := tx.GetContext(context.Background(), 2)
+		assert.ErrorIs(t, err, repcrec.ErrAborted)
+		err = tx.PutContext(context.Background(), 4, 101)
+		assert.ErrorIs(t, err, repcrec.ErrAborted)
+		assert.Contains(t, err.Error(), "No site holding x2")
+		assert.False(t, errors.Is(err, repcrec.ErrWouldWait))
+	})
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+/* A connection to a test server. Reads time out, so that a missing response fails the test instead of hanging */
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func startServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	t.Cleanup(func() { siteCoordinator.Close() })
+	server := internal.CreateServer(siteCoordinator, domain.CreateTransactionManager(siteCoordinator))
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func connect(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn, bufio.NewReader(conn)}
+}
+
+/* Sends a line and returns its output, up to and including the "tick N" line which ends it */
+func (c *testClient) send(t *testing.T, line string) []string {
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	output := make([]string, 0)
+	for {
+		text := c.readLine(t)
+		output = append(output, text)
+		if strings.HasPrefix(text, "tick ") {
+			return output
+		}
+	}
+}
+
+func (c *testClient) readLine(t *testing.T) string {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	text, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(text, "\n")
+}
+
+func TestServer(t *testing.T) {
+	t.Run("Clients should share one database and one clock", func(t *testing.T) {
+		addr := startServer(t)
+		first, second := connect(t, addr), connect(t, addr)
+		assert.Equal(t, []string{"tick 1"}, first.send(t, "begin(T1)"))
+		assert.Equal(t, []string{"tick 2"}, second.send(t, "begin(T2)"))
+		assert.Equal(t, []string{"T1 writes x2: sites: [1 2 3 4 5 6 7 8 9 10]", "tick 3"}, first.send(t, "W(T1, x2, 101)"))
+		assert.Equal(t, []string{"T1 commits", "tick 4"}, first.send(t, "end(T1)"))
+		assert.Equal(t, []string{"x2: 20", "tick 5"}, second.send(t, "R(T2, x2)")) // T2 began before T1 committed
+		assert.Equal(t, []string{"tick 6"}, second.send(t, "begin(T3)"))
+		assert.Equal(t, []string{"x2: 101", "tick 7"}, second.send(t, "R(T3, x2)"))
+	})
+
+	t.Run("Errors should be reported to the connection which caused them", func(t *testing.T) {
+		addr := startServer(t)
+		first, second := connect(t, addr), connect(t, addr)
+		assert.Equal(t, []string{"Error at line 1: R(T9, x2): Transaction 9 does not exist", "tick 1"}, first.send(t, "R(T9, x2)"))
+		assert.Equal(t, "Error at line 2: line 2, column 1: unknown command \"wrong\"", first.send(t, "wrong(T1)")[0])
+		assert.Equal(t, []string{"tick 2"}, second.send(t, "begin(T1)"))
+	})
+
+	t.Run("A site which does not exist should be an error, not stop the server", func(t *testing.T) {
+		addr := startServer(t)
+		first, second := connect(t, addr), connect(t, addr)
+		assert.Equal(t, []string{"Error at line 1: fail(11): site 11 does not exist", "tick 1"}, first.send(t, "fail(11)"))
+		assert.Equal(t, []string{"tick 2"}, second.send(t, "begin(T1)"))
+	})
+
+	t.Run("A read which waited should be answered on the connection which issued it", func(t *testing.T) {
+		addr := startServer(t)
+		first, second := connect(t, addr), connect(t, addr)
+		first.send(t, "begin(T1)")
+		second.send(t, "fail(4)")
+		assert.Equal(t, []string{"T1 waits", "tick 3"}, first.send(t, "R(T1, x3)"))
+		assert.Equal(t, []string{"tick 4"}, second.send(t, "recover(4)"))
+		assert.Equal(t, "x3: 30", first.readLine(t))
+		assert.Equal(t, []string{"T1 commits", "tick 5"}, first.send(t, "end(T1)"))
+	})
+
+	t.Run("A client which does not read its output should not block other clients", func(t *testing.T) {
+		addr := startServer(t)
+		slow, fast := connect(t, addr), connect(t, addr)
+		slow.conn.(*net.TCPConn).SetReadBuffer(1024)
+		go func() {
+			for i := 0; i < 20000; i++ { // Enough output to fill the socket buffers of the slow client
+				if _, err := slow.conn.Write([]byte("dump()\n")); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Second)
+		assert.Len(t, fast.send(t, "begin(T1)"), 1) // Only the tick, which times out if the slow client holds up the server
+	})
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/mingyi850/repcrec/internal/shrink"
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, script string) []parser.Line {
+	parsed, err := parser.Parse(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Lines
+}
+
+func TestShrink(t *testing.T) {
+	t.Run("Shrink should keep only what a failing expectation needs", func(t *testing.T) {
+		lines := parse(t, `begin(T1)
+begin(T2)
+fail(3)
+W(T2, x2, 22)
+R(T1, x6)
+recover(3)
+end(T2)
+begin(T3); R(T3, x2)
+expect R(T3, x2) = 20
+end(T1)
+expect T1 commits
+end(T3)
+dump()
+`)
+		fails, description, err := shrink.NewPredicate(shrink.FailedExpectation, lines)
+		assert.Nil(t, err)
+		assert.Equal(t, "expect R(T3, x2) = 20: expectation failed, T3 read x2 = 22", description)
+		result := shrink.Shrink(lines, fails)
+		assert.Equal(t, "begin(T2)\nW(T2, x2, 22)\nend(T2)\nbegin(T3); R(T3, x2)\nexpect R(T3, x2) = 20\n", shrink.Format(result.Lines))
+		assert.True(t, fails(result.Lines))
+		assert.Greater(t, result.Runs, 0)
+	})
+
+	t.Run("Shrink should not keep a script whose expectation fails for another reason", func(t *testing.T) {
+		lines := parse(t, "begin(T1)\nR(T1, x4)\nexpect R(T1, x4) = 41\n")
+		fails, _, _ := shrink.NewPredicate(shrink.FailedExpectation, lines)
+		assert.False(t, fails(parse(t, "begin(T1)\nexpect R(T1, x4) = 41\n"))) // T1 has not read x4, which is a different failure
+	})
+
+	t.Run("Shrink should keep only the step which violates an invariant", func(t *testing.T) {
+		lines := parse(t, "begin(T1)\nW(T1, x2, 1001)\nfail(2)\nend(T1)\nrecover(2)\nR(T9, x2)\nbegin(T2)\nend(T2)\n")
+		fails, description, err := shrink.NewPredicate(shrink.InvariantViolation, lines)
+		assert.Nil(t, err)
+		assert.Contains(t, description, "valid commands violated at tick 6")
+		assert.Equal(t, "R(T9, x2)\n", shrink.Format(shrink.Shrink(lines, fails).Lines))
+	})
+
+	t.Run("Shrink should remove a site failure together with its recovery", func(t *testing.T) {
+		lines := parse(t, "begin(T1)\nfail(4)\nfail(5)\nW(T1, x2, 5)\nrecover(4)\nrecover(5)\nend(T1)\n")
+		keepsFail := func(candidate []parser.Line) bool {
+			return strings.Contains(shrink.Format(candidate), "fail(5)")
+		}
+		assert.Equal(t, "fail(5)\n", shrink.Format(shrink.Shrink(lines, keepsFail).Lines))
+	})
+
+	t.Run("NewPredicate should reject a script which does not panic, such as one naming a site which does not exist", func(t *testing.T) {
+		lines := parse(t, "begin(T1)\nW(T1, x2, 5)\nfail(11)\nend(T1)\n")
+		_, _, err := shrink.NewPredicate(shrink.Panic, lines)
+		assert.EqualError(t, err, "the script does not panic")
+	})
+
+	t.Run("NewPredicate should reject scripts which do not fail", func(t *testing.T) {
+		lines := parse(t, "begin(T1)\nR(T1, x2)\nexpect R(T1, x2) = 20\nend(T1)\n")
+		_, _, err := shrink.NewPredicate(shrink.FailedExpectation, lines)
+		assert.EqualError(t, err, "every expectation of the script holds")
+		_, _, err = shrink.NewPredicate(shrink.InvariantViolation, lines)
+		assert.EqualError(t, err, "every invariant holds throughout the script")
+		_, _, err = shrink.NewPredicate(shrink.Panic, lines)
+		assert.EqualError(t, err, "the script does not panic")
+		_, _, err = shrink.NewPredicate("timeout", lines)
+		assert.Contains(t, err.Error(), "unknown failure")
+	})
+}
@@ -1,31 +1,51 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/clock"
 	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/history"
 	"github.com/stretchr/testify/assert"
 )
 
-func runTest(filePath string) (*SiteCoordinatorTestImpl, domain.TransactionManager, error) {
+func runTest(t *testing.T, filePath string) (*SiteCoordinatorTestImpl, domain.TransactionManager, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		fmt.Println(err)
 		return nil, nil, err
 	}
 	defer file.Close()
-	siteCoordinator := CreateSiteCoordinatorTestImpl(10)
+	siteCoordinator := CreateSiteCoordinatorTestImpl(t, 10)
 	transactionManager := domain.CreateTransactionManager(siteCoordinator)
 	err = internal.Simulation(file, siteCoordinator, transactionManager)
 	return siteCoordinator, transactionManager, err
 }
+
+func runTestWithOptions(t *testing.T, filePath string, options internal.Options) (*SiteCoordinatorTestImpl, domain.TransactionManager, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	defer file.Close()
+	siteCoordinator := CreateSiteCoordinatorTestImpl(t, 10)
+	transactionManager := domain.CreateTransactionManager(siteCoordinator)
+	err = internal.SimulationWithOptions(file, siteCoordinator, transactionManager, options)
+	return siteCoordinator, transactionManager, err
+}
 func TestSimulation(t *testing.T) {
 
 	t.Run("Successfully Reads and Writes to unreplicated site", func(t *testing.T) {
-		siteCoordinator, _, err := runTest("resources/test1.txt")
+		siteCoordinator, _, err := runTest(t, "resources/test1.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -35,7 +55,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Successfully Reads and Writes to replicated site", func(t *testing.T) {
-		siteCoordinator, _, err := runTest("resources/test2.txt")
+		siteCoordinator, _, err := runTest(t, "resources/test2.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -48,7 +68,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Should terminate on invalid operation", func(t *testing.T) {
-		siteCoordinator, _, err := runTest("resources/test3.txt")
+		siteCoordinator, _, err := runTest(t, "resources/test3.txt")
 		if err != nil {
 			assert.Contains(t, err.Error(), "does not exist")
 			assert.Equal(t, 40, siteCoordinator.GetLatestValue(1, 4).GetValue()) // Original value of 4
@@ -60,7 +80,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("First Commit Wins", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test4.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test4.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -75,7 +95,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Reads should last committed value at transaction start", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test5.txt")
+		_, transactionManager, err := runTest(t, "resources/test5.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -87,7 +107,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Reads should abort if no site can possibly service request and wait if there is a site, but it is down", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test6.txt")
+		_, transactionManager, err := runTest(t, "resources/test6.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -103,7 +123,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Reads should always wait for site on unreplicated variable", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test7.txt")
+		_, transactionManager, err := runTest(t, "resources/test7.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -119,7 +139,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Transactions should continue when a blocking site is recovered", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test6.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test6.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -143,7 +163,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Transactions should re-block when a blocking site is encountered during recovery", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test9.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test9.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -176,7 +196,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Failure after write aborts transaction", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test10.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test10.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -187,7 +207,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("RWRW in graph cycle aborts transaction", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test11.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test11.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -206,7 +226,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("RWRW in graph cycle aborts transaction part 2", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test12.txt")
+		_, transactionManager, err := runTest(t, "resources/test12.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -222,7 +242,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("RWRW in graph cycle - abort avoided by strategic commits", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test14.txt")
+		_, transactionManager, err := runTest(t, "resources/test14.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -239,7 +259,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Transaction should abort on read if no valid sites (even if active)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test15.txt")
+		_, transactionManager, err := runTest(t, "resources/test15.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -253,7 +273,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Transaction should abort if another commits first", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test16.txt")
+		_, transactionManager, err := runTest(t, "resources/test16.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -267,7 +287,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write conflict between T1 and T2", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test17.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test17.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -282,7 +302,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Serializable snapshot - no conflicts", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test18.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test18.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -297,7 +317,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("All transaction commits despite site failure", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test19.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test19.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -312,7 +332,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write is lost due to abort", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test20.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test20.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -327,7 +347,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write is lost due to abort (part 2)", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test21.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test21.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -342,7 +362,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write is lost due to abort (part 3)", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test22.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test22.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -357,7 +377,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write is lost due to abort (part 4)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test23.txt")
+		_, transactionManager, err := runTest(t, "resources/test23.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -369,7 +389,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Read from unreplicated variable at recovering site is allowed", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test24.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test24.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -384,7 +404,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation reads from original version of site at transaction begin", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test25.txt")
+		_, transactionManager, err := runTest(t, "resources/test25.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -394,7 +414,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation reads from original version of site at transaction begin (part 2)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test26.txt")
+		_, transactionManager, err := runTest(t, "resources/test26.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -406,7 +426,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation reads from original version of site at transaction begin (part 3)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test27.txt")
+		_, transactionManager, err := runTest(t, "resources/test27.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -420,7 +440,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation reads from new version of site at transaction begin.", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test28.txt")
+		_, transactionManager, err := runTest(t, "resources/test28.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -435,7 +455,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("All transactions commit if no conflict occurs", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test29.txt")
+		_, transactionManager, err := runTest(t, "resources/test29.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -447,7 +467,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("All transactions commit if no conflict occurs (part 2)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test30.txt")
+		_, transactionManager, err := runTest(t, "resources/test30.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -459,7 +479,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Only first commit wins", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test31.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test31.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -474,7 +494,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Only first commit wins (part 2)", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test32.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test32.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -489,7 +509,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Complex case - transasction aborts due to failure, then first commit wins", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test33.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test33.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -508,7 +528,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation - reads value from when transaction began", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test34.txt")
+		_, transactionManager, err := runTest(t, "resources/test34.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -523,7 +543,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Snapshot isolation - reads value from when transaction began. Ignore aborted writes", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test35.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test35.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -539,7 +559,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Circular conflict - all RW edges. Cycle closing transaction aborted", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test36.txt")
+		_, transactionManager, err := runTest(t, "resources/test36.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -557,7 +577,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Almost Circular conflict - all RW edges. No cycle because a transaction aborts", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test37.txt")
+		_, transactionManager, err := runTest(t, "resources/test37.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -575,7 +595,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Write conflcit, first commit wins", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test38.txt")
+		_, transactionManager, err := runTest(t, "resources/test38.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -587,7 +607,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Simple R-W cycle - cycle closing transaction aborts", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test39.txt")
+		_, transactionManager, err := runTest(t, "resources/test39.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -599,7 +619,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("R-W cycle - cycle closing transaction with WW aborts", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test40.txt")
+		_, transactionManager, err := runTest(t, "resources/test40.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -613,7 +633,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Read should abort immediately if no valid site for read on replicated site", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test41.txt")
+		_, transactionManager, err := runTest(t, "resources/test41.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -627,7 +647,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Read should abort immediately if no valid site for read on replicated site (part 2)", func(t *testing.T) {
-		_, transactionManager, err := runTest("resources/test42.txt")
+		_, transactionManager, err := runTest(t, "resources/test42.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -643,7 +663,7 @@ func TestSimulation(t *testing.T) {
 	})
 
 	t.Run("Read should wait if valid site exists but is down for read on replicated site", func(t *testing.T) {
-		siteCoordinator, transactionManager, err := runTest("resources/test43.txt")
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test43.txt")
 		if err != nil {
 			fmt.Printf("Error: %v", err)
 			t.Fatal(err)
@@ -664,4 +684,326 @@ func TestSimulation(t *testing.T) {
 		tx3, _, _ = transactionManager.GetTransaction(3)
 		assert.Equal(t, domain.TxCommitted, tx3.GetState())
 	})
+
+	t.Run("Explain should record why sites were excluded from a read which aborts", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test15.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		excluded := make(map[int]domain.SiteReadResult)
+		var last domain.Decision
+		for _, decision := range tx3.GetDecisions() {
+			if decision.GetType() == domain.DecisionSiteExcluded {
+				excluded[decision.GetSite()] = domain.SiteReadResult(decision.GetDetail())
+			}
+			last = decision
+		}
+		assert.Equal(t, 10, len(excluded))
+		assert.Equal(t, domain.SiteDownSinceCommit, excluded[1])
+		assert.Equal(t, domain.SiteNotAliveAtStart, excluded[10])
+		assert.Equal(t, domain.DecisionAbort, last.GetType())
+
+		explanation, err := transactionManager.Explain(3)
+		assert.Nil(t, err)
+		assert.Contains(t, explanation, "T3: aborted")
+	})
+
+	t.Run("Dump variants should show a single key, a single site or a key's history", func(t *testing.T) {
+		siteCoordinator, _, err := runTest(t, "resources/test24.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		keyDump, err := siteCoordinator.DumpKey(8)
+		assert.Nil(t, err)
+		assert.Contains(t, keyDump, "site 4 - x8: 80\nsite 5 - x8: 88")
+		siteDump, err := siteCoordinator.DumpSite(2)
+		assert.Nil(t, err)
+		assert.Contains(t, siteDump, "site 2 - x1: 10, x2: 20")
+		history, err := siteCoordinator.DumpHistory(8)
+		assert.Nil(t, err)
+		assert.Contains(t, history, "site 3 - x8: 80 at init\n")
+		assert.Contains(t, history, "site 5 - x8: 80 at init, 88 at 11")
+		_, err = siteCoordinator.DumpKey(21)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Querystate shows transactions, waits, the graph and site uptime", func(t *testing.T) {
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test55.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		state := transactionManager.QueryState()
+		assert.Contains(t, state, "T1: committed, start 1, end 4, pending [], waiting sites []")
+		assert.Contains(t, state, "T2: waiting, start 2, end -, pending [R(x1)@8], waiting sites [2], site writes {}")
+		assert.Contains(t, state, "T3: active, start 5, end -, pending [], waiting sites [], site writes {1: [W(x4, 41)@6]")
+		assert.Contains(t, state, "Waiting transactions: [T2]")
+		assert.Contains(t, state, "Transaction graph:\n  T1 (committed at 4): []")
+		sites := siteCoordinator.QueryState()
+		assert.Contains(t, sites, "site 1: [init, now]")
+		assert.Contains(t, sites, "site 2: [init, 7]\n")
+		assert.Contains(t, sites, "site 5: [init, 9], [10, now]")
+	})
+
+	t.Run("Read-only transactions can read as of a past tick", func(t *testing.T) {
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test44.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		assert.Equal(t, domain.TxCommitted, tx3.GetState())
+
+		transactionManager.BeginRO(4, 20)
+		result, err := transactionManager.ReadAsOf(4, 4, 3, 21)
+		assert.Nil(t, err)
+		assert.Equal(t, 111, result.Value)
+		result, err = transactionManager.ReadAsOf(4, 4, 2, 22)
+		assert.Nil(t, err)
+		assert.Equal(t, 40, result.Value)
+		_, err = transactionManager.ReadAsOf(4, 4, 30, 23) // Tick is in the future
+		assert.NotNil(t, err)
+		_, err = transactionManager.Write(4, 4, 1, 24) // Read-only transactions cannot write
+		assert.NotNil(t, err)
+
+		transactionManager.Begin(5, 25)
+		_, err = transactionManager.ReadAsOf(5, 4, 3, 26) // Only read-only transactions can read as of a past tick
+		assert.NotNil(t, err)
+
+		assert.Contains(t, siteCoordinator.DumpAsOf(9), "site 4 - down at 9")
+		assert.Contains(t, siteCoordinator.DumpAsOf(7), "site 4 - x2: 20, x3: 333, x4: 222")
+	})
+
+	t.Run("Read-only transactions take part in RW cycle detection", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test54.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		assert.Equal(t, domain.TxCommitted, tx3.GetState())
+		tx1, _, _ := transactionManager.GetTransaction(1)
+		assert.Equal(t, domain.TxAborted, tx1.GetState())
+	})
+
+	t.Run("Reads as of a past tick do not take part in RW cycle detection", func(t *testing.T) {
+		siteCoordinator := CreateSiteCoordinatorTestImpl(t, 10)
+		transactionManager := domain.CreateTransactionManager(siteCoordinator)
+		transactionManager.Begin(1, 1)
+		transactionManager.Read(1, 4, 2)
+		transactionManager.Begin(2, 3)
+		transactionManager.Write(2, 4, 44, 4)
+		transactionManager.End(2, 5)
+		transactionManager.BeginRO(3, 6)
+		transactionManager.Read(3, 4, 7)
+		transactionManager.ReadAsOf(3, 6, 2, 8)
+		transactionManager.End(3, 9)
+		transactionManager.Write(1, 6, 33, 10)
+		result, err := transactionManager.End(1, 11)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Success, result.ResultType)
+	})
+
+	t.Run("Operations separated by semicolons share a tick", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test45.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		tx1, _, _ := transactionManager.GetTransaction(1)
+		tx2, _, _ := transactionManager.GetTransaction(2)
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		assert.Equal(t, domain.TxCommitted, tx1.GetState())
+		assert.Equal(t, domain.TxCommitted, tx2.GetState())
+		assert.Equal(t, domain.TxCommitted, tx3.GetState())
+		graph := transactionManager.GetTransactionGraph()
+		assert.Equal(t, domain.WR, graph.GetEdges(1)[3]) // T3 read the value committed by T1 on the tick it began
+		time, _ := graph.GetCommitTime(1)
+		assert.Equal(t, 3, time)
+	})
+
+	t.Run("Operations sharing a tick should observe commits in the order they appear on the line", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test56.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		tx4, _, _ := transactionManager.GetTransaction(4)
+		value, _ := tx3.GetLastRead(2)
+		assert.Equal(t, 20, value) // T1 ended after T3 began
+		assert.Equal(t, domain.TxAborted, tx3.GetState())
+		value, _ = tx4.GetLastRead(4)
+		assert.Equal(t, 41, value) // T2 ended before T4 began
+		assert.Equal(t, domain.TxCommitted, tx4.GetState())
+		graph := transactionManager.GetTransactionGraph()
+		assert.Equal(t, domain.WR, graph.GetEdges(2)[4]) // T4 read the value committed by T2 on the tick it began
+	})
+
+	t.Run("Sites and keys which do not exist should be errors on their line", func(t *testing.T) {
+		_, transactionManager, err := runTestWithOptions(t, "resources/test57.txt", internal.Options{ContinueOnError: true})
+		commandErrors, ok := err.(internal.CommandErrors)
+		assert.Equal(t, true, ok)
+		messages := make([]string, len(commandErrors))
+		for i, commandError := range commandErrors {
+			messages[i] = commandError.Error()
+		}
+		assert.Equal(t, []string{
+			"line 4: fail(11): site 11 does not exist",
+			"line 5: recover(0): site 0 does not exist",
+			"line 7: W(T1, x22, 5): key x22 does not exist",
+			"line 7: R(T1, x22): key x22 does not exist",
+			"line 9: dump(11): site 11 does not exist",
+		}, messages)
+		tx1, _, _ := transactionManager.GetTransaction(1)
+		assert.Equal(t, domain.TxCommitted, tx1.GetState())
+	})
+
+	t.Run("Continue on error skips failing commands and summarises errors", func(t *testing.T) {
+		siteCoordinator, transactionManager, err := runTestWithOptions(t, "resources/test46.txt", internal.Options{ContinueOnError: true})
+		commandErrors, ok := err.(internal.CommandErrors)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 2, len(commandErrors))
+		assert.Equal(t, 6, commandErrors[0].Line)
+		assert.Equal(t, "W(T2, x4, 222)", commandErrors[0].Command)
+		assert.Contains(t, commandErrors[0].Error(), "Transaction 2 does not exist")
+		assert.Equal(t, 8, commandErrors[1].Line)
+		assert.Contains(t, commandErrors[1].Error(), "Transaction 1 already exists")
+		tx1, _, _ := transactionManager.GetTransaction(1)
+		tx3, _, _ := transactionManager.GetTransaction(3)
+		assert.Equal(t, domain.TxCommitted, tx1.GetState())
+		assert.Equal(t, domain.TxCommitted, tx3.GetState()) // Commands after the failing one on the same line still run
+		assert.Equal(t, 111, siteCoordinator.GetLatestValue(1, 4).GetValue())
+	})
+
+	t.Run("Should stop at the first error without continue on error", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test46.txt")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Transaction 2 does not exist")
+		_, _, err = transactionManager.GetTransaction(3)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Includes, macros and loops expand before execution", func(t *testing.T) {
+		siteCoordinator, transactionManager, err := runTest(t, "resources/test47.txt")
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			t.Fatal(err)
+		}
+		for i := 1; i <= 3; i++ {
+			tx, _, _ := transactionManager.GetTransaction(i)
+			assert.Equal(t, domain.TxCommitted, tx.GetState())
+			assert.Equal(t, i*100, siteCoordinator.GetLatestValue(1, i*2).GetValue())
+		}
+		tx12, _, _ := transactionManager.GetTransaction(12)
+		assert.Equal(t, domain.TxAborted, tx12.GetState())
+		assert.Equal(t, 1001, siteCoordinator.GetLatestValue(1, 20).GetValue())
+		assert.Equal(t, 88, siteCoordinator.GetLatestValue(1, 8).GetValue())
+	})
+
+	t.Run("Scenario files satisfy their expectations", func(t *testing.T) {
+		files, _ := filepath.Glob("resources/*.txt")
+		for _, file := range files {
+			if file == "resources/test49.txt" { // Every expectation in test49 fails on purpose
+				continue
+			}
+			_, _, err := runTest(t, file)
+			var expectationError *internal.ExpectationError
+			assert.False(t, errors.As(err, &expectationError), "%s: %v", file, err)
+		}
+	})
+
+	t.Run("Failed expectations are reported without stopping the run", func(t *testing.T) {
+		_, transactionManager, err := runTest(t, "resources/test49.txt")
+		commandErrors, ok := err.(internal.CommandErrors)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 5, len(commandErrors))
+		assert.Equal(t, 6, commandErrors[0].Line)
+		assert.Equal(t, "expect R(T1, x4) = 41", commandErrors[0].Command)
+		assert.Contains(t, commandErrors[0].Error(), "T1 read x4 = 40")
+		assert.Contains(t, commandErrors[2].Error(), "T1 is active")
+		assert.Contains(t, commandErrors[3].Error(), "site 2 has x4: 111")
+		tx1, _, _ := transactionManager.GetTransaction(1)
+		assert.Equal(t, domain.TxCommitted, tx1.GetState())
+	})
+
+	t.Run("Periodic anti-entropy repairs a recovered site without verify", func(t *testing.T) {
+		var output strings.Builder
+		siteCoordinator, _, err := runTestWithOptions(t, "resources/test53.txt", internal.Options{AntiEntropyEvery: 1, Output: &output})
+		assert.Nil(t, err)
+		assert.Contains(t, output.String(), "anti-entropy: x2 at site 3: missing 101 at 5, 102 at 5; repaired")
+		assert.NotContains(t, output.String(), "verify: x2")
+		assert.Equal(t, domain.CreateHistoricalValue(102, 5), siteCoordinator.GetLatestValue(3, 2))
+	})
+}
+
+func TestClocks(t *testing.T) {
+	files, err := filepath.Glob("resources/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(fmt.Sprintf("%s should have the same outcome under a hybrid clock", file), func(t *testing.T) {
+			contents, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(contents), "@") {
+				t.Skip("reads as of a past tick name logical ticks")
+			}
+			_, logical, _ := runTestWithOptions(t, file, internal.Options{ContinueOnError: true, Output: io.Discard})
+			nanoseconds := int64(0)
+			hybrid := clock.CreateHybridClock(func() int64 {
+				nanoseconds += 700_000 // Some events share a millisecond
+				return nanoseconds
+			})
+			_, hybridManager, _ := runTestWithOptions(t, file, internal.Options{ContinueOnError: true, Clock: hybrid, Output: io.Discard})
+			assert.Equal(t, withoutCommitTimes(logical.History()), withoutCommitTimes(hybridManager.History()))
+		})
+	}
+}
+
+/* Returns a history with commit and version times cleared, which differ between clocks */
+func withoutCommitTimes(committed history.History) history.History {
+	for i := range committed.Transactions {
+		committed.Transactions[i].CommitTime = 0
+		for j := range committed.Transactions[i].Reads {
+			committed.Transactions[i].Reads[j].VersionTime = 0
+		}
+	}
+	return committed
+}
+
+func TestFaultyNetwork(t *testing.T) {
+	files, err := filepath.Glob("resources/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range files {
+		t.Run(fmt.Sprintf("%s should have the same outcome when messages to sites are delayed, reordered and lost", file), func(t *testing.T) {
+			t.Parallel() // Runs mostly wait for the network
+			direct := domain.CreateSiteCoordinator(10)
+			defer direct.Close()
+			directManager := domain.CreateTransactionManager(direct)
+			network := domain.CreateFaultyNetwork(domain.FaultConfig{MaxDelay: 20 * time.Microsecond, Loss: 0.2, Seed: int64(i), RetryTimeout: 200 * time.Microsecond})
+			faulty := domain.CreateSiteCoordinatorWithNetwork(10, network)
+			defer faulty.Close()
+			faultyManager := domain.CreateTransactionManager(faulty)
+			for _, run := range []struct {
+				siteCoordinator    domain.SiteCoordinator
+				transactionManager domain.TransactionManager
+			}{{direct, directManager}, {faulty, faultyManager}} {
+				file, err := os.Open(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				internal.SimulationWithOptions(file, run.siteCoordinator, run.transactionManager, internal.Options{ContinueOnError: true, Output: io.Discard})
+				file.Close()
+			}
+			assert.Equal(t, direct.Dump(), faulty.Dump())
+			assert.Equal(t, directManager.History(), faultyManager.History())
+		})
+	}
 }
@@ -1,14 +1,21 @@
 package internal
 
-import "github.com/mingyi850/repcrec/internal/domain"
+import (
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/domain"
+)
 
 type SiteCoordinatorTestImpl struct {
 	siteCoordinator *domain.SiteCoordinatorImpl
 }
 
-func CreateSiteCoordinatorTestImpl(numSites int) *SiteCoordinatorTestImpl {
+/* Creates a SiteCoordinatorTestImpl whose sites are stopped when the test ends */
+func CreateSiteCoordinatorTestImpl(t *testing.T, numSites int) *SiteCoordinatorTestImpl {
+	siteCoordinator := domain.CreateSiteCoordinator(numSites)
+	t.Cleanup(func() { siteCoordinator.Close() })
 	return &SiteCoordinatorTestImpl{
-		siteCoordinator: domain.CreateSiteCoordinator(numSites),
+		siteCoordinator: siteCoordinator,
 	}
 }
 
@@ -24,10 +31,38 @@ func (s *SiteCoordinatorTestImpl) Dump() string {
 	return s.siteCoordinator.Dump()
 }
 
+func (s *SiteCoordinatorTestImpl) DumpSite(site int) (string, error) {
+	return s.siteCoordinator.DumpSite(site)
+}
+
+func (s *SiteCoordinatorTestImpl) DumpKey(key int) (string, error) {
+	return s.siteCoordinator.DumpKey(key)
+}
+
+func (s *SiteCoordinatorTestImpl) DumpHistory(key int) (string, error) {
+	return s.siteCoordinator.DumpHistory(key)
+}
+
+func (s *SiteCoordinatorTestImpl) DumpAsOf(time int) string {
+	return s.siteCoordinator.DumpAsOf(time)
+}
+
+func (s *SiteCoordinatorTestImpl) QueryState() string {
+	return s.siteCoordinator.QueryState()
+}
+
 func (s *SiteCoordinatorTestImpl) ReadActiveSite(site int, key int, time int) (domain.HistoricalValue, error) {
 	return s.siteCoordinator.ReadActiveSite(site, key, time)
 }
 
+func (s *SiteCoordinatorTestImpl) GetLastCommitted(site int, key int) (domain.HistoricalValue, error) {
+	return s.siteCoordinator.GetLastCommitted(site, key)
+}
+
+func (s *SiteCoordinatorTestImpl) GetSites() []int {
+	return s.siteCoordinator.GetSites()
+}
+
 func (s *SiteCoordinatorTestImpl) GetSitesForKey(key int) []int {
 	return s.siteCoordinator.GetSitesForKey(key)
 }
@@ -40,14 +75,34 @@ func (s *SiteCoordinatorTestImpl) GetValidSitesForRead(key int, txStart int) []i
 	return s.siteCoordinator.GetValidSitesForRead(key, txStart)
 }
 
-func (s *SiteCoordinatorTestImpl) VerifySiteWrite(site int, key int, writeTime int, currentTime int) domain.SiteCommitResult {
-	return s.siteCoordinator.VerifySiteWrite(site, key, writeTime, currentTime)
+func (s *SiteCoordinatorTestImpl) VerifySiteRead(site int, key int, txStart int) domain.SiteReadResult {
+	return s.siteCoordinator.VerifySiteRead(site, key, txStart)
+}
+
+func (s *SiteCoordinatorTestImpl) VerifySiteWrite(site int, key int, snapshot int, writeTime int, currentTime int) domain.SiteCommitResult {
+	return s.siteCoordinator.VerifySiteWrite(site, key, snapshot, writeTime, currentTime)
 }
 
 func (s *SiteCoordinatorTestImpl) CommitSiteWrite(site int, key int, value int, time int) error {
 	return s.siteCoordinator.CommitSiteWrite(site, key, value, time)
 }
 
+func (s *SiteCoordinatorTestImpl) PrepareSite(site int, tx int, snapshot int, writes []domain.Operation, currentTime int) domain.SiteVote {
+	return s.siteCoordinator.PrepareSite(site, tx, snapshot, writes, currentTime)
+}
+
+func (s *SiteCoordinatorTestImpl) DecideSite(site int, tx int, commit bool, time int) error {
+	return s.siteCoordinator.DecideSite(site, tx, commit, time)
+}
+
+func (s *SiteCoordinatorTestImpl) InDoubt(site int) []int {
+	return s.siteCoordinator.InDoubt(site)
+}
+
+func (s *SiteCoordinatorTestImpl) AntiEntropy(repair bool) []domain.Divergence {
+	return s.siteCoordinator.AntiEntropy(repair)
+}
+
 type TransactionManagerTestImpl struct {
 	transactionManager *domain.TransactionManagerImpl
 }
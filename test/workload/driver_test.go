@@ -0,0 +1,106 @@
+package test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal/workload"
+	"github.com/stretchr/testify/assert"
+)
+
+var seed = flag.Int64("seed", 0, "run the fault-injection test for a single seed, e.g. to reproduce a failure")
+var seeds = flag.Int64("seeds", 20, "number of seeds the fault-injection test runs, e.g. 300 for a long sweep. Only 5 with -short")
+
+/* The config of the fault-injection test: many concurrent transactions over few keys, with frequent site failures */
+func faultConfig(seed int64) workload.Config {
+	config := workload.DefaultConfig()
+	config.Seed = seed
+	config.Transactions = 20
+	config.Concurrency = 6
+	config.Keys = 8
+	config.Distribution = workload.Zipf
+	config.ReadOnlyRatio = 0.2
+	config.FailProbability = 0.1
+	config.RecoverProbability = 0.3
+	return config
+}
+
+/*
+Runs seeded workloads with random site failures, checking invariants after every tick.
+A failing seed writes the script which reproduces it to the temp directory. Rerun it with -seed, or replay the script with repcrec
+*/
+func TestFaultInjection(t *testing.T) {
+	first, last := int64(1), *seeds
+	if testing.Short() {
+		last = min(last, 5)
+	}
+	if *seed != 0 {
+		first, last = *seed, *seed
+	}
+	for s := first; s <= last; s++ {
+		violation, err := workload.Run(faultConfig(s))
+		assert.Nil(t, err)
+		if violation != nil {
+			path := filepath.Join(os.TempDir(), fmt.Sprintf("repcrec-seed-%d.txt", s))
+			os.WriteFile(path, []byte(violation.Script), 0644)
+			t.Errorf("seed %d: %v\nreproduce with -seed %d, script written to %s:\n%s", s, violation, s, path, violation.Script)
+		}
+	}
+}
+
+func TestDriver(t *testing.T) {
+	t.Run("Steps should be written as the generated script", func(t *testing.T) {
+		config := faultConfig(7)
+		steps, err := workload.Steps(config)
+		assert.Nil(t, err)
+		script, _ := workload.Generate(config)
+		assert.Equal(t, script, workload.Script("// Generated by repcrec gen "+config.String(), steps))
+		assert.Equal(t, "dump()", steps[len(steps)-1].String())
+	})
+
+	t.Run("RunSteps should stop at the first violation with the script up to it", func(t *testing.T) {
+		steps := []workload.Step{
+			{Kind: workload.BeginStep, Tx: 1},
+			{Kind: workload.WriteStep, Tx: 1, Key: 2, Value: 1001},
+			{Kind: workload.EndStep, Tx: 1},
+			{Kind: workload.ReadStep, Tx: 2, Key: 2}, // T2 never began, which a generated script never does
+			{Kind: workload.DumpStep},
+		}
+		violation := workload.RunSteps("// header", steps)
+		if !assert.NotNil(t, violation) {
+			return
+		}
+		assert.Equal(t, workload.ValidCommands, violation.Invariant)
+		assert.Equal(t, 4, violation.Tick)
+		assert.True(t, strings.HasPrefix(violation.Script, "// header\n// Stopped at tick 4: valid commands violated"))
+		assert.True(t, strings.HasSuffix(violation.Script, "begin(T1)\nW(T1, x2, 1001)\nend(T1)\nR(T2, x2)\n"))
+	})
+
+	t.Run("RunSteps should report sites and keys which do not exist instead of panicking", func(t *testing.T) {
+		violation := workload.RunSteps("// header", []workload.Step{{Kind: workload.FailStep, Site: 11}})
+		if assert.NotNil(t, violation) {
+			assert.Equal(t, "valid commands violated at tick 1: fail(11): site 11 does not exist", violation.Error())
+		}
+		violation = workload.RunSteps("// header", []workload.Step{{Kind: workload.BeginStep, Tx: 1}, {Kind: workload.WriteStep, Tx: 1, Key: 22, Value: 1001}})
+		if assert.NotNil(t, violation) {
+			assert.Equal(t, "valid commands violated at tick 2: W(T1, x22, 1001): key x22 does not exist", violation.Error())
+		}
+	})
+
+	t.Run("RunSteps should accept a run with waits and failures", func(t *testing.T) {
+		steps := []workload.Step{
+			{Kind: workload.BeginStep, Tx: 1},
+			{Kind: workload.FailStep, Site: 4},
+			{Kind: workload.ReadStep, Tx: 1, Key: 3}, // x3 is only held by site 4, so T1 waits
+			{Kind: workload.WriteStep, Tx: 1, Key: 4, Value: 1001},
+			{Kind: workload.RecoverStep, Site: 4},
+			{Kind: workload.EndStep, Tx: 1},
+			{Kind: workload.DumpStep},
+		}
+		assert.Nil(t, workload.RunSteps("// header", steps))
+	})
+}
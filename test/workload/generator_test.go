@@ -0,0 +1,117 @@
+package test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mingyi850/repcrec/internal"
+	"github.com/mingyi850/repcrec/internal/domain"
+	"github.com/mingyi850/repcrec/internal/parser"
+	"github.com/mingyi850/repcrec/internal/workload"
+	"github.com/stretchr/testify/assert"
+)
+
+/* Runs a script through the simulation, discarding its output */
+func runScript(t *testing.T, script string) error {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	siteCoordinator := domain.CreateSiteCoordinator(10)
+	defer siteCoordinator.Close()
+	return internal.SimulationWithOptions(file, siteCoordinator, domain.CreateTransactionManager(siteCoordinator), internal.Options{Output: io.Discard})
+}
+
+func TestGenerator(t *testing.T) {
+	t.Run("Generate should be deterministic for a seed", func(t *testing.T) {
+		config := workload.DefaultConfig()
+		config.Seed = 42
+		config.FailProbability = 0.1
+		config.RecoverProbability = 0.2
+		first, err := workload.Generate(config)
+		assert.Nil(t, err)
+		second, _ := workload.Generate(config)
+		assert.Equal(t, first, second)
+		config.Seed = 43
+		other, _ := workload.Generate(config)
+		assert.NotEqual(t, first, other)
+	})
+
+	t.Run("Generated scripts should parse and run without errors", func(t *testing.T) {
+		for seed := int64(1); seed <= 50; seed++ {
+			config := workload.DefaultConfig()
+			config.Seed = seed
+			config.Transactions = 20
+			config.ReadOnlyRatio = 0.2
+			config.Distribution = workload.Zipf
+			config.FailProbability = 0.2
+			config.RecoverProbability = 0.3
+			script, err := workload.Generate(config)
+			assert.Nil(t, err)
+			_, err = parser.Parse(strings.NewReader(script))
+			assert.Nil(t, err, "seed %d", seed)
+			assert.Nil(t, runScript(t, script), "seed %d", seed)
+		}
+	})
+
+	t.Run("Generate should begin and end every transaction once", func(t *testing.T) {
+		config := workload.DefaultConfig()
+		config.Transactions = 15
+		script, _ := workload.Generate(config)
+		for tx := 1; tx <= 15; tx++ {
+			assert.Equal(t, 1, len(regexp.MustCompile(`begin(RO)?\(T`+strconv.Itoa(tx)+`\)`).FindAllString(script, -1)))
+			assert.Equal(t, 1, strings.Count(script, "end(T"+strconv.Itoa(tx)+")"))
+		}
+		assert.NotContains(t, script, "begin(T16)")
+	})
+
+	t.Run("Zipf distribution should favour the hottest key", func(t *testing.T) {
+		config := workload.DefaultConfig()
+		config.Transactions = 200
+		config.Distribution = workload.Zipf
+		config.ZipfExponent = 2
+		script, _ := workload.Generate(config)
+		counts := make(map[string]int)
+		for _, match := range regexp.MustCompile(`, (x\d+)`).FindAllStringSubmatch(script, -1) {
+			counts[match[1]]++
+		}
+		hottest, total := 0, 0
+		for _, count := range counts {
+			hottest = max(hottest, count)
+			total += count
+		}
+		assert.Greater(t, float64(hottest)/float64(total), 0.5) // 1/zeta(2) of the keys go to the hottest key
+	})
+
+	t.Run("Read-only transactions should only read", func(t *testing.T) {
+		config := workload.DefaultConfig()
+		config.ReadOnlyRatio = 1
+		config.ReadRatio = 0
+		script, _ := workload.Generate(config)
+		assert.NotContains(t, script, "W(")
+		assert.NotContains(t, script, "begin(")
+	})
+
+	t.Run("Validate should reject invalid configs", func(t *testing.T) {
+		config := workload.DefaultConfig()
+		config.ReadRatio = 1.5
+		_, err := workload.Generate(config)
+		assert.Contains(t, err.Error(), "read ratio must be between 0 and 1")
+		config = workload.DefaultConfig()
+		config.Keys = 21
+		assert.NotNil(t, config.Validate())
+		config = workload.DefaultConfig()
+		config.Distribution = "normal"
+		assert.NotNil(t, config.Validate())
+	})
+}